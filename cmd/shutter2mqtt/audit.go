@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/audit"
+)
+
+// auditCommand prints audit log entries, e.g. `shutter2mqtt audit -shutter bedroom -since 12h`.
+func auditCommand(args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	configPath := flags.String("config", "config.yaml", "config.yaml file path")
+	file := flags.String("file", "", "audit log file path, defaults to audit.path from config")
+	shutterName := flags.String("shutter", "", "show entries of a shutter only")
+	source := flags.String("source", "", "show entries of a command source only (mqtt, http, button, window_contact)")
+	since := flags.Duration("since", 0, "show entries not older than this duration")
+	limit := flags.Int("limit", 50, "show at most this many most recent entries, 0 for all")
+	asJSON := flags.Bool("json", false, "print entries as JSON lines")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	path := *file
+	if path == "" {
+		if err := loadConfig(*configPath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "invalid config %s:\n%s\n", *configPath, err)
+			return 1
+		}
+		path = Cfg.Audit.Path
+	}
+
+	filter := audit.Filter{Shutter: *shutterName, Source: *source, Limit: *limit}
+	if *since > 0 {
+		filter.Since = time.Now().Add(-*since)
+	}
+
+	entries, err := audit.Read(path, filter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSHUTTER\tCOMMAND\tSOURCE\tORIGIN\tPOSITION\tRELAY\tRUN TIME\tOUTCOME")
+	for _, e := range entries {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%d -> %d (%d)\t%s\t%.1fs\t%s\n",
+			e.Time.Local().Format(time.RFC3339),
+			e.Shutter,
+			e.Command,
+			e.Source,
+			e.Origin,
+			e.StartPosition,
+			e.EndPosition,
+			e.Target,
+			e.Relay,
+			e.RunTime,
+			e.Outcome,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+)
+
+// calibrateCommand calibrates a shutter of a running shutter2mqtt over MQTT, e.g. `shutter2mqtt calibrate -shutter bedroom`.
+// Enter is pressed once the shutter reached the bottom, then the top.
+func calibrateCommand(args []string) int {
+	flags := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	configPath := flags.String("config", "config.yaml", "config.yaml file path")
+	shutterName := flags.String("shutter", "", "name of the shutter to calibrate")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *shutterName == "" {
+		fmt.Fprintln(os.Stderr, "usage: shutter2mqtt calibrate -shutter <name> [-config config.yaml]")
+		return 2
+	}
+
+	if err := loadConfig(*configPath); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "invalid config %s:\n%s\n", *configPath, err)
+		return 1
+	}
+
+	client := paho.NewClient(pahoOptsFromConfig(Cfg.MQTT).SetClientID(Cfg.MQTT.ClientID + "-calibrate").SetAutoReconnect(false))
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		fmt.Fprintln(os.Stderr, token.Error())
+		return 1
+	}
+	defer client.Disconnect(250)
+
+	// retained messages are from before the calibration
+	states := make(chan string, 10)
+	calibrated := make(chan []byte, 1)
+	failed := make(chan string, 1)
+	subscriptions := map[string]paho.MessageHandler{
+		mqtt.StateTopic(*shutterName): func(_ paho.Client, m paho.Message) {
+			if m.Retained() {
+				return
+			}
+			select { // only closing and opening are prompted for, not every state has to be seen
+			case states <- string(m.Payload()):
+			default:
+			}
+		},
+		mqtt.CalibrationTopic(*shutterName): func(_ paho.Client, m paho.Message) {
+			if !m.Retained() {
+				calibrated <- m.Payload()
+			}
+		},
+		mqtt.ErrorTopic(*shutterName): func(_ paho.Client, m paho.Message) {
+			select {
+			case failed <- string(m.Payload()):
+			default:
+			}
+		},
+	}
+	for topic, handler := range subscriptions {
+		if token := client.Subscribe(topic, 0, handler); token.Wait() && token.Error() != nil {
+			fmt.Fprintln(os.Stderr, token.Error())
+			return 1
+		}
+	}
+
+	command := func(cmd string) error {
+		token := client.Publish(mqtt.CalibrateTopic(*shutterName), 0, false, cmd)
+		if !token.WaitTimeout(time.Second) {
+			return fmt.Errorf("%s command publish timed out", cmd)
+		}
+		return token.Error()
+	}
+
+	entered := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			entered <- struct{}{}
+		}
+	}()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, syscall.SIGTERM, syscall.SIGINT)
+
+	if err := command(mqtt.CalibrateStartCmd); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s: opening fully, press Enter once it is fully open or wait\n", *shutterName)
+
+	var closed bool
+	for {
+		select {
+		case state := <-states:
+			switch {
+			case state == shutter.ShutterClosingState:
+				closed = true
+				fmt.Println("closing, press Enter once it reached the bottom")
+			case state == shutter.ShutterOpeningState && closed:
+				fmt.Println("opening, press Enter once it reached the top")
+			}
+		case <-entered:
+			if err := command(mqtt.CalibrateReachedCmd); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		case payload := <-calibrated:
+			c, err := mqtt.UnmarshalCalibration(payload)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			fmt.Printf("calibrated: time_to_open %s, time_to_close %s, they override the config\n", c.TimeToOpen, c.TimeToClose)
+			return 0
+		case err := <-failed:
+			fmt.Fprintf(os.Stderr, "calibration failed: %s\n", err)
+			return 1
+		case <-interrupted:
+			if err := command(mqtt.CalibrateCancelCmd); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			fmt.Fprintln(os.Stderr, "calibration canceled")
+			return 1
+		}
+	}
+}
@@ -2,19 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/cristalhq/aconfig"
 	paho "github.com/eclipse/paho.mqtt.golang"
-	"github.com/jkaflik/shutter2mqtt/internal/mqtt"
-	"github.com/jkaflik/shutter2mqtt/internal/shutter"
-	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
-	"github.com/racerxdl/go-mcp23017"
+	"github.com/jkaflik/shutter2mqtt/internal/audit"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"github.com/jkaflik/shutter2mqtt/pkg/bridge"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
+// cfgWiredRelaySetPin is the pin of an input.
 type cfgWiredRelaySetPin struct {
 	Kind string `yaml:"kind"`
 
@@ -23,46 +26,69 @@ type cfgWiredRelaySetPin struct {
 	Mcp23017 int `yaml:"mcp23017"`
 }
 
-type cfgRelay struct {
-	Kind string `yaml:"kind"`
-
-	Pin          cfgWiredRelaySetPin `yaml:"pin"`
-	NormalClosed bool                `yaml:"normal_closed"`
-}
-
 type cfgShutterMQTTBridge struct {
 	Metadata map[string]interface{} `yaml:"metadata"`
+	// DebugTopics publishes shutter2mqtt/<name>/debug/true_position of simulated shutters.
+	DebugTopics bool `yaml:"debug_topics"`
 }
 
-type cfgShutterDriverRelays struct {
-	Up   cfgRelay `yaml:"up"`
-	Down cfgRelay `yaml:"down"`
-
-	FullOpenPosition  int           `yaml:"full_open_position" default:"100"`
-	FullClosePosition int           `yaml:"full_close_position" default:"0"`
-	TimeToClose       time.Duration `yaml:"time_to_close" default:"1m"`
+type cfgWindowContact struct {
+	Topic               string `yaml:"topic"`
+	OpenPayload         string `yaml:"open_payload"`
+	ClosedPayload       string `yaml:"closed_payload"`
+	Mode                string `yaml:"mode"`
+	VentilationPosition int    `yaml:"ventilation_position"`
 }
 
-type cfgShutterDriver struct {
-	Relays cfgShutterDriverRelays `yaml:"relays"`
+// cfgPowerFeedback detects end-stops and obstructions from the power drawn by the motor.
+// Readings in watts come from Topic, or from the driver when empty, e.g. a simulated one.
+type cfgPowerFeedback struct {
+	Topic            string  `yaml:"topic"`
+	Threshold        float64 `yaml:"threshold"`
+	EndStopTolerance int     `yaml:"end_stop_tolerance"`
 }
 
 type cfgShutter struct {
 	Name string `yaml:"name"`
 	Kind string `yaml:"kind"`
 
-	MQTTBridge cfgShutterMQTTBridge `yaml:"mqtt_bridge"`
+	MQTTBridge    cfgShutterMQTTBridge `yaml:"mqtt_bridge"`
+	WindowContact *cfgWindowContact    `yaml:"window_contact"`
+	PowerFeedback *cfgPowerFeedback    `yaml:"power_feedback"`
+
+	// Driver holds driver config sections by shutter kind, only the one of Kind is used.
+	Driver map[string]driver.Config `yaml:"driver"`
+}
+
+type cfgInput struct {
+	Name string              `yaml:"name"`
+	Pin  cfgWiredRelaySetPin `yaml:"pin"`
 
-	Driver cfgShutterDriver `yaml:"driver"`
+	PullUp    bool `yaml:"pull_up"`
+	ActiveLow bool `yaml:"active_low"`
+
+	Shutter        string `yaml:"shutter"`
+	Direction      string `yaml:"direction"`
+	PresetPosition *int   `yaml:"preset_position"`
+
+	LongPress   time.Duration `yaml:"long_press"`
+	DoublePress time.Duration `yaml:"double_press"`
 }
 
 type cfgDrivers struct {
+	Input struct {
+		PollInterval time.Duration `yaml:"poll_interval" default:"20ms"`
+	} `yaml:"input"`
 	Relay struct {
 		Pool     int `yaml:"pool" default:"0"`
+		Watchdog struct {
+			MaxOnTime time.Duration `yaml:"max_on_time" default:"5m"`
+			Interval  time.Duration `yaml:"interval" default:"1s"`
+		} `yaml:"watchdog"`
 		Mcp23017 map[int]struct {
 			Bus          uint8 `yaml:"bus" default:"1"`
 			DeviceNumber uint8 `yaml:"device_number" default:"0"`
-		} `yaml:""`
+		} `yaml:"mcp23017"`
 	} `yaml:"relay"`
 }
 
@@ -70,7 +96,7 @@ type cfgMQTT struct { // todo more fields
 	ClientID string `yaml:"client_id" default:"shutter2mqtt" env:"CLIENT_ID"`
 	Broker   string `yaml:"broker" default:"127.0.0.1:1883" env:"BROKER"`
 	Username string `yaml:"username" env:"USERNAME"`
-	Password string `yaml:"password" env:"PASSWORD"`
+	Password string `yaml:"password" env:"PASSWORD" secret:"true"`
 }
 
 type cfgHASS struct {
@@ -78,161 +104,216 @@ type cfgHASS struct {
 	TopicPrefix string `yaml:"topic_prefix" default:"homeassistant" env:"TOPIC_PREFIX"`
 }
 
-var Cfg struct {
-	LogLevel string `yaml:"log_level" default:"info" env:"LOG_LEVEL"`
+type cfgHTTP struct {
+	Enabled bool   `yaml:"enabled" default:"false" env:"ENABLED"`
+	Listen  string `yaml:"listen" default:":8080" env:"LISTEN"`
+}
+
+type cfgAudit struct {
+	Enabled   bool          `yaml:"enabled" default:"false" env:"ENABLED"`
+	Path      string        `yaml:"path" default:"audit.jsonl" env:"PATH"`
+	Retention time.Duration `yaml:"retention" default:"2160h" env:"RETENTION"`
+}
+
+type cfgLogFile struct {
+	Path       string `yaml:"path" env:"PATH"`
+	MaxSizeMB  int    `yaml:"max_size_mb" default:"10" env:"MAX_SIZE_MB"`
+	MaxBackups int    `yaml:"max_backups" default:"3" env:"MAX_BACKUPS"`
+}
+
+type config struct {
+	LogLevel  string     `yaml:"log_level" default:"info" env:"LOG_LEVEL"`
+	LogFormat string     `yaml:"log_format" default:"text" env:"LOG_FORMAT"`
+	LogFile   cfgLogFile `yaml:"log_file" env:"LOG_FILE"`
 
 	MQTT cfgMQTT `yaml:"mqtt" env:"MQTT"`
 	HASS cfgHASS `yaml:"hass" env:"HASS"`
+	HTTP cfgHTTP `yaml:"http" env:"HTTP"`
+
+	Audit cfgAudit `yaml:"audit" env:"AUDIT"`
 
 	Shutters []cfgShutter `yaml:"shutters"`
+	Inputs   []cfgInput   `yaml:"inputs"`
 
 	Drivers cfgDrivers `yaml:"drivers"`
 }
 
-var configLoader = aconfig.LoaderFor(&Cfg, aconfig.Config{
-	EnvPrefix: "S2M",
-})
-
-var relaysPool chan struct{}
+// Cfg is the loaded config, swapped under CfgL by a reload.
+var (
+	Cfg  config
+	CfgL sync.Mutex
+)
 
-func loadConfigFromYamlFile(filename string) {
-	f, err := os.Open(filename)
-	if err != nil {
-		logrus.Error(err)
-		return
-	}
-	defer f.Close()
+var collector = bridge.NewMetrics()
 
-	if err := yaml.NewDecoder(f).Decode(&Cfg); err != nil {
-		logrus.Fatal(err)
-		return
-	}
+var auditLog *audit.Log
 
-	if Cfg.Drivers.Relay.Pool > 0 {
-		relaysPool = make(chan struct{}, Cfg.Drivers.Relay.Pool)
+// loadConfig reads the config of filename into Cfg. Cfg is kept when the config is invalid.
+// A missing file is not an error to other config sources, Cfg gets defaults and environment variables then.
+func loadConfig(filename string) error {
+	cfg, err := readConfig(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
 	}
-}
 
-func pahoOptsFromConfig() *paho.ClientOptions {
-	return paho.NewClientOptions().
-		SetClientID(Cfg.MQTT.ClientID).
-		AddBroker(Cfg.MQTT.Broker).
-		SetUsername(Cfg.MQTT.Username).
-		SetPassword(Cfg.MQTT.Password).
-		SetConnectTimeout(time.Second).
-		SetPingTimeout(time.Second).
-		SetWriteTimeout(time.Second).
-		SetAutoReconnect(true)
+	CfgL.Lock()
+	Cfg = cfg
+	CfgL.Unlock()
+	return err
 }
 
-func shutter2mqttFromConfig(ctx context.Context, client paho.Client) (bridges []*mqtt.Bridge) {
-	for _, cfg := range Cfg.Shutters {
-		s := shutterFromConfig(ctx, cfg)
-		bridge, err := mqtt.NewBridge(client, s)
-		if err != nil {
-			logrus.Fatal(err)
-			continue
-		}
-		if err := bridge.SetMetadata(cfg.MQTTBridge.Metadata); err != nil {
-			logrus.Fatal(err)
-			continue
-		}
-		bridges = append(bridges, bridge)
+// readConfig merges defaults, the YAML file and S2M_ prefixed environment variables,
+// each one overriding the previous, and validates the result.
+// Problems found are returned all at once as configErrors. A missing file is not validated.
+func readConfig(filename string) (config, error) {
+	var cfg config
+	defaultsLoader := aconfig.LoaderFor(&cfg, aconfig.Config{
+		SkipEnv:   true,
+		SkipFiles: true,
+		SkipFlags: true,
+	})
+	if err := defaultsLoader.Load(); err != nil {
+		return cfg, err
 	}
 
-	return bridges
-}
-
-func shutterFromConfig(ctx context.Context, cfg cfgShutter) shutter.Shutter {
-	if cfg.Kind == "relays" {
-		return relay.NewRelaysShutter(
-			cfg.Name,
-			relayFromConfig(ctx, cfg.Driver.Relays.Up),
-			relayFromConfig(ctx, cfg.Driver.Relays.Down),
-			cfg.Driver.Relays.FullOpenPosition,
-			cfg.Driver.Relays.FullClosePosition,
-			cfg.Driver.Relays.TimeToClose,
-		)
+	root, err := loadConfigFromYamlFile(filename, &cfg)
+	var errs configErrors
+	if err != nil && !errors.As(err, &errs) && !os.IsNotExist(err) {
+		return cfg, err
 	}
 
-	logrus.Fatalf("%s is not supported shutter kind", cfg.Kind)
-	return nil
-}
-
-func relayFromConfig(ctx context.Context, cfg cfgRelay) relay.Relay {
-	if cfg.Kind == "wired" {
-		return wrapRelayWithPoolProxy(&relay.Wired{
-			Pin:          wiredRelaySetPinFromConfig(ctx, cfg.Pin),
-			NormalClosed: cfg.NormalClosed,
-		})
+	envLoader := aconfig.LoaderFor(&cfg, aconfig.Config{
+		EnvPrefix:    "S2M",
+		SkipDefaults: true,
+		SkipFiles:    true,
+		SkipFlags:    true,
+	})
+	if err := envLoader.Load(); err != nil {
+		return cfg, err
 	}
-
-	if cfg.Kind == "dumb" {
-		return wrapRelayWithPoolProxy(&relay.Dumb{Name: cfg.Kind})
+	if os.IsNotExist(err) {
+		return cfg, err
 	}
 
-	logrus.Fatalf("%s is not supported relay kind", cfg.Kind)
-	return nil
+	errs = append(errs, validateConfig(&cfg, root)...)
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
 }
 
-func wrapRelayWithPoolProxy(r relay.Relay) relay.Relay {
-	if relaysPool == nil {
-		return r
+// loadConfigFromYamlFile decodes the file over cfg, unknown keys and type errors are returned as configErrors.
+func loadConfigFromYamlFile(filename string, cfg *config) (*yaml.Node, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
 	}
 
-	return relay.NewPoolProxy(r, relaysPool)
-}
-
-func wiredRelaySetPinFromConfig(ctx context.Context, cfg cfgWiredRelaySetPin) relay.SetPin {
-	if cfg.Kind == "mcp23017" {
-		device := mcp23017DeviceFromConfigByID(ctx, cfg.Mcp23017)
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind == 0 { // empty file
+		return &root, nil
+	}
 
-		p, err := relay.NewMcp23017Pin(device, cfg.Pin)
-		if err != nil {
-			logrus.Fatal(err)
+	errs := checkUnknownKeys(&root, reflect.TypeOf(cfg), nil)
+	if err := root.Decode(cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, err
+		}
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, configError{msg: msg})
 		}
-		return p
 	}
 
-	logrus.Fatalf("%s is not supported wired relay set pin kind", cfg.Kind)
-	return nil
+	if len(errs) > 0 {
+		return &root, errs
+	}
+	return &root, nil
 }
 
-var mcpDevices = map[int]*mcp23017.Device{}
+func pahoOptsFromConfig(cfg cfgMQTT) *paho.ClientOptions {
+	return paho.NewClientOptions().
+		SetClientID(cfg.ClientID).
+		AddBroker(cfg.Broker).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(time.Second).
+		SetPingTimeout(time.Second).
+		SetWriteTimeout(time.Second).
+		SetAutoReconnect(true)
+}
 
-func mcp23017DeviceFromConfigByID(ctx context.Context, id int) *mcp23017.Device {
-	if Cfg.Drivers.Relay.Mcp23017 == nil {
-		logrus.Fatal("drivers.relay.mcp23017 not defined")
+// appConfigFromConfig returns the config of the app running shutters, inputs and relays of cfg.
+// Shutters record movements to the audit log, when opened.
+func appConfigFromConfig(cfg config) bridge.Config {
+	c := bridge.Config{
+		MQTT:                  pahoOptsFromConfig(cfg.MQTT),
+		RelayPool:             cfg.Drivers.Relay.Pool,
+		RelayMaxOnTime:        cfg.Drivers.Relay.Watchdog.MaxOnTime,
+		RelayWatchdogInterval: cfg.Drivers.Relay.Watchdog.Interval,
+		InputPollInterval:     cfg.Drivers.Input.PollInterval,
+		Mcp23017:              map[int]bridge.Mcp23017{},
+		Metrics:               collector,
 	}
-
-	cfg, found := Cfg.Drivers.Relay.Mcp23017[id]
-	if !found {
-		logrus.Fatalf("%d is not valid defined drivers.relay.mcp23017", id)
-		return nil
+	if cfg.HASS.Enabled {
+		c.HomeAssistantTopicPrefix = cfg.HASS.TopicPrefix
+	}
+	if auditLog != nil {
+		c.OnMovement = auditLog.Record
+	}
+	for id, dev := range cfg.Drivers.Relay.Mcp23017 {
+		c.Mcp23017[id] = bridge.Mcp23017{Bus: dev.Bus, DeviceNumber: dev.DeviceNumber}
 	}
 
-	dev := mcpDevices[id]
-	if dev == nil {
-		var err error
-		dev, err = mcp23017.Open(cfg.Bus, cfg.DeviceNumber)
-		if err != nil {
-			logrus.Fatal(err)
+	for _, s := range cfg.Shutters {
+		sc := bridge.Shutter{
+			Name:        s.Name,
+			Kind:        s.Kind,
+			Driver:      s.Driver[s.Kind],
+			Metadata:    s.MQTTBridge.Metadata,
+			DebugTopics: s.MQTTBridge.DebugTopics,
 		}
-		go func() {
-			<-ctx.Done()
-			if err := dev.Close(); err != nil {
-				logrus.Errorf("mcp23017: close failed %s", err)
-				return
+		if wc := s.WindowContact; wc != nil {
+			sc.WindowContact = &bridge.WindowContact{
+				Topic:               wc.Topic,
+				OpenPayload:         wc.OpenPayload,
+				ClosedPayload:       wc.ClosedPayload,
+				Mode:                wc.Mode,
+				VentilationPosition: wc.VentilationPosition,
 			}
-
-			logrus.Infof("mcp23017: close")
-		}()
-		if err := dev.Reset(); err != nil {
-			logrus.Fatal(err)
 		}
+		if pf := s.PowerFeedback; pf != nil {
+			sc.PowerFeedback = &bridge.PowerFeedback{Topic: pf.Topic, Threshold: pf.Threshold, EndStopTolerance: pf.EndStopTolerance}
+		}
+		c.Shutters = append(c.Shutters, sc)
+	}
 
-		mcpDevices[id] = dev
+	for _, in := range cfg.Inputs {
+		c.Inputs = append(c.Inputs, bridge.Input{
+			Name:           in.Name,
+			Mcp23017:       in.Pin.Mcp23017,
+			Pin:            in.Pin.Pin,
+			PullUp:         in.PullUp,
+			ActiveLow:      in.ActiveLow,
+			Shutter:        in.Shutter,
+			Direction:      in.Direction,
+			PresetPosition: in.PresetPosition,
+			LongPress:      in.LongPress,
+			DoublePress:    in.DoublePress,
+		})
+	}
+	return c
+}
+
+func auditLogFromConfig(ctx context.Context) *audit.Log {
+	l, err := audit.Open(Cfg.Audit.Path, Cfg.Audit.Retention)
+	if err != nil {
+		logrus.Fatal(err)
 	}
+	go l.PruneEvery(ctx, time.Hour)
 
-	return dev
+	return l
 }
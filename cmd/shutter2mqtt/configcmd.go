@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// configCommand checks or prints the config, e.g. `shutter2mqtt config check -config /etc/shutter2mqtt/config.yaml`.
+func configCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "check" && args[0] != "dump") {
+		fmt.Fprintln(os.Stderr, "usage: shutter2mqtt config check|dump [-config config.yaml]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("config "+args[0], flag.ContinueOnError)
+	configPath := flags.String("config", "config.yaml", "config.yaml file path")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if err := loadConfig(*configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config %s:\n%s\n", *configPath, err)
+		return 1
+	}
+
+	if args[0] == "check" {
+		fmt.Printf("%s: ok\n", *configPath)
+		return 0
+	}
+
+	if err := dumpConfig(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// dumpConfig writes the effective config as YAML, with secrets redacted.
+func dumpConfig(w io.Writer) error {
+	cfg := Cfg
+	redactSecrets(reflect.ValueOf(&cfg).Elem())
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// redactSecrets replaces set string fields tagged `secret:"true"`.
+// It descends into nested structs only, so a copy of Cfg can be redacted without touching shared slices or maps.
+func redactSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case t.Field(i).Tag.Get("secret") == "true" && f.Kind() == reflect.String && f.String() != "":
+			f.SetString(redacted)
+		case f.Kind() == reflect.Struct:
+			redactSecrets(f)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/systemd"
+	"github.com/jkaflik/shutter2mqtt/pkg/bridge"
+	"github.com/sirupsen/logrus"
+)
+
+// notifySystemd reports readiness once all readiness checks pass
+// and keeps the systemd watchdog fed for as long as liveness checks pass.
+func notifySystemd(ctx context.Context, checker *bridge.HealthChecker) {
+	go func() {
+		every := time.NewTicker(time.Second)
+		defer every.Stop()
+
+		for checker.Ready() != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-every.C:
+			}
+		}
+
+		logrus.Info("ready")
+		if err := systemd.Notify(systemd.Ready); err != nil {
+			logrus.Errorf("systemd notify failed: %s", err)
+		}
+	}()
+
+	interval, err := systemd.WatchdogInterval()
+	if err != nil {
+		logrus.Errorf("systemd watchdog: %s", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	go func() {
+		every := time.NewTicker(interval / 2)
+		defer every.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-every.C:
+				if err := checker.Live(); err != nil {
+					logrus.Errorf("liveness check failed, skipping systemd watchdog notification: %s", err)
+					continue
+				}
+				if err := systemd.Notify(systemd.Watchdog); err != nil {
+					logrus.Errorf("systemd notify failed: %s", err)
+				}
+			}
+		}
+	}()
+}
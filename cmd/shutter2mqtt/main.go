@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"flag"
-	"log"
 	"os"
 	"os/signal"
-	"time"
+	"syscall"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
-	"github.com/jkaflik/shutter2mqtt/internal/mqtt"
+	"github.com/jkaflik/shutter2mqtt/internal/api"
+	"github.com/jkaflik/shutter2mqtt/internal/logging"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	_ "github.com/jkaflik/shutter2mqtt/internal/shutter/driver/simulated" // registers simulated shutter kind
+	"github.com/jkaflik/shutter2mqtt/internal/systemd"
+	"github.com/jkaflik/shutter2mqtt/pkg/bridge"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,66 +23,137 @@ func main() {
 		FullTimestamp: true,
 	})
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "audit":
+			os.Exit(auditCommand(os.Args[2:]))
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		case "calibrate":
+			os.Exit(calibrateCommand(os.Args[2:]))
+		}
+	}
+
 	configPath := flag.String("config", "config.yaml", "config.yaml file path")
+	flag.Parse()
 
-	if err := configLoader.Load(); err != nil {
-		logrus.Fatal(err)
+	if err := loadConfig(*configPath); os.IsNotExist(err) {
+		logrus.Error(err)
+	} else if err != nil {
+		logrus.Fatalf("invalid config %s:\n%s", *configPath, err)
 	}
-	loadConfigFromYamlFile(*configPath)
 
-	level, err := logrus.ParseLevel(Cfg.LogLevel)
-	if err != nil {
-		logrus.Fatal(err)
-	}
-	logrus.SetLevel(level)
+	setupLogging()
 
 	ctx, cancel := context.WithCancel(context.Background())
-	var bridges []*mqtt.Bridge
-	cfg := pahoOptsFromConfig()
-	cfg.OnConnect = func(m paho.Client) {
-		logrus.Info("MQTT broker connected")
-		subscribe(ctx, m, bridges)
+	if Cfg.Audit.Enabled {
+		auditLog = auditLogFromConfig(ctx)
+	}
+
+	app, reload := newApp(*configPath)
+
+	var srv *api.Server
+	if Cfg.HTTP.Enabled {
+		srv = api.NewServer(ctx, nil)
+		app.OnChange(srv.AddShutter, func(s shutter.Shutter) { srv.RemoveShutter(s.Name()) })
+		srv.Handle("/metrics", collector)
+		srv.Handle("/healthz", app.Health().LivenessHandler())
+		srv.Handle("/readyz", app.Health().ReadinessHandler())
+		if auditLog != nil {
+			srv.Handle("/audit", auditLog.Handler())
+		}
 	}
-	cfg.OnConnectionLost = func(_ paho.Client, err error) {
-		logrus.Errorf("MQTT broker connection lost: %s", err.Error())
+
+	done := make(chan error, 1)
+	go func() { done <- app.Run(ctx) }()
+	select {
+	case <-app.Ready():
+	case err := <-done:
+		logrus.Fatal(err)
 	}
 
-	m := paho.NewClient(cfg)
-	if token := m.Connect(); token.Wait() && token.Error() != nil {
-		logrus.Fatal(token.Error())
+	notifySystemd(ctx, app.Health())
+	if srv != nil {
+		go func() {
+			if err := srv.ListenAndServe(ctx, Cfg.HTTP.Listen); err != nil {
+				logrus.Fatal(err)
+			}
+		}()
 	}
 
-	bridges = shutter2mqttFromConfig(ctx, m)
-	subscribe(ctx, m, bridges)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			logrus.Infof("%s received, shutting down", sig)
+			break
+		}
+
+		logrus.Infof("%s received, reloading config", sig)
+		if err := reload.Reload(); err != nil {
+			logrus.Errorf("config reload failed:\n%s", err)
+		}
+	}
+	if err := systemd.Notify(systemd.Stopping); err != nil {
+		logrus.Errorf("systemd notify failed: %s", err)
+	}
 
 	go func() {
-		oscall := <-c
-		log.Printf("system call:%+v", oscall)
-		cancel()
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				continue
+			}
+			logrus.Warnf("%s received, forcing exit", sig)
+			if err := app.SafeState(); err != nil {
+				logrus.Error(err)
+			}
+			os.Exit(1)
+		}
 	}()
 
-	<-ctx.Done()
+	cancel()
+	if err := <-done; err != nil {
+		logrus.Error(err)
+	}
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			logrus.Errorf("shutdown: audit log close failed: %s", err)
+		}
+	}
+}
 
-	cleanupTime := time.Second
-	logrus.Infof("cleanups for %s...", cleanupTime.String())
-	time.Sleep(cleanupTime)
+// newApp creates the app of the loaded config, configPath is reloaded on the MQTT reload command.
+func newApp(configPath string) (*bridge.App, *reloader) {
+	cfg := appConfigFromConfig(Cfg)
+	reload := &reloader{path: configPath}
+	cfg.MQTT.SetOnConnectHandler(func(c paho.Client) {
+		if err := reload.Subscribe(c); err != nil {
+			logrus.Error(err)
+		}
+	})
+	reload.app = bridge.New(cfg)
+	return reload.app, reload
 }
 
-func subscribe(ctx context.Context, m paho.Client, bridges []*mqtt.Bridge) {
-	for _, bridge := range bridges {
-		if Cfg.HASS.Enabled {
-			entity := mqtt.NewHACoverFromMQTTBridge(bridge)
-			if err := mqtt.PublishHAAutoDiscovery(m, Cfg.HASS.TopicPrefix, entity); err != nil {
-				logrus.Fatal(err)
-			}
+func setupLogging() {
+	formatter, err := logging.Formatter(Cfg.LogFormat)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.SetFormatter(formatter)
 
-		}
+	level, err := logrus.ParseLevel(Cfg.LogLevel)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.SetLevel(level)
 
-		if err := bridge.Subscribe(ctx); err != nil {
-			logrus.Error(err)
+	if Cfg.LogFile.Path != "" {
+		f, err := logging.OpenRotatingFile(Cfg.LogFile.Path, int64(Cfg.LogFile.MaxSizeMB)<<20, Cfg.LogFile.MaxBackups)
+		if err != nil {
+			logrus.Fatal(err)
 		}
+		logrus.SetOutput(f)
 	}
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt/mqtttest"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/stretchr/testify/assert"
+)
+
+const e2eShutter = `
+  - kind: relays
+    name: %s
+    driver:
+      relays:
+        up: {kind: dumb}
+        down: {kind: dumb}
+        full_open_position: 100
+        time_to_close: 200ms
+`
+
+func TestEndToEnd(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	broker.Publish("shutter2mqtt/kitchen/position", "0", true)
+	messages := broker.Subscribe("#")
+
+	saved := Cfg
+	t.Cleanup(func() { Cfg = saved })
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(t *testing.T, shutters ...string) {
+		t.Helper()
+
+		config := fmt.Sprintf("mqtt:\n  broker: %s\n  client_id: e2e\nshutters:", strings.TrimPrefix(broker.URL(), "tcp://"))
+		for _, name := range shutters {
+			config += fmt.Sprintf(e2eShutter, name)
+		}
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(t, "kitchen")
+	if err := loadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	app, _ := newApp(path)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- app.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	select {
+	case <-app.Ready():
+	case err := <-done:
+		t.Fatalf("app stopped: %v", err)
+	case <-time.After(mqtttest.DefaultTimeout):
+		t.Fatal("app not ready")
+	}
+
+	t.Run("commands", func(t *testing.T) {
+		_, found := broker.Retained("homeassistant/cover/shutters2mqtt/kitchen/config")
+		assert.True(t, found, "discovery published")
+		broker.Publish("shutter2mqtt/kitchen/set", "open", false)
+		messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.ShutterOpeningState)
+		messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.ShutterOpenState)
+		broker.WaitForRetained(t, "shutter2mqtt/kitchen/position", "100")
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		writeConfig(t, "kitchen", "patio")
+		broker.Publish(reloadTopic, "", false)
+		messages.WaitFor(t, reloadResultTopic, "ok")
+		assert.NotNil(t, app.Shutter("patio"))
+	})
+
+	t.Run("invalid reload keeps config", func(t *testing.T) {
+		writeConfig(t, "kitchen", "kitchen")
+		broker.Publish(reloadTopic, "", false)
+		assert.Contains(t, messages.NextOn(t, reloadResultTopic).Payload, `duplicate shutter name "kitchen"`)
+		assert.NotNil(t, app.Shutter("patio"))
+		CfgL.Lock()
+		assert.Len(t, Cfg.Shutters, 2)
+		CfgL.Unlock()
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/pkg/bridge"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	reloadTopic       = "shutter2mqtt/reload"
+	reloadResultTopic = "shutter2mqtt/reload/result"
+)
+
+// reloader applies config file changes to the running app.
+// Shutters, inputs and the log level are reloaded, other sections require a restart.
+type reloader struct {
+	path string
+	app  *bridge.App
+
+	l sync.Mutex
+}
+
+// Reload applies the config file to the app. An invalid config is rejected, the running one is kept then.
+func (r *reloader) Reload() error {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	next, err := readConfig(r.path)
+	if err != nil {
+		return err
+	}
+	level, err := logrus.ParseLevel(next.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	CfgL.Lock()
+	current := Cfg
+	warnRestartRequired(current, next)
+	Cfg.Shutters = next.Shutters
+	Cfg.Inputs = next.Inputs
+	Cfg.Drivers.Relay.Mcp23017 = next.Drivers.Relay.Mcp23017 // new devices, opened ones keep their settings
+	Cfg.LogLevel = next.LogLevel
+	applied := Cfg
+	CfgL.Unlock()
+
+	logrus.SetLevel(level)
+	if err := r.app.Reload(appConfigFromConfig(applied)); err != nil {
+		return err
+	}
+
+	logrus.Info("config reloaded")
+	return nil
+}
+
+// Subscribe subscribes the MQTT reload command topic, any payload triggers a reload.
+func (r *reloader) Subscribe(client paho.Client) error {
+	token := client.Subscribe(reloadTopic, 0, func(c paho.Client, msg paho.Message) {
+		// not within the handler, reload waits for MQTT acknowledgements
+		go r.reloadAndReport(c)
+	})
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (r *reloader) reloadAndReport(client paho.Client) {
+	result := "ok"
+	if err := r.Reload(); err != nil {
+		logrus.Errorf("config reload failed:\n%s", err)
+		result = err.Error()
+	}
+
+	if token := client.Publish(reloadResultTopic, 0, false, result); token.Wait() && token.Error() != nil {
+		logrus.Errorf("MQTT reload result publish failed: %s", token.Error())
+	}
+}
+
+func warnRestartRequired(current, next config) {
+	sections := map[string]bool{
+		"log_format":             !reflect.DeepEqual(current.LogFormat, next.LogFormat),
+		"log_file":               !reflect.DeepEqual(current.LogFile, next.LogFile),
+		"mqtt":                   !reflect.DeepEqual(current.MQTT, next.MQTT),
+		"hass":                   !reflect.DeepEqual(current.HASS, next.HASS),
+		"http":                   !reflect.DeepEqual(current.HTTP, next.HTTP),
+		"audit":                  !reflect.DeepEqual(current.Audit, next.Audit),
+		"drivers.input":          !reflect.DeepEqual(current.Drivers.Input, next.Drivers.Input),
+		"drivers.relay.pool":     current.Drivers.Relay.Pool != next.Drivers.Relay.Pool,
+		"drivers.relay.watchdog": !reflect.DeepEqual(current.Drivers.Relay.Watchdog, next.Drivers.Relay.Watchdog),
+	}
+	for section, changed := range sections {
+		if changed {
+			logrus.Warnf("config reload: %s changes require a restart", section)
+		}
+	}
+}
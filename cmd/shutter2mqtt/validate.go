@@ -0,0 +1,487 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jkaflik/shutter2mqtt/internal/input"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"gopkg.in/yaml.v3"
+)
+
+type configError struct {
+	line int
+	path string
+	msg  string
+}
+
+func (e configError) Error() string {
+	switch {
+	case e.path == "":
+		return e.msg
+	case e.line > 0:
+		return fmt.Sprintf("line %d: %s: %s", e.line, e.path, e.msg)
+	default:
+		return fmt.Sprintf("%s: %s", e.path, e.msg)
+	}
+}
+
+// configErrors aggregates all problems found in a config, so they can be fixed at once.
+type configErrors []configError
+
+func (e configErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// configPath points to a config value, elements are mapping keys (string) or sequence indexes (int).
+type configPath []interface{}
+
+func (p configPath) String() string {
+	var b strings.Builder
+	for _, e := range p {
+		switch e := e.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", e)
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			fmt.Fprint(&b, e)
+		}
+	}
+	return b.String()
+}
+
+// line returns the line of the value at path, or of its closest parent defined in the file.
+func (p configPath) line(root *yaml.Node) int {
+	n, _ := p.node(root)
+	if n == nil {
+		return 0
+	}
+	return n.Line
+}
+
+// node returns the value at path, or its closest parent defined in the file and false.
+func (p configPath) node(root *yaml.Node) (*yaml.Node, bool) {
+	n := root
+	if n == nil {
+		return nil, false
+	}
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	for _, e := range p {
+		next := configPathNode(n, e)
+		if next == nil {
+			return n, false
+		}
+		n = next
+	}
+	return n, true
+}
+
+func configPathNode(n *yaml.Node, e interface{}) *yaml.Node {
+	switch n.Kind {
+	case yaml.MappingNode:
+		key := fmt.Sprint(e)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				return n.Content[i+1]
+			}
+		}
+	case yaml.SequenceNode:
+		if i, ok := e.(int); ok && i < len(n.Content) {
+			return n.Content[i]
+		}
+	}
+	return nil
+}
+
+// checkUnknownKeys reports mapping keys not matching any field of the config type.
+func checkUnknownKeys(n *yaml.Node, t reflect.Type, path configPath) (errs configErrors) {
+	if n.Kind == yaml.DocumentNode {
+		for _, c := range n.Content {
+			errs = append(errs, checkUnknownKeys(c, t, path)...)
+		}
+		return errs
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(driver.Config{}) { // checked once the driver is known, see configValidator.decodeDriver
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := yamlKey(f); name != "" {
+				fields[name] = f.Type
+			}
+		}
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			ft, found := fields[key.Value]
+			if !found {
+				errs = append(errs, configError{line: key.Line, path: append(path, key.Value).String(), msg: "unknown key"})
+				continue
+			}
+			errs = append(errs, checkUnknownKeys(n.Content[i+1], ft, append(path, key.Value))...)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			errs = append(errs, checkUnknownKeys(n.Content[i+1], t.Elem(), append(path, n.Content[i].Value))...)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for i, c := range n.Content {
+			errs = append(errs, checkUnknownKeys(c, t.Elem(), append(path, i))...)
+		}
+	}
+
+	return errs
+}
+
+// yamlKey returns the YAML key of a struct field, empty when the field is not decoded.
+func yamlKey(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if name == "-" || f.PkgPath != "" {
+		return ""
+	}
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	return name
+}
+
+type configValidator struct {
+	cfg  *config
+	root *yaml.Node
+	errs configErrors
+}
+
+func (v *configValidator) errorf(path configPath, format string, args ...interface{}) {
+	v.errs = append(v.errs, configError{line: path.line(v.root), path: path.String(), msg: fmt.Sprintf(format, args...)})
+}
+
+// validateConfig checks cfg for problems which can not be expressed by types, e.g. references between sections.
+// root is the YAML file cfg was decoded from, used to point at lines.
+func validateConfig(cfg *config, root *yaml.Node) configErrors {
+	v := &configValidator{cfg: cfg, root: root}
+	pins := map[string]string{}
+
+	names := map[string]string{}
+	for i, cfg := range v.cfg.Shutters {
+		path := configPath{"shutters", i}
+		v.checkName(append(path, "name"), cfg.Name)
+		if prev, found := names[cfg.Name]; found && cfg.Name != "" {
+			v.errorf(append(path, "name"), "duplicate shutter name %q, already defined at %s", cfg.Name, prev)
+		}
+		names[cfg.Name] = append(path, "name").String()
+
+		d, found := driver.LookupShutter(cfg.Kind)
+		if !found {
+			v.errorf(append(path, "kind"), "%q is not supported shutter kind", cfg.Kind)
+			continue
+		}
+
+		var kinds []string
+		for kind := range cfg.Driver {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			if kind != cfg.Kind {
+				v.errorf(append(path, "driver", kind), "not used by %q shutter kind", cfg.Kind)
+			}
+		}
+
+		config := d.Config()
+		if !v.decodeDriver(append(path, "driver", cfg.Kind), cfg.Driver[cfg.Kind], config) {
+			continue
+		}
+		v.checkShutterDriver(append(path, "driver", cfg.Kind), config, pins)
+
+		positioned, _ := config.(driver.Positioned)
+		if wc := cfg.WindowContact; wc != nil {
+			v.checkWindowContact(append(path, "window_contact"), *wc, positioned)
+		}
+		if pf := cfg.PowerFeedback; pf != nil {
+			metered, _ := config.(driver.PowerMetered)
+			v.checkPowerFeedback(append(path, "power_feedback"), *pf, positioned, metered != nil && metered.MeasuresPower())
+		}
+	}
+
+	inputs := map[string]string{}
+	for i, cfg := range v.cfg.Inputs {
+		path := configPath{"inputs", i}
+		v.checkName(append(path, "name"), cfg.Name)
+		if prev, found := inputs[cfg.Name]; found && cfg.Name != "" {
+			v.errorf(append(path, "name"), "duplicate input name %q, already defined at %s", cfg.Name, prev)
+		}
+		inputs[cfg.Name] = append(path, "name").String()
+
+		v.checkInputPin(append(path, "pin"), cfg.Pin, pins)
+		if cfg.Shutter == "" {
+			continue
+		}
+		if _, found := names[cfg.Shutter]; !found {
+			v.errorf(append(path, "shutter"), "%q is not defined shutter", cfg.Shutter)
+		}
+		if cfg.Direction != input.UpDirection && cfg.Direction != input.DownDirection {
+			v.errorf(append(path, "direction"), "%q is not supported button direction", cfg.Direction)
+		}
+	}
+
+	return v.errs
+}
+
+// checkName rejects names which can not be a single MQTT topic level.
+func (v *configValidator) checkName(path configPath, name string) {
+	if name == "" {
+		v.errorf(path, "must not be empty")
+		return
+	}
+	if strings.ContainsAny(name, "+#/\x00") {
+		v.errorf(path, "%q must not contain MQTT topic characters +, # or /", name)
+	}
+}
+
+// decodeDriver decodes a driver config section into config and checks for unknown keys.
+// The section is decoded from the file when it is there, so type errors point at lines.
+func (v *configValidator) decodeDriver(path configPath, cfg driver.Config, config interface{}) bool {
+	n, found := path.node(v.root)
+	if !found {
+		if err := cfg.Decode(config); err != nil {
+			v.errorf(path, "%s", err)
+			return false
+		}
+		return true
+	}
+
+	kind := append(path, "kind").String()
+	for _, err := range checkUnknownKeys(n, reflect.TypeOf(config), path) {
+		if err.path != kind {
+			v.errs = append(v.errs, err)
+		}
+	}
+
+	if err := n.Decode(config); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			v.errorf(path, "%s", err)
+			return false
+		}
+		// values which fit are decoded, so checks go on
+		for _, msg := range typeErr.Errors {
+			v.errs = append(v.errs, configError{msg: msg})
+		}
+	}
+	return true
+}
+
+// validateDriver collects problems found by the driver config itself.
+func (v *configValidator) validateDriver(path configPath, config interface{}) {
+	err := driver.Validate(config)
+	var errs driver.FieldErrors
+	if !errors.As(err, &errs) {
+		if err != nil {
+			v.errorf(path, "%s", err)
+		}
+		return
+	}
+
+	for _, err := range errs {
+		p := append(configPath(nil), path...)
+		for _, key := range err.Path {
+			p = append(p, key)
+		}
+		v.errorf(p, "%s", err.Msg)
+	}
+}
+
+// checkShutterDriver checks a decoded shutter driver config and the relays it is built with.
+func (v *configValidator) checkShutterDriver(path configPath, config interface{}, pins map[string]string) {
+	v.validateDriver(path, config)
+
+	if timed, ok := config.(driver.RelayTimed); ok && v.cfg.Drivers.Relay.Watchdog.MaxOnTime > 0 {
+		maxOnTime := v.cfg.Drivers.Relay.Watchdog.MaxOnTime
+		runs := timed.RelayRuns()
+		keys := make([]string, 0, len(runs))
+		for key := range runs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if runs[key] > maxOnTime {
+				v.errorf(append(path, key), "exceeds drivers.relay.watchdog.max_on_time %s", maxOnTime)
+			}
+		}
+	}
+
+	keys, sections := driverSections(config)
+	for i, key := range keys {
+		v.checkRelay(append(path, key), sections[i], pins)
+	}
+}
+
+func (v *configValidator) checkRelay(path configPath, cfg driver.Config, pins map[string]string) {
+	d, found := driver.LookupRelay(cfg.Kind)
+	if !found {
+		v.errorf(append(path, "kind"), "%q is not supported relay kind", cfg.Kind)
+		return
+	}
+
+	config := d.Config()
+	if !v.decodeDriver(path, cfg, config) {
+		return
+	}
+	v.validateDriver(path, config)
+
+	keys, sections := driverSections(config)
+	for i, key := range keys {
+		v.checkDriverPin(append(path, key), sections[i], pins)
+	}
+}
+
+func (v *configValidator) checkDriverPin(path configPath, cfg driver.Config, pins map[string]string) {
+	d, found := driver.LookupPin(cfg.Kind)
+	if !found {
+		v.errorf(append(path, "kind"), "%q is not supported pin kind", cfg.Kind)
+		return
+	}
+
+	config := d.Config()
+	if !v.decodeDriver(path, cfg, config) {
+		return
+	}
+	v.validateDriver(path, config)
+
+	// mcp23017 devices are defined by drivers.relay.mcp23017
+	if cfg.Kind == "mcp23017" {
+		var pin cfgWiredRelaySetPin
+		if err := cfg.Decode(&pin); err == nil {
+			v.checkPinClaim(path, pin, pins)
+		}
+	}
+}
+
+// checkInputPin checks an input pin like a relay one, inputs support mcp23017 pins only.
+func (v *configValidator) checkInputPin(path configPath, cfg cfgWiredRelaySetPin, pins map[string]string) {
+	if cfg.Kind != "mcp23017" {
+		v.errorf(append(path, "kind"), "%q is not supported input pin kind", cfg.Kind)
+		return
+	}
+
+	section, err := driver.NewConfig("", cfg)
+	if err != nil {
+		v.errorf(path, "%s", err)
+		return
+	}
+	v.checkDriverPin(path, section, pins)
+}
+
+// checkPinClaim checks the pin references a defined device and is not claimed twice.
+func (v *configValidator) checkPinClaim(path configPath, cfg cfgWiredRelaySetPin, pins map[string]string) {
+	if _, found := v.cfg.Drivers.Relay.Mcp23017[cfg.Mcp23017]; !found {
+		v.errorf(append(path, "mcp23017"), "%d is not defined in drivers.relay.mcp23017", cfg.Mcp23017)
+	}
+
+	key := "mcp23017/" + strconv.Itoa(cfg.Mcp23017) + "/" + strconv.Itoa(int(cfg.Pin))
+	if prev, found := pins[key]; found {
+		v.errorf(path, "mcp23017 %d pin %d already used by %s", cfg.Mcp23017, cfg.Pin, prev)
+		return
+	}
+	pins[key] = path.String()
+}
+
+// driverSections returns the nested driver sections of a decoded driver config with their YAML keys,
+// the relays of a shutter or the pins of a relay.
+func driverSections(config interface{}) (keys []string, sections []driver.Config) {
+	val := reflect.ValueOf(config)
+	for val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, nil
+	}
+
+	for i := 0; i < val.NumField(); i++ {
+		key := yamlKey(val.Type().Field(i))
+		if key == "" {
+			continue
+		}
+		if section, ok := val.Field(i).Interface().(driver.Config); ok {
+			keys = append(keys, key)
+			sections = append(sections, section)
+		}
+	}
+	return keys, sections
+}
+
+// checkPowerFeedback checks the power feedback, positioned is nil for shutter kinds without positions in their config.
+// Without a topic, the driver has to measure power.
+func (v *configValidator) checkPowerFeedback(path configPath, cfg cfgPowerFeedback, positioned driver.Positioned, measured bool) {
+	if cfg.Topic == "" && !measured {
+		v.errorf(append(path, "topic"), "must not be empty, the shutter kind does not measure power")
+	}
+	if cfg.Threshold <= 0 {
+		v.errorf(append(path, "threshold"), "must be positive")
+	}
+	if cfg.EndStopTolerance < 0 {
+		v.errorf(append(path, "end_stop_tolerance"), "must not be negative")
+	} else if positioned != nil {
+		if open, closed := positioned.Positions(); cfg.EndStopTolerance > open-closed {
+			v.errorf(append(path, "end_stop_tolerance"), "%d is out of range 0-%d", cfg.EndStopTolerance, open-closed)
+		}
+	}
+}
+
+// checkWindowContact checks the window contact, positioned is nil for shutter kinds without positions in their config.
+func (v *configValidator) checkWindowContact(path configPath, cfg cfgWindowContact, positioned driver.Positioned) {
+	if cfg.Topic == "" {
+		v.errorf(append(path, "topic"), "must not be empty")
+	}
+
+	switch cfg.Mode {
+	case "", shutter.WindowContactBlockMode:
+	case shutter.WindowContactLimitMode:
+		if positioned == nil {
+			break
+		}
+		if open, closed := positioned.Positions(); cfg.VentilationPosition > open || cfg.VentilationPosition < closed {
+			v.errorf(
+				append(path, "ventilation_position"),
+				"%d is out of range open/close position (%d/%d)",
+				cfg.VentilationPosition,
+				open,
+				closed,
+			)
+		}
+	default:
+		v.errorf(append(path, "mode"), "%q is not supported window contact mode", cfg.Mode)
+	}
+}
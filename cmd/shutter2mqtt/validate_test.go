@@ -0,0 +1,216 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestConfig(t *testing.T, content string) error {
+	t.Helper()
+
+	saved := Cfg
+	t.Cleanup(func() { Cfg = saved })
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return loadConfig(path)
+}
+
+func TestLoadConfigFromYamlFileDist(t *testing.T) {
+	content, err := os.ReadFile("../../config.yaml.dist")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, loadTestConfig(t, string(content)))
+}
+
+func TestLoadConfigFromYamlFileErrors(t *testing.T) {
+	err := loadTestConfig(t, `
+shutters:
+  - kind: relays
+    name: living/room
+    driver:
+      relays:
+        up:
+          kind: wired
+          pin: {kind: mcp23017, mcp23017: 0, pin: 1}
+        down:
+          kind: wired
+          pin: {kind: mcp23017, mcp23017: 3, pin: 2}
+        full_open_position: 0
+        full_close_position: 0
+        time_to_close: 0s
+  - kind: relays
+    name: living/room
+    drivr: {}
+inputs:
+  - name: button
+    pin: {kind: mcp23017, mcp23017: 0, pin: 1}
+    shutter: kitchen
+    direction: sideways
+drivers:
+  relay:
+    mcp23017:
+      0:
+        bus: 1
+        device: 0
+`)
+
+	errs, ok := err.(configErrors)
+	if !assert.True(t, ok, "%v", err) {
+		return
+	}
+
+	messages := strings.Split(errs.Error(), "\n")
+	assert.ElementsMatch(t, []string{
+		"line 18: shutters[1].drivr: unknown key",
+		"line 29: drivers.relay.mcp23017.0.device: unknown key",
+		`line 4: shutters[0].name: "living/room" must not contain MQTT topic characters +, # or /`,
+		"line 13: shutters[0].driver.relays.full_open_position: must be greater than full_close_position (0)",
+		"line 15: shutters[0].driver.relays.time_to_close: must be positive",
+		"line 12: shutters[0].driver.relays.down.pin.mcp23017: 3 is not defined in drivers.relay.mcp23017",
+		`line 17: shutters[1].name: "living/room" must not contain MQTT topic characters +, # or /`,
+		`line 17: shutters[1].name: duplicate shutter name "living/room", already defined at shutters[0].name`,
+		"line 16: shutters[1].driver.relays.full_open_position: must be greater than full_close_position (0)",
+		"line 16: shutters[1].driver.relays.time_to_close: must be positive",
+		`line 16: shutters[1].driver.relays.up.kind: "" is not supported relay kind`,
+		`line 16: shutters[1].driver.relays.down.kind: "" is not supported relay kind`,
+		"line 21: inputs[0].pin: mcp23017 0 pin 1 already used by shutters[0].driver.relays.up.pin",
+		`line 22: inputs[0].shutter: "kitchen" is not defined shutter`,
+		`line 23: inputs[0].direction: "sideways" is not supported button direction`,
+	}, messages)
+}
+
+func TestLoadConfigLatencyErrors(t *testing.T) {
+	err := loadTestConfig(t, `
+shutters:
+  - kind: relays
+    name: kitchen
+    driver:
+      relays:
+        up: {kind: dumb}
+        down: {kind: dumb}
+        full_open_position: 100
+        time_to_close: 5m
+        start_delay: 300ms
+        stop_overrun: -1s
+        min_move: 101
+        position_publish_interval: 500ms
+        position_publish_step: 5
+        time_to_open: -1s
+        fault_recovery:
+          backoff: 1m
+          max_backoff: 30s
+`)
+
+	errs, ok := err.(configErrors)
+	if !assert.True(t, ok, "%v", err) {
+		return
+	}
+
+	assert.ElementsMatch(t, []string{
+		"line 10: shutters[0].driver.relays.time_to_close: exceeds drivers.relay.watchdog.max_on_time 5m0s",
+		"line 12: shutters[0].driver.relays.stop_overrun: must not be negative",
+		"line 13: shutters[0].driver.relays.min_move: 101 is out of range 0-100",
+		"line 15: shutters[0].driver.relays.position_publish_step: conflicts with position_publish_interval, set one of them",
+		"line 16: shutters[0].driver.relays.time_to_open: must not be negative",
+		"line 19: shutters[0].driver.relays.fault_recovery.max_backoff: must not be less than backoff 1m0s",
+	}, strings.Split(errs.Error(), "\n"))
+}
+
+func TestLoadConfigSimulatedShutterErrors(t *testing.T) {
+	err := loadTestConfig(t, `
+shutters:
+  - kind: simulated
+    name: kitchen
+    driver:
+      simulated:
+        full_open_position: 100
+        time_to_close: 30s
+        motor:
+          startup_delay: -1s
+          jitter: 1.5
+          initial_position: 120
+    window_contact:
+      topic: kitchen/window
+      mode: limit
+      ventilation_position: 101
+`)
+
+	errs, ok := err.(configErrors)
+	if !assert.True(t, ok, "%v", err) {
+		return
+	}
+
+	assert.ElementsMatch(t, []string{
+		"line 10: shutters[0].driver.simulated.motor.startup_delay: must not be negative",
+		"line 11: shutters[0].driver.simulated.motor.jitter: 1.5 is out of range 0-1",
+		"line 12: shutters[0].driver.simulated.motor.initial_position: 120 is out of range open/close position (100/0)",
+		"line 16: shutters[0].window_contact.ventilation_position: 101 is out of range open/close position (100/0)",
+	}, strings.Split(errs.Error(), "\n"))
+}
+
+func TestLoadConfigPowerFeedbackErrors(t *testing.T) {
+	err := loadTestConfig(t, `
+shutters:
+  - kind: relays
+    name: kitchen
+    driver:
+      relays:
+        up: {kind: dumb}
+        down: {kind: dumb}
+        full_open_position: 100
+        time_to_close: 30s
+    power_feedback:
+      threshold: 0
+      end_stop_tolerance: 101
+  - kind: simulated
+    name: bedroom
+    driver:
+      simulated: {full_open_position: 100, time_to_close: 30s}
+    power_feedback: {threshold: 10}
+`)
+
+	errs, ok := err.(configErrors)
+	if !assert.True(t, ok, "%v", err) {
+		return
+	}
+
+	assert.ElementsMatch(t, []string{
+		"line 12: shutters[0].power_feedback.topic: must not be empty, the shutter kind does not measure power",
+		"line 12: shutters[0].power_feedback.threshold: must be positive",
+		"line 13: shutters[0].power_feedback.end_stop_tolerance: 101 is out of range 0-100",
+	}, strings.Split(errs.Error(), "\n"))
+}
+
+func TestDumpConfigRedactsSecrets(t *testing.T) {
+	t.Setenv("S2M_MQTT_PASSWORD", "secret")
+	t.Setenv("S2M_LOG_LEVEL", "debug")
+	assert.NoError(t, loadTestConfig(t, "log_level: warn\nmqtt:\n  username: user\n"))
+
+	var b strings.Builder
+	assert.NoError(t, dumpConfig(&b))
+	assert.Contains(t, b.String(), "log_level: debug", "env overrides the file")
+	assert.Contains(t, b.String(), "username: user")
+	assert.Contains(t, b.String(), "password: "+redacted)
+	assert.NotContains(t, b.String(), "secret")
+	assert.Equal(t, "secret", Cfg.MQTT.Password)
+}
+
+func TestLoadConfigInvalidKeepsConfig(t *testing.T) {
+	assert.NoError(t, loadTestConfig(t, "log_level: warn\n"))
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("log_level: debug\nshutters:\n  - kind: somfy\n    name: kitchen\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualError(t, loadConfig(path), `line 3: shutters[0].kind: "somfy" is not supported shutter kind`)
+	assert.Equal(t, "warn", Cfg.LogLevel)
+}
@@ -11,9 +11,12 @@ require (
 
 require (
 	github.com/cristalhq/aconfig v0.16.8
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/prometheus/client_golang v1.10.0
+	github.com/prometheus/common v0.25.0 // indirect
 	github.com/stretchr/testify v1.7.1
-	golang.org/x/net v0.0.0-20200822124328-c89045814202 // indirect
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
 	golang.org/x/sys v0.0.0-20220731174439-a90be440212d // indirect
-	gopkg.in/yaml.v2 v2.4.0
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	google.golang.org/protobuf v1.27.1 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
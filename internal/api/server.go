@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+//go:embed openapi.yaml
+var openAPIDocument []byte
+
+type shutterResponse struct {
+	Name              string `json:"name"`
+	State             string `json:"state"`
+	Position          int    `json:"position"`
+	FullOpenPosition  int    `json:"full_open_position"`
+	FullClosePosition int    `json:"full_close_position"`
+
+	// set on stream updates only
+	Target      *int           `json:"target,omitempty"`
+	Direction   string         `json:"direction,omitempty"`
+	CoolingDown bool           `json:"cooling_down,omitempty"`
+	Obstructed  bool           `json:"obstructed,omitempty"`
+	Fault       *shutter.Fault `json:"fault,omitempty"`
+	Time        *time.Time     `json:"time,omitempty"`
+}
+
+type positionRequest struct {
+	Position *int `json:"position"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server exposes shutters over HTTP. Commands run within the server context,
+// so a move outlives the request which started it.
+type Server struct {
+	ctx context.Context
+	mux *http.ServeMux
+	hub *hub
+
+	l        sync.RWMutex
+	shutters []shutter.Shutter
+}
+
+func NewServer(ctx context.Context, shutters []shutter.Shutter) *Server {
+	s := &Server{ctx: shutter.WithSource(ctx, shutter.SourceHTTP), shutters: shutters, mux: http.NewServeMux(), hub: newHub(shutters)}
+	s.mux.HandleFunc("/openapi.yaml", s.handleOpenAPI)
+	s.mux.HandleFunc("/shutters", s.handleShutters)
+	s.mux.HandleFunc("/shutters/", s.handleShutter)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.Handle("/ws", websocket.Server{Handler: s.handleWebSocket})
+
+	return s
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s}
+
+	go func() {
+		<-ctx.Done()
+		if err := srv.Close(); err != nil {
+			logrus.Errorf("HTTP server close failed: %s", err)
+		}
+	}()
+
+	logrus.Infof("HTTP server listening on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(openAPIDocument)
+}
+
+func (s *Server) handleShutters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	shutters := s.Shutters()
+	resp := make([]shutterResponse, 0, len(shutters))
+	for _, sh := range shutters {
+		resp = append(resp, newShutterResponse(sh))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleShutter(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/shutters/"), "/")
+	sh := s.shutter(parts[0])
+	if sh == nil {
+		writeError(w, http.StatusNotFound, errors.New("shutter not found"))
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		writeJSON(w, http.StatusOK, newShutterResponse(sh))
+		return
+	}
+
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	ctx := shutter.WithOrigin(shutter.WithRequestID(s.ctx, requestID(r)), r.RemoteAddr)
+	log := shutter.Logger(ctx, logrus.WithField("shutter", sh.Name())).WithField("command", parts[1])
+
+	var err error
+	switch parts[1] {
+	case "open", "close", "stop":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		err = s.command(ctx, sh, parts[1])
+	case "position":
+		if r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		var req positionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Position == nil {
+			writeError(w, http.StatusBadRequest, errors.New("request body must be a JSON object with a position"))
+			return
+		}
+		if *req.Position > sh.FullOpenPosition() || *req.Position < sh.FullClosePosition() {
+			writeError(w, http.StatusBadRequest, errors.New("position out of range"))
+			return
+		}
+		log = log.WithField("target", *req.Position)
+		err = sh.SetPosition(ctx, *req.Position)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	if err != nil {
+		log.Errorf("HTTP command: %s", err)
+
+		writeError(w, commandErrorStatus(w, sh, err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, newShutterResponse(sh))
+}
+
+// commandErrorStatus returns the status of a failed command. A cool-down is retried after, so its end is set
+// as the Retry-After header. A command rejected by a window contact or failed on a fault of the shutter
+// conflicts with its state.
+func commandErrorStatus(w http.ResponseWriter, sh shutter.Shutter, err error) int {
+	var coolingDownErr *relay.CoolingDownError
+	if errors.As(err, &coolingDownErr) {
+		retryAfter := int(math.Ceil(time.Until(coolingDownErr.Until).Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		return http.StatusServiceUnavailable
+	}
+
+	var contactErr *shutter.WindowContactError
+	if errors.As(err, &contactErr) || errors.Is(err, relay.ErrForceDisabled) {
+		return http.StatusConflict
+	}
+	if r, ok := sh.(shutter.FaultReporter); ok {
+		if _, faulted := r.Fault(); faulted {
+			return http.StatusConflict
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *Server) command(ctx context.Context, sh shutter.Shutter, cmd string) error {
+	switch cmd {
+	case "open":
+		return sh.Open(ctx)
+	case "close":
+		return sh.Close(ctx)
+	default:
+		return sh.Stop(ctx)
+	}
+}
+
+// requestID returns the client provided X-Request-ID or a new one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	return shutter.NewRequestID()
+}
+
+// AddShutter exposes a shutter added after the server was created, e.g. on a config reload.
+func (s *Server) AddShutter(sh shutter.Shutter) {
+	s.l.Lock()
+	s.shutters = append(s.shutters, sh)
+	s.l.Unlock()
+
+	s.hub.add(sh)
+}
+
+func (s *Server) RemoveShutter(name string) {
+	s.l.Lock()
+	for i, sh := range s.shutters {
+		if sh.Name() == name {
+			s.shutters = append(s.shutters[:i:i], s.shutters[i+1:]...)
+			break
+		}
+	}
+	s.l.Unlock()
+
+	s.hub.remove(name)
+}
+
+func (s *Server) Shutters() []shutter.Shutter {
+	s.l.RLock()
+	defer s.l.RUnlock()
+
+	return s.shutters
+}
+
+func (s *Server) shutter(name string) shutter.Shutter {
+	for _, sh := range s.Shutters() {
+		if sh.Name() == name {
+			return sh
+		}
+	}
+	return nil
+}
+
+func newShutterResponse(s shutter.Shutter) shutterResponse {
+	return shutterResponse{
+		Name:              s.Name(),
+		State:             s.State(),
+		Position:          s.Position(),
+		FullOpenPosition:  s.FullOpenPosition(),
+		FullClosePosition: s.FullClosePosition(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("HTTP response write failed: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
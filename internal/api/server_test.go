@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeShutter struct {
+	l        sync.Mutex
+	position int
+	// err fails commands, fault is reported when set
+	err      error
+	fault    *shutter.Fault
+	notifier shutter.Notifier
+}
+
+func (s *fakeShutter) Name() string           { return "living_room" }
+func (s *fakeShutter) FullOpenPosition() int  { return 100 }
+func (s *fakeShutter) FullClosePosition() int { return 0 }
+func (s *fakeShutter) Position() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.position
+}
+func (s *fakeShutter) State() string { return shutter.ShutterOpenState }
+func (s *fakeShutter) Subscribe(h shutter.EventHandler) func() {
+	return s.notifier.Subscribe(h)
+}
+func (s *fakeShutter) Open(ctx context.Context) error  { return s.SetPosition(ctx, 100) }
+func (s *fakeShutter) Close(ctx context.Context) error { return s.SetPosition(ctx, 0) }
+func (s *fakeShutter) Stop(context.Context) error      { return nil }
+func (s *fakeShutter) SetPosition(_ context.Context, position int) error {
+	s.l.Lock()
+	if s.err != nil {
+		s.l.Unlock()
+		return s.err
+	}
+	s.position = position
+	s.l.Unlock()
+	s.notifier.Notify(shutter.Event{Shutter: s.Name(), State: shutter.ShutterOpenState, Position: position, Target: position})
+	return nil
+}
+func (s *fakeShutter) Fault() (shutter.Fault, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.fault == nil {
+		return shutter.Fault{}, false
+	}
+	return *s.fault, true
+}
+
+// fail makes commands fail with err and the shutter report fault until the test ends.
+func (s *fakeShutter) fail(t *testing.T, err error, fault *shutter.Fault) {
+	s.l.Lock()
+	s.err, s.fault = err, fault
+	s.l.Unlock()
+	t.Cleanup(func() {
+		s.l.Lock()
+		s.err, s.fault = nil, nil
+		s.l.Unlock()
+	})
+}
+
+func TestServer(t *testing.T) {
+	s := &fakeShutter{position: 30}
+	srv := NewServer(context.Background(), []shutter.Shutter{s})
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("list shutters", func(t *testing.T) {
+		w := request(http.MethodGet, "/shutters", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `[{"name":"living_room","state":"open","position":30,"full_open_position":100,"full_close_position":0}]`, w.Body.String())
+	})
+
+	t.Run("unknown shutter", func(t *testing.T) {
+		w := request(http.MethodGet, "/shutters/kitchen", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.JSONEq(t, `{"error":"shutter not found"}`, w.Body.String())
+	})
+
+	t.Run("open shutter", func(t *testing.T) {
+		w := request(http.MethodPost, "/shutters/living_room/open", "")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, 100, s.Position())
+	})
+
+	t.Run("set position", func(t *testing.T) {
+		w := request(http.MethodPut, "/shutters/living_room/position", `{"position": 42}`)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, 42, s.Position())
+	})
+
+	t.Run("set position out of range", func(t *testing.T) {
+		w := request(http.MethodPut, "/shutters/living_room/position", `{"position": 142}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, 42, s.Position())
+	})
+
+	t.Run("motor cooling down", func(t *testing.T) {
+		s.fail(t, errors.Wrap(&relay.CoolingDownError{Until: time.Now().Add(time.Second * 90)}, "living_room"), nil)
+		w := request(http.MethodPost, "/shutters/living_room/open", "")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "90", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("relay force disabled by watchdog", func(t *testing.T) {
+		s.fail(t, relay.ErrForceDisabled, nil)
+		w := request(http.MethodPost, "/shutters/living_room/close", "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.JSONEq(t, `{"error":"relay force disabled by watchdog"}`, w.Body.String())
+	})
+
+	t.Run("shutter fault", func(t *testing.T) {
+		s.fail(t, errors.New("device not alive"), &shutter.Fault{Code: shutter.FaultRelay, Message: "device not alive"})
+		w := request(http.MethodPut, "/shutters/living_room/position", `{"position": 10}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, 42, s.Position())
+	})
+
+	t.Run("command failed", func(t *testing.T) {
+		s.fail(t, errors.New("failed"), nil)
+		w := request(http.MethodPost, "/shutters/living_room/open", "")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("wrong method", func(t *testing.T) {
+		w := request(http.MethodGet, "/shutters/living_room/open", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	t.Run("remove and add shutter", func(t *testing.T) {
+		srv.RemoveShutter("living_room")
+		assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/shutters/living_room", "").Code)
+		assert.JSONEq(t, `[]`, request(http.MethodGet, "/shutters", "").Body.String())
+
+		srv.AddShutter(s)
+		assert.Equal(t, http.StatusOK, request(http.MethodGet, "/shutters/living_room", "").Code)
+	})
+
+	t.Run("openapi document", func(t *testing.T) {
+		w := request(http.MethodGet, "/openapi.yaml", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "openapi:")
+	})
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	subscriberBufferSize = 64
+	streamKeepAlive      = time.Second * 15
+	streamWriteTimeout   = time.Second * 5
+)
+
+var websocketPing = websocket.Codec{Marshal: func(interface{}) ([]byte, byte, error) {
+	return nil, websocket.PingFrame, nil
+}}
+
+type subscriber struct {
+	events chan []byte
+	// dropped gets closed when the subscriber could not keep up with events
+	dropped chan struct{}
+}
+
+// hub fans out shutter updates to stream subscribers.
+// A subscriber with a full buffer is dropped instead of blocking other subscribers and the shutter.
+type hub struct {
+	l           sync.Mutex
+	subscribers map[*subscriber]struct{}
+	shutters    map[string]func()
+}
+
+func newHub(shutters []shutter.Shutter) *hub {
+	h := &hub{subscribers: map[*subscriber]struct{}{}, shutters: map[string]func(){}}
+	for _, s := range shutters {
+		h.add(s)
+	}
+	return h
+}
+
+func (h *hub) add(s shutter.Shutter) {
+	unsubscribe := s.Subscribe(h.onShutterEventHandler(s))
+
+	h.l.Lock()
+	h.shutters[s.Name()] = unsubscribe
+	h.l.Unlock()
+}
+
+func (h *hub) remove(name string) {
+	h.l.Lock()
+	unsubscribe := h.shutters[name]
+	delete(h.shutters, name)
+	h.l.Unlock()
+
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+}
+
+// onShutterEventHandler streams events of s. Payloads are built from the event only, the shutter
+// might have moved on by the time it is delivered.
+func (h *hub) onShutterEventHandler(s shutter.Shutter) shutter.EventHandler {
+	name, fullOpen, fullClose := s.Name(), s.FullOpenPosition(), s.FullClosePosition()
+
+	return func(e shutter.Event) {
+		resp := shutterResponse{
+			Name:              name,
+			State:             e.State,
+			Position:          e.Position,
+			Target:            &e.Target,
+			Direction:         e.Direction,
+			CoolingDown:       e.CoolingDown,
+			Obstructed:        e.Obstructed,
+			Fault:             e.Fault,
+			Time:              &e.Time,
+			FullOpenPosition:  fullOpen,
+			FullClosePosition: fullClose,
+		}
+
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			logrus.WithField("shutter", name).Errorf("stream event marshal failed: %s", err)
+			return
+		}
+		h.publish(payload)
+	}
+}
+
+func (h *hub) subscribe() *subscriber {
+	sub := &subscriber{events: make(chan []byte, subscriberBufferSize), dropped: make(chan struct{})}
+
+	h.l.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.l.Unlock()
+
+	return sub
+}
+
+func (h *hub) unsubscribe(sub *subscriber) {
+	h.l.Lock()
+	delete(h.subscribers, sub)
+	h.l.Unlock()
+}
+
+func (h *hub) publish(payload []byte) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	for sub := range h.subscribers {
+		select {
+		case sub.events <- payload:
+		default:
+			logrus.Warn("stream subscriber too slow, dropping")
+			delete(h.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+// stream sends a snapshot of all shutters followed by live updates until ctx is done,
+// the subscriber gets dropped or send fails.
+func (s *Server) stream(ctx context.Context, send func([]byte) error, keepAlive func() error) error {
+	sub := s.hub.subscribe()
+	defer s.hub.unsubscribe(sub)
+
+	for _, sh := range s.Shutters() {
+		payload, err := json.Marshal(newShutterResponse(sh))
+		if err != nil {
+			return err
+		}
+		if err := send(payload); err != nil {
+			return err
+		}
+	}
+
+	every := time.NewTicker(streamKeepAlive)
+	defer every.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.ctx.Done():
+			return nil
+		case <-sub.dropped:
+			return errors.New("subscriber too slow")
+		case <-every.C:
+			if err := keepAlive(); err != nil {
+				return err
+			}
+		case payload := <-sub.events:
+			if err := send(payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	write := func(format string, a ...interface{}) error {
+		if _, err := fmt.Fprintf(w, format, a...); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	err := s.stream(
+		r.Context(),
+		func(payload []byte) error { return write("event: update\ndata: %s\n\n", payload) },
+		func() error { return write(": keep-alive\n\n") },
+	)
+	if err != nil {
+		logrus.Debugf("HTTP event stream closed: %s", err)
+	}
+}
+
+func (s *Server) handleWebSocket(conn *websocket.Conn) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(conn.Request().Context())
+	defer cancel()
+
+	// the stream is one-way, reads only detect a closed connection
+	go func() {
+		defer cancel()
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(payload []byte) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+			return err
+		}
+		return websocket.Message.Send(conn, string(payload))
+	}
+
+	err := s.stream(ctx, send, func() error {
+		if err := conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+			return err
+		}
+		return websocketPing.Send(conn, nil)
+	})
+	if err != nil {
+		logrus.Debugf("HTTP websocket stream closed: %s", err)
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func TestEventStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	s := &fakeShutter{position: 30}
+	ts := httptest.NewServer(NewServer(ctx, []shutter.Shutter{s}))
+	defer ts.Close()
+
+	t.Run("SSE sends snapshot followed by updates", func(t *testing.T) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events", nil)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		lines := bufio.NewScanner(resp.Body)
+		readData := func() string {
+			for lines.Scan() {
+				if strings.HasPrefix(lines.Text(), "data: ") {
+					return strings.TrimPrefix(lines.Text(), "data: ")
+				}
+			}
+			return ""
+		}
+
+		assert.Contains(t, readData(), `"position":30`)
+		assert.NoError(t, s.SetPosition(ctx, 55))
+		assert.Contains(t, readData(), `"position":55`)
+	})
+
+	t.Run("websocket sends snapshot followed by updates", func(t *testing.T) {
+		conn, err := websocket.Dial(strings.Replace(ts.URL, "http", "ws", 1)+"/ws", "", ts.URL)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		var msg string
+		assert.NoError(t, websocket.Message.Receive(conn, &msg))
+		assert.Contains(t, msg, `"position":55`)
+
+		assert.NoError(t, s.SetPosition(ctx, 70))
+		assert.NoError(t, websocket.Message.Receive(conn, &msg))
+		assert.Contains(t, msg, `"position":70`)
+	})
+
+	t.Run("updates are built from events", func(t *testing.T) {
+		conn, err := websocket.Dial(strings.Replace(ts.URL, "http", "ws", 1)+"/ws", "", ts.URL)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		var msg string
+		assert.NoError(t, websocket.Message.Receive(conn, &msg))
+
+		// the shutter reports position 70 still
+		s.notifier.Notify(shutter.Event{Shutter: s.Name(), State: shutter.ShutterClosingState, Position: 12})
+		assert.NoError(t, websocket.Message.Receive(conn, &msg))
+		assert.Contains(t, msg, `"state":"closing","position":12`)
+		assert.Contains(t, msg, `"full_open_position":100`)
+	})
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	h := newHub(nil)
+	sub := h.subscribe()
+
+	for i := 0; i <= subscriberBufferSize; i++ {
+		h.publish([]byte("{}"))
+	}
+
+	select {
+	case <-sub.dropped:
+	default:
+		t.Fatal("slow subscriber was not dropped")
+	}
+	assert.Empty(t, h.subscribers)
+}
@@ -0,0 +1,205 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/sirupsen/logrus"
+)
+
+type Entry struct {
+	Time          time.Time `json:"time"`
+	Shutter       string    `json:"shutter"`
+	Command       string    `json:"command"`
+	Source        string    `json:"source"`
+	Origin        string    `json:"origin,omitempty"`
+	RequestID     string    `json:"request_id,omitempty"`
+	StartPosition int       `json:"start_position"`
+	EndPosition   int       `json:"end_position"`
+	Target        int       `json:"target"`
+	Relay         string    `json:"relay,omitempty"`
+	RunTime       float64   `json:"run_time_seconds"`
+	Outcome       string    `json:"outcome"`
+	Error         string    `json:"error,omitempty"`
+}
+
+func NewEntry(m shutter.Movement) Entry {
+	return Entry{
+		Time:          m.Time,
+		Shutter:       m.Shutter,
+		Command:       m.Command,
+		Source:        m.Source,
+		Origin:        m.Origin,
+		RequestID:     m.RequestID,
+		StartPosition: m.StartPosition,
+		EndPosition:   m.EndPosition,
+		Target:        m.Target,
+		Relay:         m.Relay,
+		RunTime:       m.RunTime.Seconds(),
+		Outcome:       m.Outcome,
+		Error:         m.Error,
+	}
+}
+
+// recordQueueSize is how many recorded entries can wait to be written, further ones are dropped.
+const recordQueueSize = 64
+
+// Log is an append-only JSONL audit log. Entries older than retention are dropped on Open and Prune.
+type Log struct {
+	path      string
+	retention time.Duration
+
+	l sync.Mutex
+	f *os.File
+
+	queueL  sync.RWMutex
+	closed  bool
+	queue   chan Entry
+	written chan struct{}
+}
+
+func Open(path string, retention time.Duration) (*Log, error) {
+	l := &Log{path: path, retention: retention, queue: make(chan Entry, recordQueueSize), written: make(chan struct{})}
+	if err := l.Prune(); err != nil {
+		return nil, err
+	}
+	go l.write()
+	return l, nil
+}
+
+// Record queues a movement to be written by the log goroutine, so shutter notifications do not wait for the disk.
+func (l *Log) Record(m shutter.Movement) {
+	l.queueL.RLock()
+	defer l.queueL.RUnlock()
+
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- NewEntry(m):
+	default:
+		logrus.WithField("shutter", m.Shutter).Error("audit log write queue full, entry dropped")
+	}
+}
+
+func (l *Log) write() {
+	defer close(l.written)
+
+	for e := range l.queue {
+		if err := l.Append(e); err != nil {
+			logrus.WithField("shutter", e.Shutter).Errorf("audit log write failed: %s", err)
+		}
+	}
+}
+
+func (l *Log) Append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	_, err = l.f.Write(append(line, '\n'))
+	return err
+}
+
+// Prune rewrites the log without entries older than retention.
+func (l *Log) Prune() error {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if l.f != nil {
+		if err := l.f.Close(); err != nil {
+			return err
+		}
+		l.f = nil
+	}
+
+	if l.retention > 0 {
+		if err := l.prune(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	return nil
+}
+
+// Close writes queued entries and closes the file.
+func (l *Log) Close() error {
+	l.queueL.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.queueL.Unlock()
+	<-l.written
+
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	return l.f.Close()
+}
+
+func (l *Log) prune() error {
+	entries, err := Read(l.path, Filter{Since: time.Now().Add(-l.retention)})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), l.path)
+}
+
+// PruneEvery prunes the log periodically until ctx is done.
+func (l *Log) PruneEvery(ctx context.Context, interval time.Duration) {
+	every := time.NewTicker(interval)
+	defer every.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-every.C:
+			if err := l.Prune(); err != nil {
+				logrus.Errorf("audit log prune failed: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l, err := Open(path, time.Hour)
+	assert.NoError(t, err)
+
+	old := Entry{Time: time.Now().Add(-time.Hour * 2), Shutter: "bedroom", Command: "close", Source: "mqtt", Outcome: "completed"}
+	recent := Entry{Time: time.Now(), Shutter: "bedroom", Command: "open", Source: "http", Outcome: "superseded"}
+	other := Entry{Time: time.Now(), Shutter: "kitchen", Command: "stop", Source: "button", Outcome: "completed"}
+	for _, e := range []Entry{old, recent, other} {
+		assert.NoError(t, l.Append(e))
+	}
+
+	t.Run("read with filter", func(t *testing.T) {
+		entries, err := Read(path, Filter{Shutter: "bedroom"})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+
+		entries, err = Read(path, Filter{Limit: 1})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "kitchen", entries[0].Shutter)
+	})
+
+	t.Run("prune drops entries older than retention", func(t *testing.T) {
+		assert.NoError(t, l.Prune())
+		entries, err := Read(path, Filter{})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+		assert.Equal(t, "open", entries[0].Command)
+
+		assert.NoError(t, l.Append(old))
+		entries, err = Read(path, Filter{})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+	})
+
+	t.Run("HTTP query", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		l.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit?shutter=kitchen", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"command":"stop"`)
+		assert.NotContains(t, w.Body.String(), "bedroom")
+
+		w = httptest.NewRecorder()
+		l.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/audit?since=yesterday", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	assert.NoError(t, l.Close())
+}
+
+func TestLogRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l, err := Open(path, 0)
+	assert.NoError(t, err)
+
+	for _, command := range []string{"open", "stop", "close"} {
+		m := shutter.NewMovement(context.Background(), "bedroom", command, 0, 100)
+		m.Outcome = shutter.OutcomeCompleted
+		l.Record(m)
+	}
+	assert.NoError(t, l.Close())
+	l.Record(shutter.NewMovement(context.Background(), "bedroom", "open", 0, 100))
+
+	entries, err := Read(path, Filter{})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3, "queued entries are written on close") {
+		assert.Equal(t, "open", entries[0].Command)
+		assert.Equal(t, "close", entries[2].Command)
+	}
+}
@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Filter struct {
+	Shutter string
+	Source  string
+	Since   time.Time
+	Until   time.Time
+	// Limit keeps only the most recent entries, zero means no limit.
+	Limit int
+}
+
+func (f Filter) match(e Entry) bool {
+	if f.Shutter != "" && e.Shutter != f.Shutter {
+		return false
+	}
+	if f.Source != "" && e.Source != f.Source {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Read returns entries matching the filter, oldest first. Malformed lines are skipped.
+func Read(path string, filter Filter) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	lines := bufio.NewScanner(f)
+	lines.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lines.Scan() {
+		var e Entry
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			logrus.Warnf("audit log: malformed entry skipped: %s", err)
+			continue
+		}
+		if filter.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+	return entries, nil
+}
+
+// Handler serves entries matching shutter, source, since, until (RFC 3339) and limit query parameters.
+func (l *Log) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		filter, err := filterFromQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		l.l.Lock()
+		entries, err := Read(l.path, filter)
+		l.l.Unlock()
+		if err != nil && !os.IsNotExist(err) {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		if entries == nil {
+			entries = []Entry{}
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+}
+
+func filterFromQuery(r *http.Request) (filter Filter, err error) {
+	q := r.URL.Query()
+	filter.Shutter = q.Get("shutter")
+	filter.Source = q.Get("source")
+
+	if v := q.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("since must be a RFC 3339 time")
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("until must be a RFC 3339 time")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			return filter, errors.New("limit must be a non-negative integer")
+		}
+	}
+	return filter, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("HTTP response write failed: %s", err)
+	}
+}
@@ -0,0 +1,187 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a virtual clock, time only passes on Advance. Timers and tickers due within an Advance
+// fire in order of their deadlines, each seeing Now at its deadline.
+type Fake struct {
+	l       sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	seq     int
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	clock  *Fake
+	seq    int
+	at     time.Time
+	period time.Duration // tickers only
+	c      chan time.Time
+	f      func() // AfterFunc only
+}
+
+// NewFake returns a clock stopped at now.
+func NewFake(now time.Time) *Fake {
+	c := &Fake{now: now}
+	c.changed = sync.NewCond(&c.l)
+	return c
+}
+
+func (c *Fake) Now() time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.now
+}
+
+func (c *Fake) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *Fake) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Sleep blocks until the clock is advanced by d.
+func (c *Fake) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *Fake) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0, nil)
+}
+
+func (c *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(d, d, nil)}
+}
+
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(d, 0, f)
+}
+
+func (c *Fake) add(d, period time.Duration, f func()) *fakeWaiter {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.seq++
+	w := &fakeWaiter{clock: c, seq: c.seq, at: c.now.Add(d), period: period, c: make(chan time.Time, 1), f: f}
+	if d <= 0 && period == 0 {
+		w.fire(c.now)
+		return w
+	}
+
+	c.waiters = append(c.waiters, w)
+	c.changed.Broadcast()
+	return w
+}
+
+// Advance moves the clock forward by d and fires timers and tickers due meanwhile.
+// Like the time package, a ticker drops ticks its receiver is not ready for.
+func (c *Fake) Advance(d time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		w := c.next(end)
+		if w == nil {
+			break
+		}
+
+		c.now = w.at
+		w.fire(w.at)
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			c.remove(w)
+		}
+	}
+	c.now = end
+}
+
+// Waiters returns the number of pending timers and running tickers.
+func (c *Fake) Waiters() int {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return len(c.waiters)
+}
+
+// BlockUntil waits until there are at least n Waiters, so a test advances the clock
+// only after the code under test started waiting on it.
+func (c *Fake) BlockUntil(n int) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	for len(c.waiters) < n {
+		c.changed.Wait()
+	}
+}
+
+func (c *Fake) next(end time.Time) *fakeWaiter {
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		if c.waiters[i].at.Equal(c.waiters[j].at) {
+			return c.waiters[i].seq < c.waiters[j].seq
+		}
+		return c.waiters[i].at.Before(c.waiters[j].at)
+	})
+
+	if len(c.waiters) == 0 || c.waiters[0].at.After(end) {
+		return nil
+	}
+	return c.waiters[0]
+}
+
+func (c *Fake) remove(w *fakeWaiter) bool {
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			c.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fakeWaiter) fire(now time.Time) {
+	if w.f != nil {
+		go w.f()
+		return
+	}
+
+	select {
+	case w.c <- now:
+	default:
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.l.Lock()
+	defer w.clock.l.Unlock()
+
+	return w.clock.remove(w)
+}
+
+type fakeTicker struct {
+	w *fakeWaiter
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t fakeTicker) Stop() {
+	t.w.Stop()
+}
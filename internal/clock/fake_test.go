@@ -0,0 +1,77 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFakeTimer(t *testing.T) {
+	c := NewFake(epoch)
+	timer := c.NewTimer(time.Second)
+	stopped := c.NewTimer(time.Second)
+	assert.Equal(t, 2, c.Waiters())
+
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop(), "already stopped")
+
+	c.Advance(time.Millisecond * 999)
+	assert.Empty(t, timer.C())
+
+	c.Advance(time.Millisecond * 2)
+	assert.Equal(t, epoch.Add(time.Second), <-timer.C(), "fired at its deadline")
+	assert.Equal(t, epoch.Add(time.Millisecond*1001), c.Now())
+	assert.Equal(t, 0, c.Waiters())
+	assert.Empty(t, stopped.C())
+
+	assert.Equal(t, c.Now(), <-c.After(0), "non-positive duration fires immediately")
+}
+
+func TestFakeTicker(t *testing.T) {
+	c := NewFake(epoch)
+	ticker := c.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 1; i <= 3; i++ {
+		c.Advance(time.Second)
+		assert.Equal(t, epoch.Add(time.Second*time.Duration(i)), <-ticker.C())
+	}
+
+	c.Advance(time.Second * 3)
+	assert.Equal(t, epoch.Add(time.Second*4), <-ticker.C(), "ticks not received are dropped")
+	assert.Empty(t, ticker.C())
+
+	ticker.Stop()
+	c.Advance(time.Second)
+	assert.Empty(t, ticker.C())
+}
+
+func TestFakeAfterFunc(t *testing.T) {
+	c := NewFake(epoch)
+	called := make(chan struct{})
+	c.AfterFunc(time.Second, func() { close(called) })
+
+	c.Advance(time.Second)
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("not called")
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	c := NewFake(epoch)
+	slept := make(chan time.Duration)
+	go func() {
+		start := c.Now()
+		c.Sleep(time.Minute)
+		slept <- c.Since(start)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	assert.Equal(t, time.Minute, <-slept)
+}
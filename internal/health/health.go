@@ -0,0 +1,107 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Check func() error
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Checker runs named liveness and readiness checks.
+type Checker struct {
+	l         sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+func NewChecker() *Checker {
+	return &Checker{liveness: map[string]Check{}, readiness: map[string]Check{}}
+}
+
+func (c *Checker) AddLivenessCheck(name string, check Check) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.liveness[name] = check
+}
+
+func (c *Checker) AddReadinessCheck(name string, check Check) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.readiness[name] = check
+}
+
+func (c *Checker) Live() error {
+	return c.firstError(c.run(c.liveness))
+}
+
+func (c *Checker) Ready() error {
+	return c.firstError(c.run(c.readiness))
+}
+
+func (c *Checker) LivenessHandler() http.Handler {
+	return c.handler(c.liveness)
+}
+
+func (c *Checker) ReadinessHandler() http.Handler {
+	return c.handler(c.readiness)
+}
+
+func (c *Checker) handler(checks map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := response{Status: "ok", Checks: map[string]string{}}
+		status := http.StatusOK
+
+		for name, err := range c.run(checks) {
+			if err != nil {
+				resp.Status = "failing"
+				resp.Checks[name] = err.Error()
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			resp.Checks[name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logrus.Errorf("HTTP response write failed: %s", err)
+		}
+	})
+}
+
+func (c *Checker) run(checks map[string]Check) map[string]error {
+	c.l.RLock()
+	defer c.l.RUnlock()
+
+	results := make(map[string]error, len(checks))
+	for name, check := range checks {
+		results[name] = check()
+	}
+	return results
+}
+
+func (c *Checker) firstError(results map[string]error) error {
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if results[name] != nil {
+			return results[name]
+		}
+	}
+	return nil
+}
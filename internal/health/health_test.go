@@ -0,0 +1,39 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker(t *testing.T) {
+	c := NewChecker()
+	ready := errors.New("MQTT broker not connected")
+	c.AddReadinessCheck("mqtt", func() error { return ready })
+
+	t.Run("liveness without checks is ok", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c.LivenessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, c.Live())
+	})
+
+	t.Run("failing readiness check", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c.ReadinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.JSONEq(t, `{"status":"failing","checks":{"mqtt":"MQTT broker not connected"}}`, w.Body.String())
+		assert.Equal(t, ready, c.Ready())
+	})
+
+	t.Run("passing readiness check", func(t *testing.T) {
+		ready = nil
+		w := httptest.NewRecorder()
+		c.ReadinessHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"status":"ok","checks":{"mqtt":"ok"}}`, w.Body.String())
+	})
+}
@@ -0,0 +1,84 @@
+package input
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	UpDirection   = "up"
+	DownDirection = "down"
+)
+
+// ShutterAction maps button gestures onto a shutter:
+// single press toggles move/stop, long press moves while held, double press goes to a preset position.
+// While the shutter is calibrating, single press signals it reached the end-stop.
+type ShutterAction struct {
+	shutter        shutter.Shutter
+	direction      string
+	presetPosition *int
+	calibrator     shutter.Calibrator
+}
+
+func NewShutterAction(s shutter.Shutter, direction string, presetPosition *int) (*ShutterAction, error) {
+	if direction != UpDirection && direction != DownDirection {
+		return nil, fmt.Errorf("%s: %s is not supported button direction", s.Name(), direction)
+	}
+
+	return &ShutterAction{shutter: s, direction: direction, presetPosition: presetPosition}, nil
+}
+
+// SetCalibrator makes single press signal calibration of c, which calibrates the shutter, e.g. unwrapped.
+func (a *ShutterAction) SetCalibrator(c shutter.Calibrator) {
+	a.calibrator = c
+}
+
+func (a *ShutterAction) Handler(ctx context.Context) EventHandler {
+	ctx = shutter.WithSource(ctx, shutter.SourceButton)
+	return func(button string, event string) {
+		ctx := shutter.WithOrigin(shutter.WithRequestID(ctx, shutter.NewRequestID()), button)
+
+		var err error
+		switch event {
+		case SinglePressEvent:
+			if a.calibrator != nil && a.calibrator.CalibrationReached() {
+				return
+			}
+			if a.isMoving() {
+				err = a.shutter.Stop(ctx)
+			} else {
+				err = a.move(ctx)
+			}
+		case LongPressEvent:
+			err = a.move(ctx)
+		case LongPressReleaseEvent:
+			err = a.shutter.Stop(ctx)
+		case DoublePressEvent:
+			if a.presetPosition == nil {
+				return
+			}
+			err = a.shutter.SetPosition(ctx, *a.presetPosition)
+		}
+
+		if err != nil {
+			shutter.Logger(ctx, logrus.WithField("shutter", a.shutter.Name())).
+				WithField("button", button).
+				Errorf("button %s press: %s", event, err)
+		}
+	}
+}
+
+func (a *ShutterAction) isMoving() bool {
+	state := a.shutter.State()
+	return state == shutter.ShutterOpeningState || state == shutter.ShutterClosingState
+}
+
+func (a *ShutterAction) move(ctx context.Context) error {
+	if a.direction == UpDirection {
+		return a.shutter.Open(ctx)
+	}
+	return a.shutter.Close(ctx)
+}
@@ -0,0 +1,143 @@
+package input
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SinglePressEvent      = "single"
+	DoublePressEvent      = "double"
+	LongPressEvent        = "long"
+	LongPressReleaseEvent = "long_release"
+)
+
+type EventHandler func(button string, event string)
+
+// Button turns sampled pin levels into press gestures.
+// Gestures are resolved on Sample calls only, so it has to be sampled periodically, also when idle.
+type Button struct {
+	Name string
+	Pin  Pin
+
+	Debounce    time.Duration
+	LongPress   time.Duration
+	DoublePress time.Duration
+	// Clock samples the pin, it defaults to the system clock.
+	Clock clock.Clock
+
+	l        sync.Mutex
+	handlers []EventHandler
+
+	pressed         bool
+	changedAt       time.Time
+	pressedAt       time.Time
+	releasedAt      time.Time
+	pendingSingle   bool
+	longActive      bool
+	suppressRelease bool
+}
+
+func NewButton(name string, pin Pin) *Button {
+	return &Button{
+		Name:        name,
+		Pin:         pin,
+		Debounce:    time.Millisecond * 30,
+		LongPress:   time.Millisecond * 600,
+		DoublePress: time.Millisecond * 400,
+	}
+}
+
+func (b *Button) OnEvent(h EventHandler) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	b.handlers = append(b.handlers, h)
+}
+
+func (b *Button) Run(ctx context.Context, interval time.Duration) {
+	every := clock.Or(b.Clock).NewTicker(interval)
+	defer every.Stop()
+
+	var failing bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-every.C():
+			pressed, err := b.Pin.Read()
+			if err != nil {
+				if !failing {
+					logrus.WithField("button", b.Name).Errorf("button read failed: %s", err)
+				}
+				failing = true
+				continue
+			}
+			if failing {
+				logrus.WithField("button", b.Name).Info("button read recovered")
+				failing = false
+			}
+
+			for _, event := range b.Sample(pressed, now) {
+				b.emit(event)
+			}
+		}
+	}
+}
+
+// Sample feeds a pin level read at a given time and returns recognized gestures.
+func (b *Button) Sample(pressed bool, now time.Time) (events []string) {
+	if pressed != b.pressed && now.Sub(b.changedAt) >= b.Debounce {
+		b.pressed = pressed
+		b.changedAt = now
+
+		if pressed {
+			b.pressedAt = now
+			if b.pendingSingle && now.Sub(b.releasedAt) <= b.DoublePress {
+				b.pendingSingle = false
+				b.suppressRelease = true
+				events = append(events, DoublePressEvent)
+			}
+		} else {
+			switch {
+			case b.longActive:
+				b.longActive = false
+				events = append(events, LongPressReleaseEvent)
+			case b.suppressRelease:
+				b.suppressRelease = false
+			default:
+				b.pendingSingle = true
+				b.releasedAt = now
+			}
+		}
+	}
+
+	if b.pressed && !b.longActive && !b.suppressRelease && now.Sub(b.pressedAt) >= b.LongPress {
+		b.longActive = true
+		b.pendingSingle = false
+		events = append(events, LongPressEvent)
+	}
+
+	if !b.pressed && b.pendingSingle && now.Sub(b.releasedAt) > b.DoublePress {
+		b.pendingSingle = false
+		events = append(events, SinglePressEvent)
+	}
+
+	return events
+}
+
+func (b *Button) emit(event string) {
+	logrus.WithField("button", b.Name).Debugf("button %s press", event)
+
+	b.l.Lock()
+	handlers := b.handlers
+	b.l.Unlock()
+
+	for _, h := range handlers {
+		h(b.Name, event)
+	}
+}
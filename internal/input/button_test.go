@@ -0,0 +1,131 @@
+package input
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+type sample struct {
+	at      time.Duration
+	pressed bool
+}
+
+func sampleButton(b *Button, samples []sample) (events []string) {
+	start := time.Unix(0, 0)
+	for _, s := range samples {
+		events = append(events, b.Sample(s.pressed, start.Add(s.at))...)
+	}
+	return events
+}
+
+func TestButtonSample(t *testing.T) {
+	ms := time.Millisecond
+
+	t.Run("short press is a single press once double press window passes", func(t *testing.T) {
+		b := NewButton("test", nil)
+		events := sampleButton(b, []sample{
+			{100 * ms, true},
+			{200 * ms, false},
+			{400 * ms, false},
+			{700 * ms, false},
+		})
+		assert.Equal(t, []string{SinglePressEvent}, events)
+	})
+
+	t.Run("two short presses are a double press", func(t *testing.T) {
+		b := NewButton("test", nil)
+		events := sampleButton(b, []sample{
+			{100 * ms, true},
+			{200 * ms, false},
+			{300 * ms, true},
+			{400 * ms, false},
+			{1000 * ms, false},
+		})
+		assert.Equal(t, []string{DoublePressEvent}, events)
+	})
+
+	t.Run("held press is a long press released on button release", func(t *testing.T) {
+		b := NewButton("test", nil)
+		events := sampleButton(b, []sample{
+			{100 * ms, true},
+			{500 * ms, true},
+			{800 * ms, true},
+			{2000 * ms, true},
+			{2100 * ms, false},
+			{3000 * ms, false},
+		})
+		assert.Equal(t, []string{LongPressEvent, LongPressReleaseEvent}, events)
+	})
+
+	t.Run("bouncing contact is debounced", func(t *testing.T) {
+		b := NewButton("test", nil)
+		events := sampleButton(b, []sample{
+			{100 * ms, true},
+			{110 * ms, false},
+			{120 * ms, true},
+			{200 * ms, false},
+			{1000 * ms, false},
+		})
+		assert.Equal(t, []string{SinglePressEvent}, events)
+	})
+}
+
+type fakePin struct {
+	l       sync.Mutex
+	pressed bool
+	reads   int
+}
+
+func (p *fakePin) Read() (bool, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	p.reads++
+	return p.pressed, nil
+}
+
+func (p *fakePin) press(pressed bool) (reads int) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	p.pressed = pressed
+	return p.reads
+}
+
+func (p *fakePin) readCount() int {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	return p.reads
+}
+
+func TestButtonRun(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	pin := &fakePin{}
+	b := NewButton("test", pin)
+	b.Clock = c
+	events := make(chan string, 10)
+	b.OnEvent(func(_ string, event string) { events <- event })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx, time.Millisecond*20)
+	c.BlockUntil(1)
+
+	// sample samples the pin n times on the clock ticks
+	sample := func(pressed bool, n int) {
+		for i := 0; i < n; i++ {
+			reads := pin.press(pressed)
+			c.Advance(time.Millisecond * 20)
+			assert.Eventually(t, func() bool { return pin.readCount() > reads }, time.Second, time.Millisecond)
+		}
+	}
+	sample(true, 5)
+	sample(false, 25)
+	assert.Equal(t, SinglePressEvent, <-events)
+}
@@ -0,0 +1,40 @@
+package input
+
+import (
+	"errors"
+
+	"github.com/racerxdl/go-mcp23017"
+)
+
+type Pin interface {
+	// Read returns true when the pin is in an active (pressed) state.
+	Read() (bool, error)
+}
+
+type Mcp23017Pin struct {
+	device    *mcp23017.Device
+	pin       uint8
+	activeLow bool
+}
+
+func NewMcp23017Pin(device *mcp23017.Device, pin uint8, pullUp bool, activeLow bool) (p *Mcp23017Pin, err error) {
+	p = &Mcp23017Pin{device: device, pin: pin, activeLow: activeLow}
+	if err = p.device.PinMode(pin, mcp23017.INPUT); err != nil {
+		return p, err
+	}
+	err = p.device.SetPullUp(pin, pullUp)
+	return p, err
+}
+
+func (m *Mcp23017Pin) Read() (bool, error) {
+	if !m.device.IsPresent() {
+		return false, errors.New("device not alive")
+	}
+
+	level, err := m.device.DigitalRead(m.pin)
+	if err != nil {
+		return false, err
+	}
+
+	return bool(level) != m.activeLow, nil
+}
@@ -0,0 +1,26 @@
+package logging
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	TextFormat   = "text"
+	JSONFormat   = "json"
+	LogfmtFormat = "logfmt"
+)
+
+func Formatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case TextFormat, "":
+		return &logrus.TextFormatter{FullTimestamp: true}, nil
+	case LogfmtFormat:
+		return &logrus.TextFormatter{FullTimestamp: true, DisableColors: true}, nil
+	case JSONFormat:
+		return &logrus.JSONFormatter{}, nil
+	}
+
+	return nil, fmt.Errorf("%s is not supported log format", format)
+}
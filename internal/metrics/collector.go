@@ -0,0 +1,283 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	CommandErrorKind       = "command"
+	RelayErrorKind         = "relay"
+	WindowContactErrorKind = "window_contact"
+)
+
+var shutterStates = []string{
+	shutter.ShutterOpenState,
+	shutter.ShutterClosedState,
+	shutter.ShutterOpeningState,
+	shutter.ShutterClosingState,
+}
+
+// Collector holds shutter2mqtt metrics and instruments shutters, relays and the MQTT connection.
+// Every Collector has its own Prometheus registry.
+type Collector struct {
+	registry *prometheus.Registry
+	handler  http.Handler
+
+	shutterPosition *prometheus.GaugeVec
+	shutterState    *prometheus.GaugeVec
+	shutterMoving   *prometheus.GaugeVec
+
+	commands         *prometheus.CounterVec
+	errors           *prometheus.CounterVec
+	positionRestores *prometheus.CounterVec
+
+	relayActivations *prometheus.CounterVec
+	relayOnSeconds   *prometheus.CounterVec
+	relayPoolWait    *prometheus.HistogramVec
+	relayWatchdog    *prometheus.CounterVec
+
+	mqttConnected      prometheus.Gauge
+	mqttConnections    prometheus.Counter
+	mqttConnectionLost prometheus.Counter
+	mqttReconnects     prometheus.Counter
+
+	instrumentedL sync.Mutex
+	// instrumented counts instrumented shutters by name, a replacement is instrumented before the replaced one is removed
+	instrumented map[string]int
+}
+
+func NewCollector() *Collector {
+	c := &Collector{registry: prometheus.NewRegistry(), instrumented: map[string]int{}}
+	c.handler = promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{})
+
+	c.shutterPosition = c.gaugeVec("shutter2mqtt_shutter_position", "Current shutter position.", "shutter")
+	c.shutterState = c.gaugeVec("shutter2mqtt_shutter_state", "Current shutter state, 1 for the active one.", "shutter", "state")
+	c.shutterMoving = c.gaugeVec("shutter2mqtt_shutter_moving", "Whether a shutter is moving.", "shutter")
+
+	c.commands = c.counterVec("shutter2mqtt_shutter_commands_total", "Shutter commands received.", "shutter", "command", "source")
+	c.errors = c.counterVec("shutter2mqtt_shutter_errors_total", "Shutter errors.", "shutter", "kind")
+	c.positionRestores = c.counterVec("shutter2mqtt_shutter_position_restores_total", "Shutter positions restored from a retained state.", "shutter")
+
+	c.relayActivations = c.counterVec("shutter2mqtt_relay_activations_total", "Relay activations.", "shutter", "relay")
+	c.relayOnSeconds = c.counterVec("shutter2mqtt_relay_on_seconds_total", "Time relays spent enabled.", "shutter", "relay")
+	c.relayPoolWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shutter2mqtt_relay_pool_wait_seconds",
+		Help:    "Time spent waiting for a free relay pool slot.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120},
+	}, []string{"shutter", "relay"})
+	c.registry.MustRegister(c.relayPoolWait)
+	c.relayWatchdog = c.counterVec("shutter2mqtt_relay_watchdog_violations_total", "Relays force disabled by the watchdog.", "shutter", "relay")
+
+	c.mqttConnected = c.gaugeVec("shutter2mqtt_mqtt_connected", "Whether the MQTT broker is connected.").WithLabelValues()
+	c.mqttConnections = c.counterVec("shutter2mqtt_mqtt_connections_total", "MQTT broker connections established.").WithLabelValues()
+	c.mqttConnectionLost = c.counterVec("shutter2mqtt_mqtt_connection_lost_total", "MQTT broker connections lost.").WithLabelValues()
+	c.mqttReconnects = c.counterVec("shutter2mqtt_mqtt_reconnects_total", "MQTT broker reconnect attempts.").WithLabelValues()
+
+	return c
+}
+
+func (c *Collector) gaugeVec(name, help string, labelNames ...string) *prometheus.GaugeVec {
+	v := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+	c.registry.MustRegister(v)
+	return v
+}
+
+func (c *Collector) counterVec(name, help string, labelNames ...string) *prometheus.CounterVec {
+	v := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)
+	c.registry.MustRegister(v)
+	return v
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handler.ServeHTTP(w, r)
+}
+
+// InstrumentShutter tracks the shutter state and counts commands passed through the returned shutter.
+// The state is tracked until unsubscribe is called, e.g. once the shutter is removed. Then its state
+// gauges are deleted unless another shutter of the same name is instrumented, counters are kept.
+func (c *Collector) InstrumentShutter(s shutter.Shutter) (instrumented shutter.Shutter, unsubscribe func()) {
+	c.instrumentedL.Lock()
+	c.instrumented[s.Name()]++
+	c.instrumentedL.Unlock()
+	c.observeEvent(shutter.Event{Shutter: s.Name(), State: s.State(), Position: s.Position()})
+
+	// an event delivered meanwhile must not bring deleted gauges back
+	var l sync.Mutex
+	var removed bool
+	unsubscribeEvents := s.Subscribe(func(e shutter.Event) {
+		l.Lock()
+		defer l.Unlock()
+
+		if !removed {
+			c.observeEvent(e)
+		}
+	})
+
+	return &instrumentedShutter{Shutter: s, c: c}, func() {
+		unsubscribeEvents()
+
+		l.Lock()
+		defer l.Unlock()
+
+		removed = true
+		c.forgetShutter(s.Name())
+	}
+}
+
+// forgetShutter deletes the state gauges of a shutter, once no shutter of its name is instrumented.
+func (c *Collector) forgetShutter(name string) {
+	c.instrumentedL.Lock()
+	defer c.instrumentedL.Unlock()
+
+	c.instrumented[name]--
+	if c.instrumented[name] > 0 {
+		return
+	}
+	delete(c.instrumented, name)
+
+	c.shutterPosition.DeleteLabelValues(name)
+	c.shutterMoving.DeleteLabelValues(name)
+	for _, state := range shutterStates {
+		c.shutterState.DeleteLabelValues(name, state)
+	}
+}
+
+// InstrumentRelay counts activations, on-time and errors of a relay.
+// It should wrap the relay before a pool proxy, so pool waiting is not counted as on-time.
+func (c *Collector) InstrumentRelay(shutterName, relayName string, r relay.Relay) relay.Relay {
+	return &instrumentedRelay{Relay: r, c: c, shutter: shutterName, relay: relayName}
+}
+
+func (c *Collector) ObservePoolProxy(shutterName, relayName string, p *relay.PoolProxy) {
+	p.OnWait(func(wait time.Duration) {
+		c.relayPoolWait.WithLabelValues(shutterName, relayName).Observe(wait.Seconds())
+	})
+}
+
+func (c *Collector) ObserveWatchdog(w *relay.Watchdog) {
+	w.OnViolation(func(v relay.Violation) {
+		c.relayWatchdog.WithLabelValues(v.Shutter, v.Relay).Inc()
+	})
+}
+
+func (c *Collector) MQTTConnected() {
+	c.mqttConnected.Set(1)
+	c.mqttConnections.Inc()
+}
+
+func (c *Collector) MQTTConnectionLost() {
+	c.mqttConnected.Set(0)
+	c.mqttConnectionLost.Inc()
+}
+
+func (c *Collector) MQTTReconnecting() {
+	c.mqttReconnects.Inc()
+}
+
+func (c *Collector) observeEvent(e shutter.Event) {
+	c.shutterPosition.WithLabelValues(e.Shutter).Set(float64(e.Position))
+
+	for _, state := range shutterStates {
+		var active float64
+		if state == e.State {
+			active = 1
+		}
+		c.shutterState.WithLabelValues(e.Shutter, state).Set(active)
+	}
+
+	var moving float64
+	if e.State == shutter.ShutterOpeningState || e.State == shutter.ShutterClosingState {
+		moving = 1
+	}
+	c.shutterMoving.WithLabelValues(e.Shutter).Set(moving)
+}
+
+func (c *Collector) observeCommand(ctx context.Context, name, command string, err error) {
+	c.commands.WithLabelValues(name, command, shutter.SourceFromContext(ctx)).Inc()
+	if err == nil {
+		return
+	}
+
+	kind := CommandErrorKind
+	var contactErr *shutter.WindowContactError
+	if errors.As(err, &contactErr) {
+		kind = WindowContactErrorKind
+	}
+	c.errors.WithLabelValues(name, kind).Inc()
+}
+
+type instrumentedShutter struct {
+	shutter.Shutter
+	c *Collector
+}
+
+func (s *instrumentedShutter) Open(ctx context.Context) error {
+	err := s.Shutter.Open(ctx)
+	s.c.observeCommand(ctx, s.Name(), "open", err)
+	return err
+}
+
+func (s *instrumentedShutter) Close(ctx context.Context) error {
+	err := s.Shutter.Close(ctx)
+	s.c.observeCommand(ctx, s.Name(), "close", err)
+	return err
+}
+
+func (s *instrumentedShutter) Stop(ctx context.Context) error {
+	err := s.Shutter.Stop(ctx)
+	s.c.observeCommand(ctx, s.Name(), "stop", err)
+	return err
+}
+
+func (s *instrumentedShutter) SetPosition(ctx context.Context, position int) error {
+	err := s.Shutter.SetPosition(ctx, position)
+	s.c.observeCommand(ctx, s.Name(), "set_position", err)
+	return err
+}
+
+func (s *instrumentedShutter) ResetPosition(position int) error {
+	stateless, ok := s.Shutter.(shutter.StatelessShutter)
+	if !ok {
+		return fmt.Errorf("%s: shutter is not stateless", s.Name())
+	}
+
+	if err := stateless.ResetPosition(position); err != nil {
+		return err
+	}
+	s.c.positionRestores.WithLabelValues(s.Name()).Inc()
+	return nil
+}
+
+type instrumentedRelay struct {
+	relay.Relay
+	c       *Collector
+	shutter string
+	relay   string
+}
+
+func (r *instrumentedRelay) EnableFor(ctx context.Context, duration time.Duration) error {
+	r.c.relayActivations.WithLabelValues(r.shutter, r.relay).Inc()
+
+	start := time.Now()
+	err := r.Relay.EnableFor(ctx, duration)
+	r.c.relayOnSeconds.WithLabelValues(r.shutter, r.relay).Add(time.Since(start).Seconds())
+
+	if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+		r.c.errors.WithLabelValues(r.shutter, RelayErrorKind).Inc()
+	}
+	return err
+}
+
+func (r *instrumentedRelay) Unwrap() relay.Relay {
+	return r.Relay
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	c.MQTTConnected()
+	c.observeCommand(context.Background(), "kitchen", "open", errors.New("failed"))
+	r := c.InstrumentRelay("kitchen", "up", &relay.Dumb{})
+	assert.NoError(t, r.EnableFor(context.Background(), time.Millisecond))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.mqttConnected))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.relayActivations))
+	assert.NoError(t, testutil.GatherAndCompare(c.registry, strings.NewReader(`
+# HELP shutter2mqtt_shutter_errors_total Shutter errors.
+# TYPE shutter2mqtt_shutter_errors_total counter
+shutter2mqtt_shutter_errors_total{kind="command",shutter="kitchen"} 1
+`), "shutter2mqtt_shutter_errors_total"))
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "shutter2mqtt_mqtt_connected 1")
+}
+
+func TestCollectorInstrumentShutter(t *testing.T) {
+	c := NewCollector()
+	s := relay.NewRelaysShutter("kitchen", &relay.Dumb{}, &relay.Dumb{}, 100, 0, time.Second)
+	assert.NoError(t, s.ResetPosition(40))
+
+	_, unsubscribe := c.InstrumentShutter(s)
+	assert.Equal(t, 40.0, testutil.ToFloat64(c.shutterPosition.WithLabelValues("kitchen")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.shutterState.WithLabelValues("kitchen", shutter.ShutterOpenState)))
+
+	_, unsubscribeReplacement := c.InstrumentShutter(relay.NewRelaysShutter("kitchen", &relay.Dumb{}, &relay.Dumb{}, 100, 0, time.Second))
+	unsubscribe()
+	assert.Equal(t, 1, testutil.CollectAndCount(c.shutterPosition), "series kept for a replacement")
+
+	unsubscribeReplacement()
+	assert.Zero(t, testutil.CollectAndCount(c.shutterPosition), "series of a removed shutter deleted")
+	assert.Zero(t, testutil.CollectAndCount(c.shutterState))
+	assert.Zero(t, testutil.CollectAndCount(c.shutterMoving))
+}
@@ -0,0 +1,32 @@
+package mqtt
+
+import (
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+const (
+	AvailabilityTopic   = "shutter2mqtt/availability"
+	AvailabilityOnline  = "online"
+	AvailabilityOffline = "offline"
+)
+
+// SetAvailabilityWill makes the broker publish offline availability when the connection drops unexpectedly.
+func SetAvailabilityWill(opts *paho.ClientOptions) *paho.ClientOptions {
+	return opts.SetWill(AvailabilityTopic, AvailabilityOffline, 0, true)
+}
+
+func PublishAvailability(client paho.Client, online bool, timeout time.Duration) error {
+	payload := AvailabilityOffline
+	if online {
+		payload = AvailabilityOnline
+	}
+
+	token := client.Publish(AvailabilityTopic, 0, true, payload)
+	if !token.WaitTimeout(timeout) {
+		return errors.New("MQTT availability publish timed out")
+	}
+	return token.Error()
+}
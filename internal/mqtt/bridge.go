@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/jkaflik/shutter2mqtt/internal/shutter"
@@ -25,28 +28,87 @@ type Bridge struct {
 	StateTopic    string
 	PositionTopic string
 	MetadataTopic string
+	ErrorTopic    string
+	// CoolingDownTopic reports whether the motor is protected from overheating and does not accept moves.
+	CoolingDownTopic string
+	// ObstructedTopic reports whether the motor stopped mid-travel, until the next move.
+	ObstructedTopic string
+	// ProblemTopic reports the active fault of a shutter, see ProblemPayload.
+	ProblemTopic string
+	// TruePositionTopic is a debug topic with the true position of a shutter, see SetTruePosition.
+	TruePositionTopic string
+	// CalibrationTopic is the retained result of the last calibration, see SetCalibrator.
+	CalibrationTopic string
 
 	CommandTopic        string
 	PositionChangeTopic string
+	CalibrateTopic      string
+
+	WindowContactTopic         string
+	WindowContactOpenPayload   string
+	WindowContactClosedPayload string
+
+	// PowerTopic has readings of the power drawn by the motor, see SetPowerTopic.
+	PowerTopic string
+
+	windowContact *shutter.WindowContactGuard
+
+	calibrator        shutter.Calibrator
+	calibrationL      sync.Mutex
+	cancelCalibration context.CancelFunc
+
+	power shutter.PowerNotifier
+
+	subscribed int32
+	// subscribing is 1 once Subscribe is called, until Unsubscribe
+	subscribing int32
+
+	unsubscribeShutter      func()
+	unsubscribeTruePosition func()
+
+	log *logrus.Entry
+}
+
+// StateTopic returns the topic with the state of a shutter.
+func StateTopic(shutterName string) string {
+	return fmt.Sprintf("shutter2mqtt/%s/state", shutterName)
+}
+
+// ErrorTopic returns the topic shutter command errors are reported on.
+func ErrorTopic(shutterName string) string {
+	return fmt.Sprintf("shutter2mqtt/%s/error", shutterName)
 }
 
 func NewBridge(mqtt mqtt.Client, shutter shutter.Shutter) (*Bridge, error) {
 	bridge := &Bridge{mqtt: mqtt, shutter: shutter}
-	bridge.StateTopic = fmt.Sprintf("shutter2mqtt/%s/state", shutter.Name())
+	bridge.log = logrus.WithField("shutter", shutter.Name())
+	bridge.StateTopic = StateTopic(shutter.Name())
 	bridge.PositionTopic = fmt.Sprintf("shutter2mqtt/%s/position", shutter.Name())
 	bridge.MetadataTopic = fmt.Sprintf("shutter2mqtt/%s/metadata", shutter.Name())
+	bridge.ErrorTopic = ErrorTopic(shutter.Name())
+	bridge.CoolingDownTopic = fmt.Sprintf("shutter2mqtt/%s/cooling_down", shutter.Name())
+	bridge.ObstructedTopic = fmt.Sprintf("shutter2mqtt/%s/obstructed", shutter.Name())
+	bridge.ProblemTopic = fmt.Sprintf("shutter2mqtt/%s/problem", shutter.Name())
+	bridge.TruePositionTopic = fmt.Sprintf("shutter2mqtt/%s/debug/true_position", shutter.Name())
 	bridge.CommandTopic = fmt.Sprintf("shutter2mqtt/%s/set", shutter.Name())
 	bridge.PositionChangeTopic = fmt.Sprintf("shutter2mqtt/%s/position/set", shutter.Name())
+	bridge.CalibrateTopic = CalibrateTopic(shutter.Name())
+	bridge.CalibrationTopic = CalibrationTopic(shutter.Name())
 
 	if err := bridge.restorePosition(); err != nil {
 		return nil, err
 	}
 
-	shutter.OnUpdate(bridge.onShutterUpdateHandler())
+	bridge.unsubscribeShutter = shutter.Subscribe(bridge.onShutterEventHandler())
+	bridge.publishProblem(currentFault(shutter)) // a retained problem of the last run is stale
 
 	return bridge, nil
 }
 
+func (b *Bridge) Shutter() shutter.Shutter {
+	return b.shutter
+}
+
 func (b *Bridge) SetMetadata(value interface{}) error {
 	payload, err := json.Marshal(value)
 	if err != nil {
@@ -60,42 +122,179 @@ func (b *Bridge) SetMetadata(value interface{}) error {
 	return nil
 }
 
-func (b *Bridge) Subscribe(ctx context.Context) error {
-	go func() {
-		<-ctx.Done()
+func (b *Bridge) SetWindowContact(guard *shutter.WindowContactGuard, topic, openPayload, closedPayload string) {
+	b.windowContact = guard
+	b.WindowContactTopic = topic
+	b.WindowContactOpenPayload = openPayload
+	b.WindowContactClosedPayload = closedPayload
+}
 
-		if token := b.mqtt.Unsubscribe(b.PositionChangeTopic, b.CommandTopic); token.Wait() && token.Error() != nil {
-			logrus.Errorf("%s: MQTT topics unsubscribe failed: %s", b.shutter.Name(), token.Error())
+// SetTruePosition publishes the true position reported by r on TruePositionTopic,
+// to compare the estimated position with, e.g. of a simulated shutter.
+func (b *Bridge) SetTruePosition(r shutter.TruePositionReporter) {
+	b.unsubscribeTruePosition = r.SubscribeTruePosition(func(e shutter.Event) {
+		if token := b.mqtt.Publish(b.TruePositionTopic, 0, false, strconv.Itoa(e.Position)); token.Wait() && token.Error() != nil {
+			b.log.Errorf("MQTT true position publish failed: %s", token.Error())
 		}
-	}()
+	})
+}
+
+// Subscribed reports whether the last Subscribe call succeeded.
+func (b *Bridge) Subscribed() bool {
+	return atomic.LoadInt32(&b.subscribed) == 1
+}
+
+// Subscribe subscribes command topics. Commands received once ctx is done are ignored.
+func (b *Bridge) Subscribe(ctx context.Context) error {
+	atomic.StoreInt32(&b.subscribed, 0)
+	atomic.StoreInt32(&b.subscribing, 1)
 
 	if token := b.mqtt.Subscribe(b.CommandTopic, 0, b.onCommandHandler(ctx)); token.Wait() && token.Error() != nil {
 		return errors.Wrapf(token.Error(), "%s: MQTT command topic subscription failed:", b.shutter.Name())
 	}
-	logrus.Infof("%s: MQTT command topic subscribed", b.shutter.Name())
+	b.log.Info("MQTT command topic subscribed")
 	if token := b.mqtt.Subscribe(b.PositionChangeTopic, 0, b.onPositionChangeHandler(ctx)); token.Wait() && token.Error() != nil {
 		return errors.Wrapf(token.Error(), "%s: MQTT position change topic subscription failed", b.shutter.Name())
 	}
-	logrus.Infof("%s: MQTT position change topic subscribed", b.shutter.Name())
+	b.log.Info("MQTT position change topic subscribed")
 
+	if b.windowContact != nil {
+		if token := b.mqtt.Subscribe(b.WindowContactTopic, 0, b.onWindowContactHandler(ctx)); token.Wait() && token.Error() != nil {
+			return errors.Wrapf(token.Error(), "%s: MQTT window contact topic subscription failed", b.shutter.Name())
+		}
+		b.log.Info("MQTT window contact topic subscribed")
+	}
+
+	if b.PowerTopic != "" {
+		if token := b.mqtt.Subscribe(b.PowerTopic, 0, b.onPowerHandler()); token.Wait() && token.Error() != nil {
+			return errors.Wrapf(token.Error(), "%s: MQTT power topic subscription failed", b.shutter.Name())
+		}
+		b.log.Info("MQTT power topic subscribed")
+	}
+
+	if b.calibrator != nil {
+		if token := b.mqtt.Subscribe(b.CalibrateTopic, 0, b.onCalibrateHandler(ctx)); token.Wait() && token.Error() != nil {
+			return errors.Wrapf(token.Error(), "%s: MQTT calibrate topic subscription failed", b.shutter.Name())
+		}
+		b.log.Info("MQTT calibrate topic subscribed")
+	}
+
+	atomic.StoreInt32(&b.subscribed, 1)
+	return nil
+}
+
+func (b *Bridge) Unsubscribe(timeout time.Duration) error {
+	atomic.StoreInt32(&b.subscribed, 0)
+	atomic.StoreInt32(&b.subscribing, 0)
+
+	topics := []string{b.PositionChangeTopic, b.CommandTopic}
+	if b.windowContact != nil {
+		topics = append(topics, b.WindowContactTopic)
+	}
+	if b.calibrator != nil {
+		topics = append(topics, b.CalibrateTopic)
+	}
+	if b.PowerTopic != "" {
+		topics = append(topics, b.PowerTopic)
+	}
+
+	token := b.mqtt.Unsubscribe(topics...)
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("%s: MQTT topics unsubscribe timed out", b.shutter.Name())
+	}
+	if token.Error() != nil {
+		return errors.Wrapf(token.Error(), "%s: MQTT topics unsubscribe failed", b.shutter.Name())
+	}
 	return nil
 }
 
-func (b *Bridge) onShutterUpdateHandler() shutter.ShutterUpdateHandler {
-	return func(state string, position int) {
-		if token := b.mqtt.Publish(b.StateTopic, 0, true, state); token.Wait() && token.Error() != nil {
-			logrus.Errorf("%s: MQTT state publish failed: %s", b.shutter.Name(), token.Error())
+// Close unsubscribes command topics and stops publishing shutter updates. Topics are left subscribed
+// when Subscribe was not called, e.g. by a running bridge of the same shutter name.
+func (b *Bridge) Close(timeout time.Duration) error {
+	b.unsubscribeShutter()
+	if b.unsubscribeTruePosition != nil {
+		b.unsubscribeTruePosition()
+	}
+
+	if atomic.LoadInt32(&b.subscribing) == 0 {
+		return nil
+	}
+	return b.Unsubscribe(timeout)
+}
+
+// PublishState publishes the current state and position and waits until they are delivered.
+// The retained position is restored on the next start.
+func (b *Bridge) PublishState(timeout time.Duration) error {
+	publish := map[string]string{
+		b.StateTopic:    b.shutter.State(),
+		b.PositionTopic: strconv.Itoa(b.shutter.Position()),
+	}
+	for topic, payload := range publish {
+		token := b.mqtt.Publish(topic, 0, true, payload)
+		if !token.WaitTimeout(timeout) {
+			return fmt.Errorf("%s: MQTT state publish timed out", b.shutter.Name())
+		}
+		if token.Error() != nil {
+			return errors.Wrapf(token.Error(), "%s: MQTT state publish failed", b.shutter.Name())
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) onShutterEventHandler() shutter.EventHandler {
+	return func(e shutter.Event) {
+		if token := b.mqtt.Publish(b.StateTopic, 0, true, e.State); token.Wait() && token.Error() != nil {
+			b.log.Errorf("MQTT state publish failed: %s", token.Error())
+		}
+		if token := b.mqtt.Publish(b.PositionTopic, 0, true, fmt.Sprintf("%d", e.Position)); token.Wait() && token.Error() != nil {
+			b.log.Errorf("MQTT position publish failed: %s", token.Error())
+		}
+		if token := b.mqtt.Publish(b.CoolingDownTopic, 0, true, strconv.FormatBool(e.CoolingDown)); token.Wait() && token.Error() != nil {
+			b.log.Errorf("MQTT cooling down publish failed: %s", token.Error())
+		}
+		if token := b.mqtt.Publish(b.ObstructedTopic, 0, true, strconv.FormatBool(e.Obstructed)); token.Wait() && token.Error() != nil {
+			b.log.Errorf("MQTT obstructed publish failed: %s", token.Error())
 		}
-		if token := b.mqtt.Publish(b.PositionTopic, 0, true, fmt.Sprintf("%d", position)); token.Wait() && token.Error() != nil {
-			logrus.Errorf("%s: MQTT position publish failed: %s", b.shutter.Name(), token.Error())
+		b.publishProblem(e.Fault)
+	}
+}
+
+// publishProblem publishes fault on ProblemTopic, no problem when it is nil.
+func (b *Bridge) publishProblem(fault *shutter.Fault) {
+	if payload, err := json.Marshal(ProblemPayload{Problem: fault != nil, Fault: fault}); err != nil {
+		b.log.Errorf("MQTT problem publish failed: %s", err)
+	} else if token := b.mqtt.Publish(b.ProblemTopic, 0, true, payload); token.Wait() && token.Error() != nil {
+		b.log.Errorf("MQTT problem publish failed: %s", token.Error())
+	}
+}
+
+// currentFault returns the active fault of s, nil when there is none or s does not report faults.
+func currentFault(s shutter.Shutter) *shutter.Fault {
+	if r, ok := s.(shutter.FaultReporter); ok {
+		if f, ok := r.Fault(); ok {
+			return &f
 		}
 	}
+	return nil
+}
+
+// ProblemPayload is published retained on ProblemTopic, with the code, message and since of the active fault.
+type ProblemPayload struct {
+	Problem bool `json:"problem"`
+	*shutter.Fault
 }
 
 var unsupportedCommandErr = errors.New("unsupported command received")
 
 func (b *Bridge) onCommandHandler(ctx context.Context) mqtt.MessageHandler {
+	ctx = shutter.WithSource(ctx, shutter.SourceMQTT)
 	return func(c mqtt.Client, msg mqtt.Message) {
+		if ctx.Err() != nil {
+			b.log.Warnf("MQTT message on %s ignored, shutting down", msg.Topic())
+			return
+		}
+		ctx := shutter.WithOrigin(shutter.WithRequestID(ctx, shutter.NewRequestID()), msg.Topic())
+
 		var err error
 		cmd := string(msg.Payload())
 		switch cmd {
@@ -110,49 +309,101 @@ func (b *Bridge) onCommandHandler(ctx context.Context) mqtt.MessageHandler {
 		}
 
 		if err != nil {
-			logrus.Errorf("%s: MQTT %s command: %s", b.shutter.Name(), cmd, err.Error())
+			shutter.Logger(ctx, b.log).WithField("command", cmd).Errorf("MQTT command: %s", err)
+			b.PublishError(err)
 		}
 	}
 }
 
 func (b *Bridge) onPositionChangeHandler(ctx context.Context) mqtt.MessageHandler {
+	ctx = shutter.WithSource(ctx, shutter.SourceMQTT)
 	return func(c mqtt.Client, msg mqtt.Message) {
+		if ctx.Err() != nil {
+			b.log.Warnf("MQTT message on %s ignored, shutting down", msg.Topic())
+			return
+		}
+		ctx := shutter.WithOrigin(shutter.WithRequestID(ctx, shutter.NewRequestID()), msg.Topic())
+		log := shutter.Logger(ctx, b.log).WithField("command", "set_position")
+
 		pos, err := strconv.Atoi(string(msg.Payload()))
 		if err != nil {
-			logrus.Error(err)
+			log.Errorf("MQTT position change: %s", err)
+			b.PublishError(err)
+			return
 		}
 		if err := b.shutter.SetPosition(ctx, pos); err != nil {
-			logrus.Error(err)
+			log.WithField("target", pos).Errorf("MQTT position change: %s", err)
+			b.PublishError(err)
+		}
+	}
+}
+
+func (b *Bridge) onWindowContactHandler(ctx context.Context) mqtt.MessageHandler {
+	ctx = shutter.WithSource(ctx, shutter.SourceWindowContact)
+	return func(c mqtt.Client, msg mqtt.Message) {
+		if ctx.Err() != nil {
+			b.log.Warnf("MQTT message on %s ignored, shutting down", msg.Topic())
+			return
+		}
+		ctx := shutter.WithOrigin(shutter.WithRequestID(ctx, shutter.NewRequestID()), msg.Topic())
+
+		var open bool
+		switch payload := string(msg.Payload()); payload {
+		case b.WindowContactOpenPayload:
+			open = true
+		case b.WindowContactClosedPayload:
+			open = false
+		default:
+			b.log.Warnf("MQTT window contact: unknown payload %q", payload)
+			return
+		}
+
+		log := shutter.Logger(ctx, b.log)
+		log.Infof("window contact open: %t", open)
+		if err := b.windowContact.SetWindowOpen(ctx, open); err != nil {
+			log.Warn(err)
+			b.PublishError(err)
 		}
 	}
 }
 
+// PublishError reports a shutter error on the error topic.
+func (b *Bridge) PublishError(err error) {
+	if token := b.mqtt.Publish(b.ErrorTopic, 0, false, err.Error()); token.Wait() && token.Error() != nil {
+		b.log.Errorf("MQTT error publish failed: %s", token.Error())
+	}
+}
+
 func (b *Bridge) restorePosition() error {
 	shutter, ok := b.shutter.(shutter.StatelessShutter)
 	if !ok {
-		logrus.Warnf("%s: MQTT position restore: shutter is not stateless", b.shutter.Name())
+		b.log.Warn("MQTT position restore: shutter is not stateless")
 		return nil
 	}
 
 	restoreHandler := func(c mqtt.Client, msg mqtt.Message) {
 		pos, err := strconv.Atoi(string(msg.Payload()))
 		if err != nil {
-			logrus.Error(err)
+			b.log.Errorf("MQTT position restore: %s", err)
 			return
 		}
 		if err := shutter.ResetPosition(pos); err != nil {
-			logrus.Errorf("%s: MQTT position restore failed: %s", b.shutter.Name(), err)
+			b.log.Errorf("MQTT position restore failed: %s", err)
 			return
 		}
 
-		logrus.Infof("%s: MQTT position restored to %d", b.shutter.Name(), pos)
+		b.log.Infof("MQTT position restored to %d", pos)
 
-		if token := b.mqtt.Unsubscribe(b.PositionTopic); token.Wait() && token.Error() != nil {
-			logrus.Errorf("%s: MQTT position restore topic unsubscribe failed: %s", b.shutter.Name(), token.Error())
-			return
-		}
+		// waiting in the handler blocks the client from routing messages, also the unsubscribe ack
+		token := b.mqtt.Unsubscribe(b.PositionTopic)
+		go func() {
+			if token.Wait() && token.Error() != nil {
+				b.log.Errorf("MQTT position restore topic unsubscribe failed: %s", token.Error())
+				return
+			}
 
-		logrus.Debugf("%s: MQTT position restore topic unsubscribed", b.shutter.Name())
+			b.log.Debug("MQTT position restore topic unsubscribed")
+		}()
 	}
 
 	if token := b.mqtt.Subscribe(b.PositionTopic, 0, restoreHandler); token.Wait() && token.Error() != nil {
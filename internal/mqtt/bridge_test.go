@@ -0,0 +1,354 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt/mqtttest"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
+	"github.com/stretchr/testify/assert"
+)
+
+func connect(t *testing.T, broker *mqtttest.Broker) paho.Client {
+	t.Helper()
+
+	opts := SetAvailabilityWill(paho.NewClientOptions().AddBroker(broker.URL()).SetClientID(t.Name()))
+	client := paho.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client
+}
+
+func newTestBridge(t *testing.T, client paho.Client) *Bridge {
+	t.Helper()
+
+	s := relay.NewRelaysShutter("kitchen", &relay.Dumb{}, &relay.Dumb{}, 100, 0, time.Millisecond*200)
+	b, err := NewBridge(client, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close(time.Second) })
+	return b
+}
+
+func TestBridgeCommands(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	client := connect(t, broker)
+	c := clock.NewFake(time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := relay.NewRelaysShutter("kitchen", &relay.Dumb{Clock: c}, &relay.Dumb{Clock: c}, 100, 0, time.Millisecond*200)
+	s.SetClock(c)
+	// a retained position is restored before any command arrives, so later position updates are not taken for it
+	broker.Publish("shutter2mqtt/kitchen/position", "0", true)
+	b, err := NewBridge(client, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, b.Subscribe(ctx))
+	assert.True(t, b.Subscribed())
+
+	messages := broker.Subscribe("shutter2mqtt/kitchen/#")
+	// travel waits until a move runs its relay and position calculation ticker, then lets d pass
+	travel := func(d time.Duration) {
+		c.BlockUntil(2)
+		c.Advance(d)
+	}
+
+	broker.Publish("shutter2mqtt/kitchen/set", "open", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.ShutterOpeningState)
+	travel(time.Millisecond * 200)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.ShutterOpenState)
+	broker.WaitForRetained(t, "shutter2mqtt/kitchen/position", "100")
+
+	broker.Publish("shutter2mqtt/kitchen/position/set", "50", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.ShutterClosingState)
+	travel(time.Millisecond * 100)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/position", "50")
+	assert.Eventually(t, func() bool { return b.Shutter().Position() == 50 }, time.Second, time.Millisecond)
+
+	broker.Publish("shutter2mqtt/kitchen/set", "close", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.ShutterClosingState)
+	travel(time.Millisecond * 20)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/position", "40")
+	broker.Publish("shutter2mqtt/kitchen/set", "stop", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.ShutterOpenState)
+	assert.Equal(t, 40, b.Shutter().Position(), "stopped at the position reached")
+	broker.WaitForRetained(t, "shutter2mqtt/kitchen/position", "40")
+
+	broker.Publish("shutter2mqtt/kitchen/set", "tilt", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/error", unsupportedCommandErr.Error())
+	broker.Publish("shutter2mqtt/kitchen/position/set", "101", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/error", "kitchen: 101 is out of range open/close targetPosition for (100/0)")
+
+	cancel()
+	messages.Drain()
+	broker.Publish("shutter2mqtt/kitchen/set", "open", false)
+	time.Sleep(time.Millisecond * 50)
+	assert.NotEqual(t, shutter.ShutterOpeningState, b.Shutter().State(), "commands are ignored once ctx is done")
+}
+
+func TestBridgeRestorePosition(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	broker.Publish("shutter2mqtt/kitchen/position", "0", true)
+	client := connect(t, broker)
+
+	b := newTestBridge(t, client)
+	assert.Eventually(t, func() bool {
+		return b.Shutter().State() == shutter.ShutterClosedState
+	}, time.Second, time.Millisecond*10, "closed on the restored full close position")
+
+	broker.Publish("shutter2mqtt/kitchen/position", "30", true)
+	b = newTestBridge(t, client)
+	assert.Eventually(t, func() bool {
+		return b.Shutter().Position() == 30
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, shutter.ShutterOpenState, b.Shutter().State())
+}
+
+func TestBridgePublishState(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	client := connect(t, broker)
+	b := newTestBridge(t, client)
+
+	assert.NoError(t, b.SetMetadata(map[string]int{"floor": 1}))
+	assert.NoError(t, b.PublishState(time.Second))
+
+	broker.WaitForRetained(t, "shutter2mqtt/kitchen/state", shutter.ShutterOpenState)
+	broker.WaitForRetained(t, "shutter2mqtt/kitchen/position", "0")
+	broker.WaitForRetained(t, "shutter2mqtt/kitchen/metadata", `{"floor":1}`)
+}
+
+func TestHAAutoDiscovery(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	client := connect(t, broker)
+	b := newTestBridge(t, client)
+
+	discovery := broker.Subscribe("homeassistant/#")
+	assert.NoError(t, PublishHAAutoDiscovery(client, "homeassistant", NewHACoverFromMQTTBridge(b)))
+	m := discovery.Next(t)
+	assert.Equal(t, "homeassistant/cover/shutters2mqtt/kitchen/config", m.Topic)
+	assert.True(t, m.Retained)
+
+	var cover map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(m.Payload), &cover))
+	assert.Equal(t, AvailabilityTopic, cover["avty_t"])
+	assert.Equal(t, "shutter2mqtt/kitchen/state", cover["stat_t"])
+	assert.Equal(t, "shutter2mqtt/kitchen/set", cover["cmd_t"])
+	assert.Equal(t, "shutter2mqtt/kitchen/position/set", cover["set_pos_t"])
+	assert.Equal(t, float64(100), cover["pos_open"])
+
+	assert.NoError(t, PublishHAProblemAutoDiscovery(client, "homeassistant", b))
+	m = discovery.Next(t)
+	assert.Equal(t, "homeassistant/binary_sensor/shutters2mqtt/kitchen/config", m.Topic)
+
+	var sensor map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(m.Payload), &sensor))
+	assert.Equal(t, "problem", sensor["device_class"])
+	assert.Equal(t, "kitchen_problem", sensor["uniq_id"])
+	assert.Equal(t, "shutter2mqtt/kitchen/problem", sensor["stat_t"])
+	assert.Equal(t, "shutter2mqtt/kitchen/problem", sensor["json_attr_t"])
+
+	assert.NoError(t, DeleteHAAutoDiscovery(client, "homeassistant", "kitchen"))
+	broker.WaitForRetained(t, "homeassistant/cover/shutters2mqtt/kitchen/config", "")
+	broker.WaitForRetained(t, "homeassistant/binary_sensor/shutters2mqtt/kitchen/config", "")
+}
+
+type failingRelay struct{}
+
+func (failingRelay) EnableFor(ctx context.Context, duration time.Duration) error {
+	return errors.New("device not alive")
+}
+
+func (failingRelay) IsEnabled() bool {
+	return false
+}
+
+func TestBridgeProblem(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	client := connect(t, broker)
+	s := relay.NewRelaysShutter("kitchen", failingRelay{}, &relay.Dumb{}, 100, 0, time.Millisecond*200)
+	s.SetFaultRecovery(relay.FaultRecovery{})
+	b, err := NewBridge(client, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(time.Second)
+	broker.WaitForRetained(t, "shutter2mqtt/kitchen/problem", `{"problem":false}`)
+
+	messages := broker.Subscribe("shutter2mqtt/kitchen/problem")
+	assert.NoError(t, s.Open(context.Background()))
+
+	var problem map[string]interface{}
+	for problem["problem"] != true {
+		assert.NoError(t, json.Unmarshal([]byte(messages.Next(t).Payload), &problem))
+	}
+	assert.Equal(t, shutter.FaultRelay, problem["code"])
+	assert.Equal(t, "device not alive", problem["message"])
+	assert.NotEmpty(t, problem["since"])
+	assert.Equal(t, shutter.ShutterClosedState, s.State())
+}
+
+func TestAvailability(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	client := connect(t, broker)
+
+	assert.NoError(t, PublishAvailability(client, true, time.Second))
+	broker.WaitForRetained(t, AvailabilityTopic, AvailabilityOnline)
+
+	broker.DropClients() // will published on a dropped connection
+	broker.WaitForRetained(t, AvailabilityTopic, AvailabilityOffline)
+}
+
+func TestHeartbeat(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	client := connect(t, broker)
+	h := NewHeartbeat(client)
+	assert.NoError(t, h.Subscribe())
+
+	now := time.Now()
+	h.Beat(now)
+	assert.Eventually(t, func() bool {
+		return h.Stalled(now.Add(time.Hour), time.Second) == nil
+	}, time.Second, time.Millisecond, "heartbeat received")
+
+	// a blocking handler stalls handling of later messages
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	blocked := make(chan struct{})
+	if token := client.Subscribe("test/block", 0, func(paho.Client, paho.Message) {
+		close(blocked)
+		<-release
+	}); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	broker.Publish("test/block", "", false)
+	<-blocked
+
+	h.Beat(now)
+	time.Sleep(time.Millisecond * 50)
+	assert.EqualError(t, h.Stalled(now.Add(time.Second*2), time.Second), "MQTT heartbeat not received for 2s")
+	assert.NoError(t, h.Stalled(now.Add(time.Second*2), time.Second*3))
+}
+
+type testTruePosition struct {
+	notifier shutter.Notifier
+}
+
+func (r *testTruePosition) TruePosition() int { return 0 }
+
+func (r *testTruePosition) SubscribeTruePosition(h shutter.EventHandler) func() {
+	return r.notifier.Subscribe(h)
+}
+
+func TestBridgeTruePosition(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	client := connect(t, broker)
+	b := newTestBridge(t, client)
+
+	r := &testTruePosition{}
+	b.SetTruePosition(r)
+	debug := broker.Subscribe("shutter2mqtt/kitchen/debug/#")
+	r.notifier.Notify(shutter.Event{Shutter: "kitchen", Position: 42})
+
+	m := debug.Next(t)
+	assert.Equal(t, "shutter2mqtt/kitchen/debug/true_position", m.Topic)
+	assert.Equal(t, "42", m.Payload)
+	assert.False(t, m.Retained)
+}
+
+type testCalibrator struct {
+	started  chan struct{}
+	reached  chan struct{}
+	restored chan shutter.Calibration
+}
+
+func (c *testCalibrator) Calibrate(ctx context.Context) (shutter.Calibration, error) {
+	c.started <- struct{}{}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-c.reached:
+		case <-ctx.Done():
+			return shutter.Calibration{}, ctx.Err()
+		}
+	}
+	return shutter.Calibration{TimeToOpen: time.Millisecond * 12500, TimeToClose: time.Second * 11}, nil
+}
+
+func (c *testCalibrator) CalibrationReached() bool {
+	select {
+	case c.reached <- struct{}{}:
+		return true
+	case <-time.After(time.Millisecond * 100):
+		return false
+	}
+}
+
+func (c *testCalibrator) SetCalibration(calibration shutter.Calibration) error {
+	c.restored <- calibration
+	return nil
+}
+
+func TestBridgeCalibrate(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	broker.Publish("shutter2mqtt/kitchen/calibration", `{"time_to_open_seconds":20,"time_to_close_seconds":18.5}`, true)
+	client := connect(t, broker)
+	b := newTestBridge(t, client)
+
+	c := &testCalibrator{started: make(chan struct{}, 1), reached: make(chan struct{}), restored: make(chan shutter.Calibration, 1)}
+	assert.NoError(t, b.SetCalibrator(c))
+	assert.Equal(t, shutter.Calibration{TimeToOpen: time.Second * 20, TimeToClose: time.Millisecond * 18500}, <-c.restored)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, b.Subscribe(ctx))
+	messages := broker.Subscribe("shutter2mqtt/kitchen/#")
+
+	broker.Publish("shutter2mqtt/kitchen/calibrate", "reached", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/error", "kitchen: not calibrating")
+
+	broker.Publish("shutter2mqtt/kitchen/calibrate", "start", false)
+	<-c.started
+	broker.Publish("shutter2mqtt/kitchen/calibrate", "start", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/error", "kitchen: already calibrating")
+	broker.Publish("shutter2mqtt/kitchen/calibrate", "reached", false)
+	broker.Publish("shutter2mqtt/kitchen/calibrate", "reached", false)
+	broker.WaitForRetained(t, "shutter2mqtt/kitchen/calibration", `{"time_to_open_seconds":12.5,"time_to_close_seconds":11}`)
+
+	broker.Publish("shutter2mqtt/kitchen/calibrate", "start", false)
+	<-c.started
+	broker.Publish("shutter2mqtt/kitchen/calibrate", "cancel", false)
+	messages.WaitFor(t, "shutter2mqtt/kitchen/error", context.Canceled.Error())
+}
+
+func TestBridgePower(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	client := connect(t, broker)
+	b := newTestBridge(t, client)
+	b.SetPowerTopic("shellies/kitchen/relay/0/power")
+
+	readings := make(chan float64, 10)
+	unsubscribe := b.SubscribePower(func(watts float64) { readings <- watts })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, b.Subscribe(ctx))
+
+	broker.Publish("shellies/kitchen/relay/0/power", "abc", false)
+	broker.Publish("shellies/kitchen/relay/0/power", "112.5", false)
+	assert.Equal(t, 112.5, <-readings, "invalid reading skipped")
+
+	unsubscribe()
+	broker.Publish("shellies/kitchen/relay/0/power", "0", false)
+	time.Sleep(time.Millisecond * 50)
+	assert.Empty(t, readings)
+}
@@ -0,0 +1,32 @@
+package mqtt
+
+import (
+	"fmt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/internal/input"
+	"github.com/sirupsen/logrus"
+)
+
+type ButtonBridge struct {
+	mqtt mqtt.Client
+
+	EventTopic string
+}
+
+func NewButtonBridge(mqtt mqtt.Client, button *input.Button) *ButtonBridge {
+	bridge := &ButtonBridge{mqtt: mqtt}
+	bridge.EventTopic = fmt.Sprintf("shutter2mqtt/button/%s", button.Name)
+
+	button.OnEvent(bridge.onButtonEventHandler())
+
+	return bridge
+}
+
+func (b *ButtonBridge) onButtonEventHandler() input.EventHandler {
+	return func(button string, event string) {
+		if token := b.mqtt.Publish(b.EventTopic, 0, false, event); token.Wait() && token.Error() != nil {
+			logrus.WithField("button", button).Errorf("MQTT button event publish failed: %s", token.Error())
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/pkg/errors"
+)
+
+const (
+	CalibrateStartCmd   = "start"
+	CalibrateReachedCmd = "reached"
+	CalibrateCancelCmd  = "cancel"
+)
+
+// CalibrateTopic returns the topic taking calibration commands of a shutter.
+func CalibrateTopic(shutterName string) string {
+	return fmt.Sprintf("shutter2mqtt/%s/calibrate", shutterName)
+}
+
+// CalibrationTopic returns the retained topic with the last calibration of a shutter.
+func CalibrationTopic(shutterName string) string {
+	return fmt.Sprintf("shutter2mqtt/%s/calibration", shutterName)
+}
+
+type calibrationPayload struct {
+	TimeToOpen  float64 `json:"time_to_open_seconds"`
+	TimeToClose float64 `json:"time_to_close_seconds"`
+}
+
+// MarshalCalibration returns the payload of CalibrationTopic.
+func MarshalCalibration(c shutter.Calibration) ([]byte, error) {
+	return json.Marshal(calibrationPayload{TimeToOpen: c.TimeToOpen.Seconds(), TimeToClose: c.TimeToClose.Seconds()})
+}
+
+// UnmarshalCalibration parses a payload of CalibrationTopic.
+func UnmarshalCalibration(payload []byte) (shutter.Calibration, error) {
+	var p calibrationPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return shutter.Calibration{}, err
+	}
+
+	return shutter.Calibration{
+		TimeToOpen:  time.Duration(p.TimeToOpen * float64(time.Second)),
+		TimeToClose: time.Duration(p.TimeToClose * float64(time.Second)),
+	}, nil
+}
+
+// SetCalibrator takes calibration commands on CalibrateTopic for c and publishes the result retained on
+// CalibrationTopic. The retained calibration is restored, it overrides configured travel times.
+func (b *Bridge) SetCalibrator(c shutter.Calibrator) error {
+	b.calibrator = c
+
+	restoreHandler := func(client mqtt.Client, msg mqtt.Message) {
+		if len(msg.Payload()) == 0 { // cleared
+			return
+		}
+
+		calibration, err := UnmarshalCalibration(msg.Payload())
+		if err == nil {
+			err = c.SetCalibration(calibration)
+		}
+		if err != nil {
+			b.log.Errorf("MQTT calibration restore failed: %s", err)
+			return
+		}
+
+		b.log.Infof("MQTT calibration restored, time to open %s, time to close %s", calibration.TimeToOpen, calibration.TimeToClose)
+
+		// waited for off the handler like the position restore one, the handler blocks routing the ack
+		token := b.mqtt.Unsubscribe(b.CalibrationTopic)
+		go func() {
+			if token.Wait() && token.Error() != nil {
+				b.log.Errorf("MQTT calibration restore topic unsubscribe failed: %s", token.Error())
+			}
+		}()
+	}
+
+	if token := b.mqtt.Subscribe(b.CalibrationTopic, 0, restoreHandler); token.Wait() && token.Error() != nil {
+		return errors.Wrapf(token.Error(), "%s: MQTT calibration restore topic subscription failed", b.shutter.Name())
+	}
+
+	return nil
+}
+
+// Calibrator returns the calibrator set by SetCalibrator, nil when not set.
+func (b *Bridge) Calibrator() shutter.Calibrator {
+	return b.calibrator
+}
+
+func (b *Bridge) onCalibrateHandler(ctx context.Context) mqtt.MessageHandler {
+	ctx = shutter.WithSource(ctx, shutter.SourceMQTT)
+	return func(c mqtt.Client, msg mqtt.Message) {
+		if ctx.Err() != nil {
+			b.log.Warnf("MQTT message on %s ignored, shutting down", msg.Topic())
+			return
+		}
+		ctx := shutter.WithOrigin(shutter.WithRequestID(ctx, shutter.NewRequestID()), msg.Topic())
+		log := shutter.Logger(ctx, b.log).WithField("command", "calibrate")
+
+		var err error
+		switch cmd := string(msg.Payload()); cmd {
+		case CalibrateStartCmd:
+			err = b.startCalibration(ctx)
+		case CalibrateReachedCmd:
+			if !b.calibrator.CalibrationReached() {
+				err = fmt.Errorf("%s: not calibrating", b.shutter.Name())
+			}
+		case CalibrateCancelCmd:
+			b.calibrationL.Lock()
+			if b.cancelCalibration != nil {
+				b.cancelCalibration()
+			}
+			b.calibrationL.Unlock()
+		default:
+			err = unsupportedCommandErr
+		}
+
+		if err != nil {
+			log.Errorf("MQTT calibrate: %s", err)
+			b.PublishError(err)
+		}
+	}
+}
+
+func (b *Bridge) startCalibration(ctx context.Context) error {
+	b.calibrationL.Lock()
+	defer b.calibrationL.Unlock()
+
+	if b.cancelCalibration != nil {
+		return fmt.Errorf("%s: already calibrating", b.shutter.Name())
+	}
+	ctx, b.cancelCalibration = context.WithCancel(ctx)
+
+	go func() {
+		defer func() {
+			b.calibrationL.Lock()
+			b.cancelCalibration()
+			b.cancelCalibration = nil
+			b.calibrationL.Unlock()
+		}()
+
+		calibration, err := b.calibrator.Calibrate(ctx)
+		if err != nil {
+			b.PublishError(err)
+			return
+		}
+
+		payload, err := MarshalCalibration(calibration)
+		if err != nil {
+			b.log.Errorf("MQTT calibration publish failed: %s", err)
+			return
+		}
+		if token := b.mqtt.Publish(b.CalibrationTopic, 0, true, payload); token.Wait() && token.Error() != nil {
+			b.log.Errorf("MQTT calibration publish failed: %s", token.Error())
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package mqtt
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// HeartbeatTopic is where a client publishes heartbeats to itself, topics of clients differ by client ID.
+func HeartbeatTopic(clientID string) string {
+	return fmt.Sprintf("shutter2mqtt/heartbeat/%s", clientID)
+}
+
+// Heartbeat checks the client handles messages it publishes to itself.
+// A message handler blocking the client stalls heartbeats, while the connection still looks fine.
+type Heartbeat struct {
+	mqtt  paho.Client
+	topic string
+
+	l sync.Mutex
+	// pending is when the heartbeat not received yet was published, zero when there is none
+	pending time.Time
+}
+
+func NewHeartbeat(client paho.Client) *Heartbeat {
+	opts := client.OptionsReader()
+	return &Heartbeat{mqtt: client, topic: HeartbeatTopic(opts.ClientID())}
+}
+
+// Subscribe subscribes the heartbeat topic, it has to be done on every connect.
+func (h *Heartbeat) Subscribe() error {
+	h.Reset()
+	if token := h.mqtt.Subscribe(h.topic, 0, h.onHeartbeat); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("MQTT heartbeat topic subscription failed: %s", token.Error())
+	}
+	return nil
+}
+
+func (h *Heartbeat) onHeartbeat(_ paho.Client, msg paho.Message) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	if string(msg.Payload()) == strconv.FormatInt(h.pending.UnixNano(), 10) {
+		h.pending = time.Time{}
+	}
+}
+
+// Beat publishes a heartbeat, unless the previous one is pending or the client is not connected.
+func (h *Heartbeat) Beat(now time.Time) {
+	if !h.mqtt.IsConnectionOpen() {
+		return
+	}
+
+	h.l.Lock()
+	if !h.pending.IsZero() {
+		h.l.Unlock()
+		return
+	}
+	h.pending = now
+	h.l.Unlock()
+
+	// not waited for, a stalled client does not complete it
+	h.mqtt.Publish(h.topic, 0, false, strconv.FormatInt(now.UnixNano(), 10))
+}
+
+// Reset forgets the pending heartbeat, e.g. lost with the connection.
+func (h *Heartbeat) Reset() {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	h.pending = time.Time{}
+}
+
+// Stalled returns an error when the pending heartbeat was published longer than timeout before now.
+func (h *Heartbeat) Stalled(now time.Time, timeout time.Duration) error {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	if !h.pending.IsZero() && now.Sub(h.pending) > timeout {
+		return fmt.Errorf("MQTT heartbeat not received for %s", now.Sub(h.pending).Round(time.Second))
+	}
+	return nil
+}
@@ -37,21 +37,33 @@ type haCover struct {
 	PayloadClose     string `json:"pl_cls"`
 }
 
+// haBinarySensor is a problem sensor of a shutter, on while it has a fault. The fault is in its attributes.
+type haBinarySensor struct {
+	haEntity
+	StateTopic          string `json:"stat_t"`
+	ValueTemplate       string `json:"val_tpl"`
+	JSONAttributesTopic string `json:"json_attr_t"`
+}
+
+func haDeviceFromMQTTBridge(bridge *Bridge) haDevice {
+	return haDevice{
+		Identifiers:  []string{"shutter2mqtt"},
+		Manufacturer: "Somfy",
+		Model:        "Ilmo",
+		Name:         bridge.shutter.Name(),
+		SWVersion:    "shutters2mqtt",
+	}
+}
+
 func NewHACoverFromMQTTBridge(bridge *Bridge) haCover {
 	return haCover{
 		haEntity: haEntity{
-			//AvailabilityTopic: "",
-			UniqueID:    bridge.shutter.Name(),
-			Name:        bridge.shutter.Name(),
-			DeviceClass: "shutter",
-
-			Device: haDevice{
-				Identifiers:  []string{"shutter2mqtt"},
-				Manufacturer: "Somfy",
-				Model:        "Ilmo",
-				Name:         bridge.shutter.Name(),
-				SWVersion:    "shutters2mqtt",
-			},
+			AvailabilityTopic: AvailabilityTopic,
+			UniqueID:          bridge.shutter.Name(),
+			Name:              bridge.shutter.Name(),
+			DeviceClass:       "shutter",
+
+			Device: haDeviceFromMQTTBridge(bridge),
 		},
 		StateTopic:       bridge.StateTopic,
 		CommandTopic:     bridge.CommandTopic,
@@ -65,10 +77,44 @@ func NewHACoverFromMQTTBridge(bridge *Bridge) haCover {
 	}
 }
 
+// newHAProblemSensorFromMQTTBridge returns a binary sensor of the problem topic, on the device of the cover.
+func newHAProblemSensorFromMQTTBridge(bridge *Bridge) haBinarySensor {
+	return haBinarySensor{
+		haEntity: haEntity{
+			AvailabilityTopic: AvailabilityTopic,
+			UniqueID:          bridge.shutter.Name() + "_problem",
+			Name:              bridge.shutter.Name() + " problem",
+			DeviceClass:       "problem",
+
+			Device: haDeviceFromMQTTBridge(bridge),
+		},
+		StateTopic:          bridge.ProblemTopic,
+		ValueTemplate:       "{{ 'ON' if value_json.problem else 'OFF' }}",
+		JSONAttributesTopic: bridge.ProblemTopic,
+	}
+}
+
+func haCoverDiscoveryTopic(homeAssistantDiscoveryTopicPrefix, name string) string {
+	return fmt.Sprintf("%s/cover/shutters2mqtt/%s/config", homeAssistantDiscoveryTopicPrefix, name)
+}
+
+func haProblemDiscoveryTopic(homeAssistantDiscoveryTopicPrefix, name string) string {
+	return fmt.Sprintf("%s/binary_sensor/shutters2mqtt/%s/config", homeAssistantDiscoveryTopicPrefix, name)
+}
+
 func PublishHAAutoDiscovery(client paho.Client, homeAssistantDiscoveryTopicPrefix string, haCover haCover) error {
-	topic := fmt.Sprintf("%s/cover/shutters2mqtt/%s/config", homeAssistantDiscoveryTopicPrefix, haCover.Name)
+	return publishHADiscovery(client, haCoverDiscoveryTopic(homeAssistantDiscoveryTopicPrefix, haCover.Name), haCover)
+}
+
+// PublishHAProblemAutoDiscovery publishes a binary sensor with problem device class of a bridged shutter,
+// it is on while the shutter has a fault.
+func PublishHAProblemAutoDiscovery(client paho.Client, homeAssistantDiscoveryTopicPrefix string, bridge *Bridge) error {
+	topic := haProblemDiscoveryTopic(homeAssistantDiscoveryTopicPrefix, bridge.shutter.Name())
+	return publishHADiscovery(client, topic, newHAProblemSensorFromMQTTBridge(bridge))
+}
 
-	payload, err := json.Marshal(haCover)
+func publishHADiscovery(client paho.Client, topic string, entity interface{}) error {
+	payload, err := json.Marshal(entity)
 	if err != nil {
 		return err
 	}
@@ -79,3 +125,19 @@ func PublishHAAutoDiscovery(client paho.Client, homeAssistantDiscoveryTopicPrefi
 
 	return nil
 }
+
+// DeleteHAAutoDiscovery removes a cover and its problem sensor from Home Assistant by clearing their retained
+// discovery configs.
+func DeleteHAAutoDiscovery(client paho.Client, homeAssistantDiscoveryTopicPrefix string, name string) error {
+	topics := []string{
+		haCoverDiscoveryTopic(homeAssistantDiscoveryTopicPrefix, name),
+		haProblemDiscoveryTopic(homeAssistantDiscoveryTopicPrefix, name),
+	}
+	for _, topic := range topics {
+		if token := client.Publish(topic, 0, true, ""); token.Wait() && token.Error() != nil {
+			return token.Error()
+		}
+	}
+
+	return nil
+}
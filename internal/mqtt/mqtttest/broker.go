@@ -0,0 +1,412 @@
+// Package mqtttest provides an in-process MQTT broker for tests, like net/http/httptest does for HTTP.
+package mqtttest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// DefaultTimeout is how long Subscription waits for a message.
+const DefaultTimeout = time.Second * 5
+
+// Message is a message published to the broker.
+type Message struct {
+	ClientID string
+	Topic    string
+	Payload  string
+	Retained bool
+}
+
+// Broker is an MQTT 3.1.1 broker listening on a local port. It supports what shutter2mqtt uses:
+// QoS 0 and 1 publishing (delivered with QoS 0), retained messages, wills, wildcards and clean sessions only.
+type Broker struct {
+	ln net.Listener
+
+	l        sync.Mutex
+	clients  map[*client]struct{}
+	retained map[string]Message
+	subs     map[*Subscription]struct{}
+	closed   bool
+}
+
+// NewBroker starts a broker closed on the test cleanup.
+func NewBroker(t testing.TB) *Broker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Broker{
+		ln:       ln,
+		clients:  map[*client]struct{}{},
+		retained: map[string]Message{},
+		subs:     map[*Subscription]struct{}{},
+	}
+	go b.serve()
+	t.Cleanup(b.Close)
+	return b
+}
+
+// URL is the broker address for paho.ClientOptions.AddBroker.
+func (b *Broker) URL() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func (b *Broker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &client{broker: b, conn: conn, filters: map[string]struct{}{}}
+		b.l.Lock()
+		if b.closed {
+			b.l.Unlock()
+			conn.Close()
+			return
+		}
+		b.clients[c] = struct{}{}
+		b.l.Unlock()
+
+		go c.serve()
+	}
+}
+
+// Close disconnects all clients and stops the broker.
+func (b *Broker) Close() {
+	b.l.Lock()
+	b.closed = true
+	b.l.Unlock()
+
+	b.ln.Close()
+	b.DropClients()
+}
+
+// DropClients closes all client connections like a network failure would, so wills get published.
+func (b *Broker) DropClients() {
+	b.l.Lock()
+	clients := make([]*client, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.l.Unlock()
+
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
+
+// Connected returns how many clients are connected.
+func (b *Broker) Connected() int {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	var n int
+	for c := range b.clients {
+		c.l.Lock()
+		if c.id != "" {
+			n++
+		}
+		c.l.Unlock()
+	}
+	return n
+}
+
+// Publish publishes a message as a client would, an empty retained payload deletes the retained message.
+func (b *Broker) Publish(topic, payload string, retained bool) {
+	b.route(Message{ClientID: "mqtttest", Topic: topic, Payload: payload, Retained: retained})
+}
+
+// Retained returns the retained message of topic.
+func (b *Broker) Retained(topic string) (string, bool) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	m, found := b.retained[topic]
+	return m.Payload, found
+}
+
+// WaitForRetained waits until payload is retained on topic, an empty payload waits until none is.
+// It fails the test on DefaultTimeout.
+func (b *Broker) WaitForRetained(t testing.TB, topic, payload string) {
+	t.Helper()
+
+	deadline := time.Now().Add(DefaultTimeout)
+	for {
+		retained, _ := b.Retained(topic)
+		if retained == payload {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q not retained on %s within %s, got %q", payload, topic, DefaultTimeout, retained)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// Subscribe records messages published on topics matching filter from now on, filter may contain wildcards.
+func (b *Broker) Subscribe(filter string) *Subscription {
+	s := &Subscription{broker: b, filter: filter, c: make(chan Message, 1024)}
+
+	b.l.Lock()
+	b.subs[s] = struct{}{}
+	b.l.Unlock()
+	return s
+}
+
+func (b *Broker) route(m Message) {
+	b.l.Lock()
+	if m.Retained {
+		if m.Payload == "" {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+
+	var clients []*client
+	for c := range b.clients {
+		if c.subscribed(m.Topic) {
+			clients = append(clients, c)
+		}
+	}
+	for s := range b.subs {
+		if Match(s.filter, m.Topic) {
+			select {
+			case s.c <- m:
+			default: // a test not reading its subscription must not block the broker
+			}
+		}
+	}
+	b.l.Unlock()
+
+	for _, c := range clients {
+		c.deliver(m.Topic, m.Payload, false)
+	}
+}
+
+func (b *Broker) disconnected(c *client, will *Message) {
+	b.l.Lock()
+	delete(b.clients, c)
+	b.l.Unlock()
+
+	if will != nil {
+		b.route(*will)
+	}
+}
+
+type client struct {
+	broker *Broker
+	conn   net.Conn
+
+	wl sync.Mutex // serializes writes
+
+	l       sync.Mutex
+	id      string
+	filters map[string]struct{}
+}
+
+func (c *client) serve() {
+	var will *Message
+	defer func() {
+		c.conn.Close()
+		c.broker.disconnected(c, will)
+	}()
+
+	for {
+		p, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+
+		switch p := p.(type) {
+		case *packets.ConnectPacket:
+			c.l.Lock()
+			c.id = p.ClientIdentifier
+			c.l.Unlock()
+			if p.WillFlag {
+				will = &Message{ClientID: p.ClientIdentifier, Topic: p.WillTopic, Payload: string(p.WillMessage), Retained: p.WillRetain}
+			}
+
+			ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			ack.ReturnCode = packets.Accepted
+			c.write(ack)
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			c.l.Lock()
+			for _, filter := range p.Topics {
+				c.filters[filter] = struct{}{}
+				ack.ReturnCodes = append(ack.ReturnCodes, 0)
+			}
+			c.l.Unlock()
+			c.write(ack)
+
+			c.broker.l.Lock()
+			var retained []Message
+			for _, m := range c.broker.retained {
+				for _, filter := range p.Topics {
+					if Match(filter, m.Topic) {
+						retained = append(retained, m)
+						break
+					}
+				}
+			}
+			c.broker.l.Unlock()
+			for _, m := range retained {
+				c.deliver(m.Topic, m.Payload, true)
+			}
+		case *packets.UnsubscribePacket:
+			c.l.Lock()
+			for _, filter := range p.Topics {
+				delete(c.filters, filter)
+			}
+			c.l.Unlock()
+
+			ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			ack.MessageID = p.MessageID
+			c.write(ack)
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				c.write(ack)
+			}
+			c.broker.route(Message{ClientID: c.id, Topic: p.TopicName, Payload: string(p.Payload), Retained: p.Retain})
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			will = nil
+			return
+		}
+	}
+}
+
+func (c *client) subscribed(topic string) bool {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	for filter := range c.filters {
+		if Match(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *client) deliver(topic, payload string, retained bool) {
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = topic
+	p.Payload = []byte(payload)
+	p.Retain = retained
+	c.write(p)
+}
+
+func (c *client) write(p packets.ControlPacket) {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+
+	if err := p.Write(c.conn); err != nil {
+		c.conn.Close()
+	}
+}
+
+// Match reports whether topic matches filter, which may contain + and # wildcards.
+func Match(filter, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+// Subscription receives messages published after Broker.Subscribe.
+type Subscription struct {
+	broker *Broker
+	filter string
+	c      chan Message
+}
+
+// Next returns the next message, it fails the test when none is published within DefaultTimeout.
+func (s *Subscription) Next(t testing.TB) Message {
+	t.Helper()
+
+	select {
+	case m := <-s.c:
+		return m
+	case <-time.After(DefaultTimeout):
+		t.Fatalf("no message published on %s within %s", s.filter, DefaultTimeout)
+		return Message{}
+	}
+}
+
+// WaitFor skips messages until payload is published on topic, it fails the test on DefaultTimeout.
+func (s *Subscription) WaitFor(t testing.TB, topic, payload string) Message {
+	t.Helper()
+
+	timeout := time.After(DefaultTimeout)
+	var seen []string
+	for {
+		select {
+		case m := <-s.c:
+			if m.Topic == topic && m.Payload == payload {
+				return m
+			}
+			seen = append(seen, m.Topic+" "+m.Payload)
+		case <-timeout:
+			t.Fatalf("%q not published on %s within %s, got: %v", payload, topic, DefaultTimeout, seen)
+			return Message{}
+		}
+	}
+}
+
+// NextOn skips messages until one is published on topic, it fails the test on DefaultTimeout.
+func (s *Subscription) NextOn(t testing.TB, topic string) Message {
+	t.Helper()
+
+	timeout := time.After(DefaultTimeout)
+	for {
+		select {
+		case m := <-s.c:
+			if m.Topic == topic {
+				return m
+			}
+		case <-timeout:
+			t.Fatalf("no message published on %s within %s", topic, DefaultTimeout)
+			return Message{}
+		}
+	}
+}
+
+// Close stops recording messages.
+func (s *Subscription) Close() {
+	s.broker.l.Lock()
+	defer s.broker.l.Unlock()
+
+	delete(s.broker.subs, s)
+}
+
+// Drain discards messages received so far.
+func (s *Subscription) Drain() {
+	for {
+		select {
+		case <-s.c:
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package mqtttest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"shutter2mqtt/kitchen/set", "shutter2mqtt/kitchen/set", true},
+		{"shutter2mqtt/kitchen/set", "shutter2mqtt/kitchen/position/set", false},
+		{"shutter2mqtt/+/set", "shutter2mqtt/kitchen/set", true},
+		{"shutter2mqtt/+/set", "shutter2mqtt/kitchen/position/set", false},
+		{"shutter2mqtt/#", "shutter2mqtt/kitchen/position/set", true},
+		{"shutter2mqtt/#", "shutter2mqtt", true},
+		{"#", "homeassistant/cover/shutters2mqtt/kitchen/config", true},
+		{"shutter2mqtt/kitchen", "shutter2mqtt/kitchen/state", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, Match(tt.filter, tt.topic), "%s %s", tt.filter, tt.topic)
+	}
+}
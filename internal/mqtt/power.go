@@ -0,0 +1,31 @@
+package mqtt
+
+import (
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+)
+
+// SetPowerTopic makes the bridge a shutter.PowerMeter of readings in watts published on topic,
+// e.g. shellies/<id>/relay/0/power of a Shelly device.
+func (b *Bridge) SetPowerTopic(topic string) {
+	b.PowerTopic = topic
+}
+
+func (b *Bridge) SubscribePower(h shutter.PowerHandler) (unsubscribe func()) {
+	return b.power.SubscribePower(h)
+}
+
+func (b *Bridge) onPowerHandler() mqtt.MessageHandler {
+	return func(c mqtt.Client, msg mqtt.Message) {
+		watts, err := strconv.ParseFloat(strings.TrimSpace(string(msg.Payload())), 64)
+		if err != nil {
+			b.log.Warnf("MQTT power: %s", err)
+			return
+		}
+
+		b.power.Notify(watts)
+	}
+}
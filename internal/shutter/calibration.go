@@ -0,0 +1,24 @@
+package shutter
+
+import (
+	"context"
+	"time"
+)
+
+// Calibration is the measured full travel time of a shutter per direction.
+type Calibration struct {
+	TimeToOpen  time.Duration
+	TimeToClose time.Duration
+}
+
+// Calibrator is implemented by shutters which estimate the position from travel times and can measure them.
+type Calibrator interface {
+	// Calibrate opens the shutter fully, then closes and opens it again, each until CalibrationReached
+	// is called. The measured travel times replace the configured ones and are returned.
+	Calibrate(ctx context.Context) (Calibration, error)
+	// CalibrationReached signals the shutter reached the end-stop the calibration moves it to.
+	// It reports false when no calibration waits for it.
+	CalibrationReached() bool
+	// SetCalibration replaces the configured travel times, e.g. with ones measured before.
+	SetCalibration(c Calibration) error
+}
@@ -0,0 +1,189 @@
+package shutter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	WindowContactBlockMode = "block"
+	WindowContactLimitMode = "limit"
+)
+
+type WindowContactError struct {
+	Shutter string
+	Reason  string
+}
+
+func (e *WindowContactError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Shutter, e.Reason)
+}
+
+// WindowContactGuard wraps a Shutter and blocks or limits closing while a window contact reports open.
+// In limit mode closing still happens, but stops at the ventilation position.
+type WindowContactGuard struct {
+	Shutter
+
+	mode                string
+	ventilationPosition int
+
+	l          sync.RWMutex
+	windowOpen bool
+	// target of the last move passed to the shutter, the closing one is limited only if it goes below ventilation
+	target int
+
+	movementHandlers []MovementHandler
+}
+
+func NewWindowContactGuard(s Shutter, mode string, ventilationPosition int) (*WindowContactGuard, error) {
+	switch mode {
+	case WindowContactBlockMode:
+	case WindowContactLimitMode:
+		if ventilationPosition > s.FullOpenPosition() || ventilationPosition < s.FullClosePosition() {
+			return nil, fmt.Errorf(
+				"%s: ventilation position %d is out of range open/close position for (%d/%d)",
+				s.Name(),
+				ventilationPosition,
+				s.FullOpenPosition(),
+				s.FullClosePosition(),
+			)
+		}
+	default:
+		return nil, fmt.Errorf("%s: %s is not supported window contact mode", s.Name(), mode)
+	}
+
+	return &WindowContactGuard{
+		Shutter:             s,
+		mode:                mode,
+		ventilationPosition: ventilationPosition,
+		target:              s.FullClosePosition(),
+	}, nil
+}
+
+// OnMovement registers a handler for commands blocked by the guard.
+// Commands passed to the wrapped shutter are reported by the shutter itself.
+func (g *WindowContactGuard) OnMovement(h MovementHandler) {
+	g.movementHandlers = append(g.movementHandlers, h)
+}
+
+func (g *WindowContactGuard) IsWindowOpen() bool {
+	g.l.RLock()
+	defer g.l.RUnlock()
+
+	return g.windowOpen
+}
+
+// SetWindowOpen updates the contact state. When the window opens while the shutter is closing,
+// the move gets stopped (block mode) or redirected to the ventilation position (limit mode).
+// In limit mode a move not going below the ventilation position is left alone.
+func (g *WindowContactGuard) SetWindowOpen(ctx context.Context, open bool) error {
+	g.l.Lock()
+	g.windowOpen = open
+	target := g.target
+	g.l.Unlock()
+
+	if !open || g.Shutter.State() != ShutterClosingState {
+		return nil
+	}
+
+	if g.mode == WindowContactLimitMode {
+		if target >= g.ventilationPosition {
+			return nil
+		}
+		if g.Shutter.Position() > g.ventilationPosition {
+			if err := g.setPosition(ctx, g.ventilationPosition); err != nil {
+				return err
+			}
+			return g.limitedErr()
+		}
+	}
+
+	if err := g.Shutter.Stop(ctx); err != nil {
+		return err
+	}
+	return g.blockedErr()
+}
+
+// Open is checked like SetPosition to the full open position. Its target is tracked, so the window opening
+// meanwhile does not limit it.
+func (g *WindowContactGuard) Open(ctx context.Context) error {
+	position := g.Shutter.FullOpenPosition()
+	if !g.IsWindowOpen() || position >= g.Shutter.Position() {
+		g.setTarget(position)
+		return g.Shutter.Open(ctx)
+	}
+
+	return g.closeTo(ctx, "open", position)
+}
+
+func (g *WindowContactGuard) Close(ctx context.Context) error {
+	if !g.IsWindowOpen() {
+		g.setTarget(g.Shutter.FullClosePosition())
+		return g.Shutter.Close(ctx)
+	}
+
+	return g.closeTo(ctx, "close", g.Shutter.FullClosePosition())
+}
+
+func (g *WindowContactGuard) SetPosition(ctx context.Context, position int) error {
+	if !g.IsWindowOpen() || position >= g.Shutter.Position() {
+		return g.setPosition(ctx, position)
+	}
+
+	return g.closeTo(ctx, "set_position", position)
+}
+
+func (g *WindowContactGuard) ResetPosition(position int) error {
+	s, ok := g.Shutter.(StatelessShutter)
+	if !ok {
+		return fmt.Errorf("%s: shutter is not stateless", g.Shutter.Name())
+	}
+
+	return s.ResetPosition(position)
+}
+
+func (g *WindowContactGuard) closeTo(ctx context.Context, command string, position int) error {
+	if g.mode == WindowContactBlockMode || g.Shutter.Position() <= g.ventilationPosition {
+		err := g.blockedErr()
+
+		m := NewMovement(ctx, g.Shutter.Name(), command, g.Shutter.Position(), position)
+		m.Outcome = OutcomeBlocked
+		m.Error = err.Error()
+		for _, h := range g.movementHandlers {
+			h(m)
+		}
+		return err
+	}
+
+	if position >= g.ventilationPosition {
+		return g.setPosition(ctx, position)
+	}
+
+	if err := g.setPosition(ctx, g.ventilationPosition); err != nil {
+		return err
+	}
+	return g.limitedErr()
+}
+
+func (g *WindowContactGuard) setPosition(ctx context.Context, position int) error {
+	g.setTarget(position)
+	return g.Shutter.SetPosition(ctx, position)
+}
+
+func (g *WindowContactGuard) setTarget(position int) {
+	g.l.Lock()
+	g.target = position
+	g.l.Unlock()
+}
+
+func (g *WindowContactGuard) blockedErr() error {
+	return &WindowContactError{Shutter: g.Shutter.Name(), Reason: "closing blocked, window is open"}
+}
+
+func (g *WindowContactGuard) limitedErr() error {
+	return &WindowContactError{
+		Shutter: g.Shutter.Name(),
+		Reason:  fmt.Sprintf("closing limited to ventilation position %d, window is open", g.ventilationPosition),
+	}
+}
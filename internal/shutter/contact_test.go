@@ -0,0 +1,125 @@
+package shutter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeShutter struct {
+	state    string
+	position int
+	stopped  bool
+}
+
+func (s *fakeShutter) Name() string                  { return "fake" }
+func (s *fakeShutter) FullOpenPosition() int         { return 100 }
+func (s *fakeShutter) FullClosePosition() int        { return 0 }
+func (s *fakeShutter) Position() int                 { return s.position }
+func (s *fakeShutter) State() string                 { return s.state }
+func (s *fakeShutter) Subscribe(EventHandler) func() { return func() {} }
+func (s *fakeShutter) Open(ctx context.Context) error {
+	return s.SetPosition(ctx, 100)
+}
+func (s *fakeShutter) Close(ctx context.Context) error {
+	return s.SetPosition(ctx, 0)
+}
+func (s *fakeShutter) Stop(context.Context) error {
+	s.stopped = true
+	return nil
+}
+func (s *fakeShutter) SetPosition(_ context.Context, position int) error {
+	s.position = position
+	return nil
+}
+
+func TestWindowContactGuard(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("block mode rejects closing while window is open", func(t *testing.T) {
+		s := &fakeShutter{position: 80}
+		g, err := NewWindowContactGuard(s, WindowContactBlockMode, 0)
+		assert.NoError(t, err)
+
+		assert.NoError(t, g.SetWindowOpen(ctx, true))
+		assert.IsType(t, &WindowContactError{}, g.Close(ctx))
+		assert.IsType(t, &WindowContactError{}, g.SetPosition(ctx, 50))
+		assert.Equal(t, 80, s.position)
+
+		assert.NoError(t, g.SetPosition(ctx, 90))
+		assert.Equal(t, 90, s.position)
+
+		assert.NoError(t, g.SetWindowOpen(ctx, false))
+		assert.NoError(t, g.Close(ctx))
+		assert.Equal(t, 0, s.position)
+	})
+
+	t.Run("limit mode stops closing at ventilation position", func(t *testing.T) {
+		s := &fakeShutter{position: 80}
+		g, err := NewWindowContactGuard(s, WindowContactLimitMode, 20)
+		assert.NoError(t, err)
+
+		assert.NoError(t, g.SetWindowOpen(ctx, true))
+		assert.IsType(t, &WindowContactError{}, g.Close(ctx))
+		assert.Equal(t, 20, s.position)
+
+		assert.NoError(t, g.SetPosition(ctx, 50))
+		assert.Equal(t, 50, s.position)
+	})
+
+	t.Run("window opening during closing stops the shutter", func(t *testing.T) {
+		s := &fakeShutter{position: 60, state: ShutterClosingState}
+		g, err := NewWindowContactGuard(s, WindowContactBlockMode, 0)
+		assert.NoError(t, err)
+
+		assert.IsType(t, &WindowContactError{}, g.SetWindowOpen(ctx, true))
+		assert.True(t, s.stopped)
+	})
+
+	t.Run("limit mode leaves closing above ventilation position alone", func(t *testing.T) {
+		s := &fakeShutter{position: 90}
+		g, err := NewWindowContactGuard(s, WindowContactLimitMode, 30)
+		assert.NoError(t, err)
+
+		assert.NoError(t, g.SetPosition(ctx, 80))
+		s.position, s.state = 85, ShutterClosingState
+
+		assert.NoError(t, g.SetWindowOpen(ctx, true))
+		assert.Equal(t, 85, s.position)
+		assert.False(t, s.stopped)
+	})
+
+	t.Run("limit mode redirects closing below ventilation position", func(t *testing.T) {
+		s := &fakeShutter{position: 90}
+		g, err := NewWindowContactGuard(s, WindowContactLimitMode, 30)
+		assert.NoError(t, err)
+
+		assert.NoError(t, g.Close(ctx))
+		s.position, s.state = 85, ShutterClosingState
+
+		assert.IsType(t, &WindowContactError{}, g.SetWindowOpen(ctx, true))
+		assert.Equal(t, 30, s.position)
+	})
+
+	t.Run("open passes while window is open", func(t *testing.T) {
+		s := &fakeShutter{position: 40}
+		g, err := NewWindowContactGuard(s, WindowContactLimitMode, 30)
+		assert.NoError(t, err)
+
+		assert.NoError(t, g.Close(ctx))
+		assert.NoError(t, g.SetWindowOpen(ctx, true))
+		assert.NoError(t, g.Open(ctx))
+		assert.Equal(t, 100, s.position)
+
+		s.position, s.state = 85, ShutterClosingState
+		assert.NoError(t, g.SetWindowOpen(ctx, true), "target of the open move kept")
+		assert.Equal(t, 85, s.position)
+		assert.False(t, s.stopped)
+	})
+
+	t.Run("ventilation position out of range is rejected", func(t *testing.T) {
+		_, err := NewWindowContactGuard(&fakeShutter{}, WindowContactLimitMode, 120)
+		assert.Error(t, err)
+	})
+}
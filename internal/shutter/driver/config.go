@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a driver config section, decoded into the driver config type once the driver is known.
+// Kind is the value of the kind key, when the section has one.
+//
+// Positions, comments and styles of the YAML source are dropped, so configs read from different files compare equal
+// with reflect.DeepEqual when their values do.
+type Config struct {
+	Kind string
+
+	node *yaml.Node
+}
+
+// NewConfig returns a section of kind with values encoded from v, e.g. a map or a driver config struct.
+func NewConfig(kind string, v interface{}) (Config, error) {
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return Config{}, err
+	}
+	if kind != "" && n.Kind == yaml.MappingNode {
+		n.Content = append([]*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "kind"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: kind},
+		}, n.Content...)
+	}
+
+	cfg := Config{Kind: kind}
+	return cfg, cfg.UnmarshalYAML(&n)
+}
+
+func (c *Config) UnmarshalYAML(n *yaml.Node) error {
+	var kind struct {
+		Kind string `yaml:"kind"`
+	}
+	if n.Kind == yaml.MappingNode {
+		if err := n.Decode(&kind); err != nil {
+			return err
+		}
+	}
+
+	c.Kind = kind.Kind
+	c.node = stripPositions(n)
+	return nil
+}
+
+func (c Config) MarshalYAML() (interface{}, error) {
+	if c.node == nil {
+		return map[string]interface{}{}, nil
+	}
+	return c.node, nil
+}
+
+// Decode decodes the section into v, a pointer to the driver config type. An empty section leaves v untouched.
+func (c Config) Decode(v interface{}) error {
+	if c.node == nil {
+		return nil
+	}
+	return c.node.Decode(v)
+}
+
+func stripPositions(n *yaml.Node) *yaml.Node {
+	stripped := &yaml.Node{
+		Kind:  n.Kind,
+		Tag:   n.Tag,
+		Value: n.Value,
+	}
+	if n.Alias != nil {
+		stripped.Alias = stripPositions(n.Alias)
+	}
+	for _, c := range n.Content {
+		stripped.Content = append(stripped.Content, stripPositions(c))
+	}
+	return stripped
+}
@@ -0,0 +1,197 @@
+// Package driver is a registry of shutter, relay and pin drivers.
+// Drivers register a factory together with their config type, usually in init,
+// so the application builds them by kind without knowing about them:
+//
+//	import _ "example.com/shutters/driver/foo" // registers foo relay kind
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+)
+
+// Relay is implemented by relay.Relay.
+type Relay interface {
+	EnableFor(ctx context.Context, duration time.Duration) error
+	IsEnabled() bool
+}
+
+// Pin is an output pin, implemented by relay.SetPin.
+type Pin interface {
+	High() error
+	Low() error
+}
+
+// Builder builds nested drivers, so a shutter driver can use relays of any kind and a relay driver pins of any kind.
+// It is implemented by the application, which may wrap what drivers return, e.g. to collect metrics.
+type Builder interface {
+	Relay(shutterName, relayName string, cfg Config) (Relay, error)
+	Pin(cfg Config) (Pin, error)
+}
+
+// Clocked is implemented by a Builder whose drivers run on a given clock, e.g. a clock.Fake in tests.
+type Clocked interface {
+	Clock() clock.Clock
+}
+
+// BuilderClock returns the clock of b, the system clock unless b is Clocked.
+func BuilderClock(b Builder) clock.Clock {
+	if c, ok := b.(Clocked); ok {
+		return clock.Or(c.Clock())
+	}
+	return clock.Real
+}
+
+// MaxOnTimed is implemented by a Builder whose relays get force-disabled after a max on-time, e.g. by a watchdog.
+type MaxOnTimed interface {
+	MaxOnTime() time.Duration
+}
+
+// BuilderMaxOnTime returns the max on-time of relays built by b, zero when there is none.
+func BuilderMaxOnTime(b Builder) time.Duration {
+	if m, ok := b.(MaxOnTimed); ok {
+		return m.MaxOnTime()
+	}
+	return 0
+}
+
+// ShutterDriver creates shutters of a kind.
+type ShutterDriver struct {
+	// Config returns a pointer to a new config value, the driver config section is decoded into.
+	// The value is validated before New when it implements Validator.
+	Config func() interface{}
+	// New creates a shutter, config is the value returned by Config after decoding.
+	New func(b Builder, name string, config interface{}) (shutter.Shutter, error)
+}
+
+// RelayDriver creates relays of a kind.
+type RelayDriver struct {
+	Config func() interface{}
+	New    func(b Builder, shutterName, relayName string, config interface{}) (Relay, error)
+}
+
+// PinDriver creates pins of a kind.
+type PinDriver struct {
+	Config func() interface{}
+	New    func(b Builder, config interface{}) (Pin, error)
+}
+
+var (
+	l        sync.RWMutex
+	shutters = map[string]ShutterDriver{}
+	relays   = map[string]RelayDriver{}
+	pins     = map[string]PinDriver{}
+)
+
+// RegisterShutter makes a shutter driver available by kind. It panics when kind is registered twice.
+func RegisterShutter(kind string, d ShutterDriver) {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, found := shutters[kind]; found {
+		panic(fmt.Sprintf("driver: shutter kind %q registered twice", kind))
+	}
+	shutters[kind] = d
+}
+
+// RegisterRelay makes a relay driver available by kind. It panics when kind is registered twice.
+func RegisterRelay(kind string, d RelayDriver) {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, found := relays[kind]; found {
+		panic(fmt.Sprintf("driver: relay kind %q registered twice", kind))
+	}
+	relays[kind] = d
+}
+
+// RegisterPin makes a pin driver available by kind. It panics when kind is registered twice.
+func RegisterPin(kind string, d PinDriver) {
+	l.Lock()
+	defer l.Unlock()
+
+	if _, found := pins[kind]; found {
+		panic(fmt.Sprintf("driver: pin kind %q registered twice", kind))
+	}
+	pins[kind] = d
+}
+
+func LookupShutter(kind string) (ShutterDriver, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	d, found := shutters[kind]
+	return d, found
+}
+
+func LookupRelay(kind string) (RelayDriver, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	d, found := relays[kind]
+	return d, found
+}
+
+func LookupPin(kind string) (PinDriver, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	d, found := pins[kind]
+	return d, found
+}
+
+// NewShutter creates a shutter of kind, cfg is its driver config section.
+func NewShutter(b Builder, kind, name string, cfg Config) (shutter.Shutter, error) {
+	d, found := LookupShutter(kind)
+	if !found {
+		return nil, fmt.Errorf("%s: %q is not supported shutter kind", name, kind)
+	}
+
+	config := d.Config()
+	if err := cfg.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if err := Validate(config); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return d.New(b, name, config)
+}
+
+// NewRelay creates a relay of cfg.Kind.
+func NewRelay(b Builder, shutterName, relayName string, cfg Config) (Relay, error) {
+	d, found := LookupRelay(cfg.Kind)
+	if !found {
+		return nil, fmt.Errorf("%s: %s relay: %q is not supported relay kind", shutterName, relayName, cfg.Kind)
+	}
+
+	config := d.Config()
+	if err := cfg.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %s relay: %s", shutterName, relayName, err)
+	}
+	if err := Validate(config); err != nil {
+		return nil, fmt.Errorf("%s: %s relay: %s", shutterName, relayName, err)
+	}
+	return d.New(b, shutterName, relayName, config)
+}
+
+// NewPin creates a pin of cfg.Kind.
+func NewPin(b Builder, cfg Config) (Pin, error) {
+	d, found := LookupPin(cfg.Kind)
+	if !found {
+		return nil, fmt.Errorf("%q is not supported pin kind", cfg.Kind)
+	}
+
+	config := d.Config()
+	if err := cfg.Decode(config); err != nil {
+		return nil, err
+	}
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	return d.New(b, config)
+}
@@ -0,0 +1,143 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+type testRelayConfig struct {
+	Pin    Config `yaml:"pin"`
+	Invert bool   `yaml:"invert"`
+}
+
+type testRelay struct {
+	pin    Pin
+	invert bool
+}
+
+func (r *testRelay) EnableFor(ctx context.Context, duration time.Duration) error { return nil }
+func (r *testRelay) IsEnabled() bool                                             { return false }
+
+type testPinConfig struct {
+	Number int `yaml:"number"`
+}
+
+func (c *testPinConfig) Validate() error {
+	var errs FieldErrors
+	if c.Number > 15 {
+		errs.Add([]string{"number"}, "%d is out of range 0-15", c.Number)
+	}
+	return errs.Err()
+}
+
+type testPin struct{ number int }
+
+func (p *testPin) High() error { return nil }
+func (p *testPin) Low() error  { return nil }
+
+// testBuilder counts built relays, like an application wrapping them would.
+type testBuilder struct{ relays int }
+
+func (b *testBuilder) Relay(shutterName, relayName string, cfg Config) (Relay, error) {
+	b.relays++
+	return NewRelay(b, shutterName, relayName, cfg)
+}
+
+func (b *testBuilder) Pin(cfg Config) (Pin, error) {
+	return NewPin(b, cfg)
+}
+
+func init() {
+	RegisterRelay("test", RelayDriver{
+		Config: func() interface{} { return &testRelayConfig{} },
+		New: func(b Builder, shutterName, relayName string, config interface{}) (Relay, error) {
+			cfg := config.(*testRelayConfig)
+			pin, err := b.Pin(cfg.Pin)
+			if err != nil {
+				return nil, err
+			}
+			return &testRelay{pin: pin, invert: cfg.Invert}, nil
+		},
+	})
+	RegisterPin("test", PinDriver{
+		Config: func() interface{} { return &testPinConfig{} },
+		New: func(b Builder, config interface{}) (Pin, error) {
+			cfg := config.(*testPinConfig)
+			if cfg.Number < 0 {
+				return nil, errors.New("negative pin number")
+			}
+			return &testPin{number: cfg.Number}, nil
+		},
+	})
+}
+
+func decodeConfig(t *testing.T, s string) Config {
+	t.Helper()
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(s), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestNewRelay(t *testing.T) {
+	b := &testBuilder{}
+
+	r, err := b.Relay("kitchen", "up", decodeConfig(t, "{kind: test, invert: true, pin: {kind: test, number: 3}}"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &testRelay{pin: &testPin{number: 3}, invert: true}, r)
+	}
+	assert.Equal(t, 1, b.relays)
+
+	_, err = b.Relay("kitchen", "up", decodeConfig(t, "{kind: test, pin: {kind: test, number: -1}}"))
+	assert.EqualError(t, err, "negative pin number")
+
+	_, err = b.Relay("kitchen", "up", decodeConfig(t, "{kind: test, pin: {kind: test, number: 16}}"))
+	assert.EqualError(t, err, "number: 16 is out of range 0-15")
+
+	_, err = b.Relay("kitchen", "up", decodeConfig(t, "{kind: test, pin: {kind: gpio}}"))
+	assert.EqualError(t, err, `"gpio" is not supported pin kind`)
+
+	_, err = b.Relay("kitchen", "down", decodeConfig(t, "{kind: solid_state}"))
+	assert.EqualError(t, err, `kitchen: down relay: "solid_state" is not supported relay kind`)
+
+	_, err = b.Relay("kitchen", "down", decodeConfig(t, "{kind: test, invert: maybe}"))
+	assert.Error(t, err)
+}
+
+func TestNewShutterUnknownKind(t *testing.T) {
+	_, err := NewShutter(&testBuilder{}, "somfy", "kitchen", Config{})
+	assert.EqualError(t, err, `kitchen: "somfy" is not supported shutter kind`)
+}
+
+func TestRegisterTwice(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterPin("test", PinDriver{})
+	})
+}
+
+func TestConfigEqual(t *testing.T) {
+	a := decodeConfig(t, "kind: test # comment\npin: {kind: test, number: 3}\n")
+	b := decodeConfig(t, "\n\nkind: \"test\"\npin:\n  kind: test\n  number: 3\n")
+	assert.Equal(t, "test", a.Kind)
+	assert.True(t, reflect.DeepEqual(a, b), "positions, comments and styles are ignored")
+
+	c, err := NewConfig("test", testRelayConfig{Pin: decodeConfig(t, "{kind: test, number: 3}")})
+	assert.NoError(t, err)
+	assert.Equal(t, "test", c.Kind)
+
+	var decoded testRelayConfig
+	assert.NoError(t, c.Decode(&decoded))
+	assert.Equal(t, "test", decoded.Pin.Kind)
+
+	out, err := yaml.Marshal(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "kind: test\npin:\n    kind: test\n    number: 3\ninvert: false\n", string(out))
+}
@@ -0,0 +1,231 @@
+package relay
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// calibrationTravelLimit bounds a calibration travel, in multiples of the configured full travel time.
+const calibrationTravelLimit = 2
+
+// Calibrate measures the full travel times. As the position is not known, it first opens the shutter for
+// twice its configured time to open, unless CalibrationReached is called earlier. Then it closes and opens it,
+// each until CalibrationReached is called, for at most twice the configured travel time.
+// Travels are not longer than the max on-time, see SetMaxOnTime.
+// A command given meanwhile cancels the calibration.
+func (s *RelaysShutter) Calibrate(ctx context.Context) (shutter.Calibration, error) {
+	log := s.commandLog(ctx, "calibrate")
+	log.Info("calibrate")
+	movement := s.newMovement(ctx, "calibrate", s.Position(), s.fullOpenPosition)
+	ctx, mv := s.retainContext(ctx)
+	defer close(mv.done)
+	if mv.prev != nil {
+		s.waitMoveEnd(mv.prev)
+		mv.prev = nil
+	}
+
+	reached := make(chan struct{}, 1)
+	s.calibrationL.Lock()
+	s.calibrationReached = reached
+	s.calibrationL.Unlock()
+	defer func() {
+		s.calibrationL.Lock()
+		s.calibrationReached = nil
+		s.calibrationL.Unlock()
+	}()
+
+	start := s.clock.Now()
+	c, err := s.calibrate(ctx, log, reached)
+	movement.RunTime = s.clock.Since(start)
+	movement.Outcome = shutter.OutcomeCompleted
+	if err != nil {
+		movement.Outcome = moveOutcome(err, mv)
+		movement.Error = err.Error()
+		log.Errorf("calibration failed: %s", err)
+
+		s.l.Lock()
+		s.resetPosition(s.currentPosition)
+		s.l.Unlock()
+		s.notifyUpdate()
+	}
+	movement.EndPosition = s.Position()
+	s.reportMovement(movement)
+
+	return c, err
+}
+
+func (s *RelaysShutter) calibrate(ctx context.Context, log *logrus.Entry, reached chan struct{}) (shutter.Calibration, error) {
+	if s.dutyCycle != nil {
+		if err := s.dutyCycle.Check(); err != nil {
+			return shutter.Calibration{}, errors.Wrap(err, s.name)
+		}
+	}
+
+	log.Info("calibration: open fully")
+	if _, _, err := s.calibrationTravel(ctx, reached, true); err != nil {
+		return shutter.Calibration{}, err
+	}
+	s.settle(s.fullOpenPosition)
+
+	log.Info("calibration: close, waiting until the bottom is reached")
+	timeToClose, ok, err := s.calibrationTravel(ctx, reached, false)
+	if err != nil {
+		return shutter.Calibration{}, err
+	}
+	if !ok {
+		return shutter.Calibration{}, errors.Errorf("%s: calibration: bottom not reached within %s", s.name, s.calibrationLimit(false))
+	}
+	s.settle(s.fullClosePosition)
+
+	log.Info("calibration: open, waiting until the top is reached")
+	timeToOpen, ok, err := s.calibrationTravel(ctx, reached, true)
+	if err != nil {
+		return shutter.Calibration{}, err
+	}
+	if !ok {
+		return shutter.Calibration{}, errors.Errorf("%s: calibration: top not reached within %s", s.name, s.calibrationLimit(true))
+	}
+	s.clearFault()
+	s.settle(s.fullOpenPosition)
+
+	c := shutter.Calibration{TimeToOpen: timeToOpen, TimeToClose: timeToClose}
+	if err := s.SetCalibration(c); err != nil {
+		return shutter.Calibration{}, err
+	}
+	log.Infof("calibrated time to open %s, time to close %s", timeToOpen, timeToClose)
+	return c, nil
+}
+
+// calibrationTravel moves the shutter up or down until reached receives, ok is false when it does not within
+// calibrationLimit. It returns how long the motor travelled, without the start delay.
+func (s *RelaysShutter) calibrationTravel(ctx context.Context, reached chan struct{}, up bool) (travel time.Duration, ok bool, err error) {
+	relay, target, state := s.rDown, s.fullClosePosition, shutter.ShutterClosingState
+	if up {
+		relay, target, state = s.rUp, s.fullOpenPosition, shutter.ShutterOpeningState
+	}
+	limit := s.calibrationLimit(up)
+
+	s.l.Lock()
+	from := s.currentPosition
+	s.currentTarget, s.currentState = target, state
+	s.l.Unlock()
+
+	select { // signalled before the travel started
+	case <-reached:
+	default:
+	}
+
+	travelCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	done := make(chan error, 1)
+	go func() { done <- relay.EnableFor(travelCtx, limit) }()
+
+	for !relay.IsEnabled() {
+		select {
+		case err := <-done:
+			s.relayFailed(err)
+			return 0, false, err
+		case <-time.After(time.Millisecond): // waits for another goroutine, not for the motor
+		}
+	}
+	since := s.clock.Now()
+	s.notifyUpdate()
+
+	select {
+	case <-reached:
+		elapsed := s.clock.Since(since)
+		stop()
+		if err := <-done; err != nil && err != context.Canceled {
+			s.relayFailed(err)
+			return 0, false, err
+		}
+		if ctx.Err() != nil {
+			s.travelled(from, target, elapsed)
+			return 0, false, ctx.Err()
+		}
+
+		if elapsed <= s.startDelay {
+			return 0, false, errors.Errorf("%s: calibration: reached after %s, before the motor started", s.name, elapsed)
+		}
+		return elapsed - s.startDelay, true, nil
+	case err := <-done:
+		if err != nil {
+			s.travelled(from, target, s.clock.Since(since))
+			s.relayFailed(err)
+		}
+		return 0, false, err
+	}
+}
+
+// travelled sets the position estimated for a calibration travel ended early.
+func (s *RelaysShutter) travelled(from, target int, elapsed time.Duration) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.currentPosition = s.estimatePosition(from, target, elapsed)
+}
+
+// calibrationLimit returns how long a calibration travel up or down runs at most,
+// it is not longer than the max on-time, so the watchdog does not abort it.
+func (s *RelaysShutter) calibrationLimit(up bool) time.Duration {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	limit := calibrationTravelLimit*s.fullTravelTime(up) + s.startDelay
+	if s.maxOnTime > 0 && limit > s.maxOnTime {
+		return s.maxOnTime
+	}
+	return limit
+}
+
+// settle sets the position the motor stopped at and notifies about it.
+func (s *RelaysShutter) settle(position int) {
+	s.l.Lock()
+	s.resetPosition(position)
+	s.l.Unlock()
+	s.notifyUpdate()
+}
+
+func (s *RelaysShutter) CalibrationReached() bool {
+	s.calibrationL.Lock()
+	defer s.calibrationL.Unlock()
+
+	if s.calibrationReached == nil {
+		return false
+	}
+	select {
+	case s.calibrationReached <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (s *RelaysShutter) SetCalibration(c shutter.Calibration) error {
+	if c.TimeToOpen <= 0 || c.TimeToClose <= 0 {
+		return errors.Errorf("%s: calibration travel times have to be positive, got %s to open and %s to close", s.name, c.TimeToOpen, c.TimeToClose)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.timeToOpen = c.TimeToOpen
+	s.timeToClose = c.TimeToClose
+	return nil
+}
+
+// moveOutcome returns the outcome of a move ended by err.
+func moveOutcome(err error, mv *move) string {
+	switch {
+	case err == context.Canceled && atomic.LoadInt32(&mv.superseded) == 1:
+		return shutter.OutcomeSuperseded
+	case err == context.Canceled || err == context.DeadlineExceeded:
+		return shutter.OutcomeCancelled
+	default:
+		return shutter.OutcomeError
+	}
+}
@@ -0,0 +1,106 @@
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelaysShutterCalibrate(t *testing.T) {
+	c := clock.NewFake(epoch)
+	s := NewRelaysShutter("test", &Dumb{Clock: c}, &Dumb{Clock: c}, 100, 0, time.Second*10)
+	s.SetClock(c)
+	s.CompensateLatency(time.Millisecond*300, 0)
+	assert.NoError(t, s.ResetPosition(40))
+	events := make(chan shutter.Event, 100)
+	defer s.Subscribe(func(e shutter.Event) { events <- e })()
+	movements := make(chan shutter.Movement, 10)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	type result struct {
+		c   shutter.Calibration
+		err error
+	}
+	calibrate := func() <-chan result {
+		done := make(chan result, 1)
+		go func() {
+			c, err := s.Calibrate(context.Background())
+			done <- result{c, err}
+		}()
+		return done
+	}
+	// travel waits until the relay of a calibration travel is energised and runs it for d
+	travel := func(state string, d time.Duration) {
+		nextEvent(t, events, func(e shutter.Event) bool { return e.State == state })
+		c.BlockUntil(1) // relay run
+		c.Advance(d)
+	}
+
+	assert.False(t, s.CalibrationReached(), "not calibrating")
+
+	t.Run("measures travel times between the end-stops", func(t *testing.T) {
+		done := calibrate()
+		travel(shutter.ShutterOpeningState, time.Millisecond*20300) // not reached, runs twice the time to open
+		travel(shutter.ShutterClosingState, time.Second*9)
+		assert.True(t, s.CalibrationReached())
+		travel(shutter.ShutterOpeningState, time.Second*12)
+		assert.True(t, s.CalibrationReached())
+
+		r := <-done
+		assert.NoError(t, r.err)
+		assert.Equal(t, shutter.Calibration{TimeToOpen: time.Millisecond * 11700, TimeToClose: time.Millisecond * 8700}, r.c)
+		assert.Equal(t, time.Millisecond*11700, s.fullTravelTime(true))
+		assert.Equal(t, time.Millisecond*8700, s.fullTravelTime(false))
+		assert.Equal(t, 100, s.Position())
+		assert.Equal(t, shutter.ShutterOpenState, s.State())
+
+		m := <-movements
+		assert.Equal(t, "calibrate", m.Command)
+		assert.Equal(t, shutter.OutcomeCompleted, m.Outcome)
+		assert.Equal(t, 40, m.StartPosition)
+		assert.Equal(t, 100, m.EndPosition)
+	})
+
+	t.Run("fails when the bottom is not reached", func(t *testing.T) {
+		done := calibrate()
+		travel(shutter.ShutterOpeningState, time.Millisecond*23700)
+		travel(shutter.ShutterClosingState, time.Millisecond*17700)
+
+		r := <-done
+		assert.EqualError(t, r.err, "test: calibration: bottom not reached within 17.7s")
+		assert.Equal(t, time.Millisecond*8700, s.fullTravelTime(false), "calibration kept")
+		assert.Equal(t, shutter.OutcomeError, (<-movements).Outcome)
+	})
+
+	t.Run("stop cancels it", func(t *testing.T) {
+		done := calibrate()
+		travel(shutter.ShutterOpeningState, time.Millisecond*23700)
+		travel(shutter.ShutterClosingState, time.Millisecond*4650) // half way down
+		assert.NoError(t, s.Stop(context.Background()))
+
+		r := <-done
+		assert.Equal(t, context.Canceled, r.err)
+		assert.Equal(t, 50, s.Position())
+		assert.Equal(t, shutter.ShutterOpenState, s.State())
+		assert.Equal(t, shutter.OutcomeCancelled, (<-movements).Outcome)
+		assert.False(t, s.CalibrationReached())
+	})
+
+	t.Run("travels up to the max on-time", func(t *testing.T) {
+		s.SetMaxOnTime(time.Second * 15)
+		defer s.SetMaxOnTime(0)
+
+		done := calibrate()
+		travel(shutter.ShutterOpeningState, time.Second*15)
+		travel(shutter.ShutterClosingState, time.Second*15)
+
+		r := <-done
+		assert.EqualError(t, r.err, "test: calibration: bottom not reached within 15s")
+	})
+
+	assert.EqualError(t, s.SetCalibration(shutter.Calibration{TimeToClose: time.Second}), "test: calibration travel times have to be positive, got 0s to open and 1s to close")
+}
@@ -0,0 +1,216 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"github.com/racerxdl/go-mcp23017"
+)
+
+// ShutterConfig is the config of relays shutter kind, a shutter moved by an up and a down relay.
+type ShutterConfig struct {
+	Up   driver.Config `yaml:"up"`
+	Down driver.Config `yaml:"down"`
+
+	FullOpenPosition  int           `yaml:"full_open_position"`
+	FullClosePosition int           `yaml:"full_close_position"`
+	TimeToClose       time.Duration `yaml:"time_to_close"`
+	// TimeToOpen is the full travel time when opening, time to close when not set.
+	TimeToOpen time.Duration `yaml:"time_to_open"`
+
+	// StartDelay is how long the motor takes to start moving after a relay is energised,
+	// StopOverrun how long it coasts after the relay is released.
+	StartDelay  time.Duration `yaml:"start_delay"`
+	StopOverrun time.Duration `yaml:"stop_overrun"`
+	// MinMove is the smallest move by positions, shorter ones are ignored.
+	MinMove int `yaml:"min_move"`
+	// PositionPublishInterval or PositionPublishStep is how often the position is published
+	// during a move, by time or by positions. Every position by default.
+	PositionPublishInterval time.Duration `yaml:"position_publish_interval"`
+	PositionPublishStep     int           `yaml:"position_publish_step"`
+
+	DutyCycle *DutyCycleConfig `yaml:"duty_cycle"`
+	// FaultRecovery replaces DefaultFaultRecovery.
+	FaultRecovery *FaultRecoveryConfig `yaml:"fault_recovery"`
+}
+
+type DutyCycleConfig struct {
+	MaxRun       time.Duration `yaml:"max_run"`
+	Window       time.Duration `yaml:"window"`
+	MaxWindowRun time.Duration `yaml:"max_window_run"`
+	CoolDown     time.Duration `yaml:"cool_down"`
+	Defer        bool          `yaml:"defer"`
+}
+
+// FaultRecoveryConfig is how often a relay fault recovery is attempted, zero backoff disables it.
+type FaultRecoveryConfig struct {
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// WiredConfig is the config of wired relay kind.
+type WiredConfig struct {
+	Pin          driver.Config `yaml:"pin"`
+	NormalClosed bool          `yaml:"normal_closed"`
+}
+
+// DumbConfig is the config of dumb relay kind, it has no settings.
+type DumbConfig struct{}
+
+// Mcp23017PinConfig is the config of mcp23017 pin kind, Mcp23017 is the device ID.
+type Mcp23017PinConfig struct {
+	Mcp23017 int   `yaml:"mcp23017"`
+	Pin      uint8 `yaml:"pin"`
+}
+
+// Mcp23017Devices is implemented by a driver.Builder which builds mcp23017 pins.
+// Pins of the same device ID share the device.
+type Mcp23017Devices interface {
+	Mcp23017Device(id int) (*mcp23017.Device, error)
+}
+
+func (c *ShutterConfig) Validate() error {
+	var errs driver.FieldErrors
+	if c.FullOpenPosition <= c.FullClosePosition {
+		errs.Add([]string{"full_open_position"}, "must be greater than full_close_position (%d)", c.FullClosePosition)
+	}
+	if c.TimeToClose <= 0 {
+		errs.Add([]string{"time_to_close"}, "must be positive")
+	}
+	if c.TimeToOpen < 0 {
+		errs.Add([]string{"time_to_open"}, "must not be negative")
+	}
+	if c.StartDelay < 0 {
+		errs.Add([]string{"start_delay"}, "must not be negative")
+	}
+	if c.StopOverrun < 0 {
+		errs.Add([]string{"stop_overrun"}, "must not be negative")
+	}
+	if c.MinMove < 0 || (c.FullOpenPosition > c.FullClosePosition && c.MinMove > c.FullOpenPosition-c.FullClosePosition) {
+		errs.Add([]string{"min_move"}, "%d is out of range 0-%d", c.MinMove, c.FullOpenPosition-c.FullClosePosition)
+	}
+	if c.PositionPublishInterval < 0 {
+		errs.Add([]string{"position_publish_interval"}, "must not be negative")
+	}
+	if c.PositionPublishStep < 0 {
+		errs.Add([]string{"position_publish_step"}, "must not be negative")
+	}
+	if c.PositionPublishInterval > 0 && c.PositionPublishStep > 0 {
+		errs.Add([]string{"position_publish_step"}, "conflicts with position_publish_interval, set one of them")
+	}
+	if fr := c.FaultRecovery; fr != nil {
+		if fr.Backoff < 0 {
+			errs.Add([]string{"fault_recovery", "backoff"}, "must not be negative")
+		}
+		if fr.MaxBackoff < 0 {
+			errs.Add([]string{"fault_recovery", "max_backoff"}, "must not be negative")
+		}
+		if fr.MaxBackoff > 0 && fr.MaxBackoff < fr.Backoff {
+			errs.Add([]string{"fault_recovery", "max_backoff"}, "must not be less than backoff %s", fr.Backoff)
+		}
+	}
+	return errs.Err()
+}
+
+func (c *ShutterConfig) Positions() (fullOpen, fullClose int) {
+	return c.FullOpenPosition, c.FullClosePosition
+}
+
+// RelayRuns returns the longest relay runs, a full travel including the start delay.
+func (c *ShutterConfig) RelayRuns() map[string]time.Duration {
+	runs := map[string]time.Duration{"time_to_close": c.TimeToClose + c.StartDelay}
+	if c.TimeToOpen > 0 {
+		runs["time_to_open"] = c.TimeToOpen + c.StartDelay
+	}
+	return runs
+}
+
+func (c *Mcp23017PinConfig) Validate() error {
+	var errs driver.FieldErrors
+	if c.Pin > 15 {
+		errs.Add([]string{"pin"}, "%d is out of mcp23017 pin range 0-15", c.Pin)
+	}
+	return errs.Err()
+}
+
+func init() {
+	driver.RegisterShutter("relays", driver.ShutterDriver{
+		Config: func() interface{} { return &ShutterConfig{} },
+		New:    newShutterFromConfig,
+	})
+	driver.RegisterRelay("wired", driver.RelayDriver{
+		Config: func() interface{} { return &WiredConfig{} },
+		New:    newWiredFromConfig,
+	})
+	driver.RegisterRelay("dumb", driver.RelayDriver{
+		Config: func() interface{} { return &DumbConfig{} },
+		New: func(b driver.Builder, shutterName, relayName string, config interface{}) (driver.Relay, error) {
+			return &Dumb{Name: shutterName + "/" + relayName, Clock: driver.BuilderClock(b)}, nil
+		},
+	})
+	driver.RegisterPin("mcp23017", driver.PinDriver{
+		Config: func() interface{} { return &Mcp23017PinConfig{} },
+		New:    newMcp23017PinFromConfig,
+	})
+}
+
+func newShutterFromConfig(b driver.Builder, name string, config interface{}) (shutter.Shutter, error) {
+	cfg := config.(*ShutterConfig)
+
+	up, err := b.Relay(name, "up", cfg.Up)
+	if err != nil {
+		return nil, err
+	}
+	down, err := b.Relay(name, "down", cfg.Down)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewRelaysShutter(name, up, down, cfg.FullOpenPosition, cfg.FullClosePosition, cfg.TimeToClose)
+	s.SetClock(driver.BuilderClock(b))
+	s.SetTimeToOpen(cfg.TimeToOpen)
+	s.CompensateLatency(cfg.StartDelay, cfg.StopOverrun)
+	s.SetMinMove(cfg.MinMove)
+	s.SetMaxOnTime(driver.BuilderMaxOnTime(b))
+	s.SetPublishRate(cfg.PositionPublishInterval, cfg.PositionPublishStep)
+	if dc := cfg.DutyCycle; dc != nil {
+		s.LimitDutyCycle(DutyCycleLimits{
+			MaxRun:       dc.MaxRun,
+			Window:       dc.Window,
+			MaxWindowRun: dc.MaxWindowRun,
+			CoolDown:     dc.CoolDown,
+			Defer:        dc.Defer,
+		})
+	}
+	if fr := cfg.FaultRecovery; fr != nil {
+		s.SetFaultRecovery(FaultRecovery{Backoff: fr.Backoff, MaxBackoff: fr.MaxBackoff})
+	}
+	return s, nil
+}
+
+func newWiredFromConfig(b driver.Builder, shutterName, relayName string, config interface{}) (driver.Relay, error) {
+	cfg := config.(*WiredConfig)
+
+	pin, err := b.Pin(cfg.Pin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s relay: %s", shutterName, relayName, err)
+	}
+	return &Wired{Pin: pin, NormalClosed: cfg.NormalClosed, Clock: driver.BuilderClock(b)}, nil
+}
+
+func newMcp23017PinFromConfig(b driver.Builder, config interface{}) (driver.Pin, error) {
+	cfg := config.(*Mcp23017PinConfig)
+
+	devices, ok := b.(Mcp23017Devices)
+	if !ok {
+		return nil, errors.New("mcp23017 devices not available")
+	}
+	device, err := devices.Mcp23017Device(cfg.Mcp23017)
+	if err != nil {
+		return nil, err
+	}
+	return NewMcp23017Pin(device, cfg.Pin)
+}
@@ -0,0 +1,81 @@
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+type testBuilder struct{}
+
+func (b testBuilder) Relay(shutterName, relayName string, cfg driver.Config) (driver.Relay, error) {
+	return driver.NewRelay(b, shutterName, relayName, cfg)
+}
+
+func (b testBuilder) Pin(cfg driver.Config) (driver.Pin, error) {
+	return driver.NewPin(b, cfg)
+}
+
+type clockedBuilder struct {
+	testBuilder
+	clock clock.Clock
+}
+
+func (b clockedBuilder) Relay(shutterName, relayName string, cfg driver.Config) (driver.Relay, error) {
+	return driver.NewRelay(b, shutterName, relayName, cfg)
+}
+
+func (b clockedBuilder) Clock() clock.Clock {
+	return b.clock
+}
+
+func TestRelaysShutterDriver(t *testing.T) {
+	var cfg driver.Config
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+up: {kind: dumb}
+down: {kind: dumb}
+full_open_position: 100
+full_close_position: 0
+time_to_close: 30s
+time_to_open: 35s
+start_delay: 300ms
+stop_overrun: 100ms
+min_move: 2
+duty_cycle: {max_run: 10s}
+`), &cfg))
+
+	s, err := driver.NewShutter(testBuilder{}, "relays", "kitchen", cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rs := s.(*RelaysShutter)
+	assert.Equal(t, "kitchen", rs.Name())
+	assert.Equal(t, 100, rs.FullOpenPosition())
+	assert.Equal(t, time.Second*30, rs.timeToClose)
+	assert.Equal(t, time.Second*35, rs.fullTravelTime(true))
+	assert.Equal(t, time.Millisecond*300, rs.startDelay)
+	assert.Equal(t, time.Millisecond*100, rs.stopOverrun)
+	assert.Equal(t, 2, rs.minMove)
+	assert.NotNil(t, rs.dutyCycle)
+	assert.Equal(t, clock.Real, rs.clock)
+
+	c := clock.NewFake(epoch)
+	s, err = driver.NewShutter(clockedBuilder{clock: c}, "relays", "kitchen", cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rs = s.(*RelaysShutter)
+	assert.Equal(t, c, rs.clock)
+	assert.Equal(t, c, rs.dutyCycle.clock)
+	assert.Equal(t, c, rs.rUp.(*dutyCycleRelay).r.(*Dumb).Clock, "relays built on the builder clock")
+
+	var wired driver.Config
+	assert.NoError(t, yaml.Unmarshal([]byte("{kind: wired, pin: {kind: gpio}}"), &wired))
+	_, err = driver.NewRelay(testBuilder{}, "kitchen", "up", wired)
+	assert.EqualError(t, err, `kitchen: up relay: "gpio" is not supported pin kind`)
+}
@@ -0,0 +1,266 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/sirupsen/logrus"
+)
+
+type DutyCycleLimits struct {
+	// MaxRun limits a single continuous run, longer runs get cut and followed by CoolDown.
+	MaxRun time.Duration
+	// MaxWindowRun limits cumulative run time within a sliding Window.
+	Window       time.Duration
+	MaxWindowRun time.Duration
+	// CoolDown is a mandatory pause after a run was cut by MaxRun or MaxWindowRun.
+	CoolDown time.Duration
+	// Defer makes runs wait for the cool-down to end instead of being rejected.
+	Defer bool
+}
+
+type CoolingDownError struct {
+	Until time.Time
+}
+
+func (e *CoolingDownError) Error() string {
+	return fmt.Sprintf("motor cooling down until %s", e.Until.Format(time.RFC3339))
+}
+
+type dutyCycleRun struct {
+	end      time.Time
+	duration time.Duration
+}
+
+// DutyCycleLimiter protects a motor from overheating. Both relays of a motor have to be wrapped
+// by the same limiter, as they share the motor run time budget.
+type DutyCycleLimiter struct {
+	name   string
+	limits DutyCycleLimits
+	clock  clock.Clock
+
+	l             sync.Mutex
+	runs          []dutyCycleRun
+	coolDownUntil time.Time
+	onChange      []func()
+}
+
+func NewDutyCycleLimiter(name string, limits DutyCycleLimits) *DutyCycleLimiter {
+	return &DutyCycleLimiter{name: name, limits: limits, clock: clock.Real}
+}
+
+func (l *DutyCycleLimiter) Wrap(r Relay) Relay {
+	return &dutyCycleRelay{r: r, l: l}
+}
+
+// OnChange registers a handler called when the limiter enters or leaves the cool-down.
+func (l *DutyCycleLimiter) OnChange(h func()) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	l.onChange = append(l.onChange, h)
+}
+
+// CoolingDown returns whether runs are currently not allowed and until when.
+func (l *DutyCycleLimiter) CoolingDown() (bool, time.Time) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	until := l.coolingDownUntil(l.clock.Now())
+	return !until.IsZero(), until
+}
+
+// Check returns a CoolingDownError when a run would be rejected now.
+func (l *DutyCycleLimiter) Check() error {
+	if l.limits.Defer {
+		return nil
+	}
+
+	if cooling, until := l.CoolingDown(); cooling {
+		return &CoolingDownError{Until: until}
+	}
+	return nil
+}
+
+func (l *DutyCycleLimiter) enableFor(ctx context.Context, r Relay, duration time.Duration) error {
+	runFor, err := l.acquire(ctx, duration)
+	if err != nil {
+		return err
+	}
+
+	// a wrapped relay may wait for a relay pool first, the run starts once it is enabled
+	enabledAt := make(chan time.Time, 1)
+	watching, stopWatching := context.WithCancel(ctx)
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		if waitEnabled(watching, r) {
+			enabledAt <- l.clock.Now()
+		}
+	}()
+
+	err = r.EnableFor(ctx, runFor)
+	stopWatching()
+	<-watched
+
+	ran := runFor
+	if err != nil { // the run ended early, if it started at all
+		ran = 0
+		select {
+		case t := <-enabledAt:
+			if ran = l.clock.Since(t); ran > runFor {
+				ran = runFor
+			}
+		default:
+		}
+	}
+
+	if cut := l.release(ran, runFor < duration && err == nil); cut != nil {
+		return cut
+	}
+	return err
+}
+
+// acquire waits (Defer) or fails until a run is allowed and returns for how long the relay can run.
+func (l *DutyCycleLimiter) acquire(ctx context.Context, duration time.Duration) (time.Duration, error) {
+	for {
+		l.l.Lock()
+		now := l.clock.Now()
+		until := l.coolingDownUntil(now)
+		if until.IsZero() {
+			runFor := l.available(now)
+			l.l.Unlock()
+
+			if runFor > duration {
+				runFor = duration
+			}
+			return runFor, nil
+		}
+		l.l.Unlock()
+
+		if !l.limits.Defer {
+			return 0, &CoolingDownError{Until: until}
+		}
+
+		logrus.WithField("shutter", l.name).Infof("motor cooling down, run deferred until %s", until.Format(time.RFC3339))
+		wait := l.clock.NewTimer(until.Sub(l.clock.Now()))
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return 0, ctx.Err()
+		case <-wait.C():
+		}
+	}
+}
+
+func (l *DutyCycleLimiter) release(ran time.Duration, cut bool) error {
+	l.l.Lock()
+
+	now := l.clock.Now()
+	l.runs = append(l.runs, dutyCycleRun{end: now, duration: ran})
+	if cut && l.limits.CoolDown > 0 {
+		l.coolDownUntil = now.Add(l.limits.CoolDown)
+	}
+
+	until := l.coolingDownUntil(now)
+	handlers := l.onChange
+	l.l.Unlock()
+
+	if until.IsZero() {
+		if cut {
+			return &CoolingDownError{Until: now}
+		}
+		return nil
+	}
+
+	logrus.WithField("shutter", l.name).Warnf("motor duty cycle limit reached, cooling down until %s", until.Format(time.RFC3339))
+	for _, h := range handlers {
+		h()
+	}
+	l.clock.AfterFunc(until.Sub(now), func() {
+		for _, h := range handlers {
+			h()
+		}
+	})
+
+	return &CoolingDownError{Until: until}
+}
+
+// coolingDownUntil returns when runs are allowed again, zero if they are allowed now.
+func (l *DutyCycleLimiter) coolingDownUntil(now time.Time) (until time.Time) {
+	if now.Before(l.coolDownUntil) {
+		until = l.coolDownUntil
+	}
+
+	if l.limits.Window <= 0 || l.limits.MaxWindowRun <= 0 {
+		return until
+	}
+
+	l.prune(now)
+	used := l.used()
+	if used < l.limits.MaxWindowRun {
+		return until
+	}
+
+	// budget frees up as the oldest runs leave the window
+	for _, run := range l.runs {
+		used -= run.duration
+		if used < l.limits.MaxWindowRun {
+			if freed := run.end.Add(l.limits.Window); freed.After(until) {
+				until = freed
+			}
+			break
+		}
+	}
+	return until
+}
+
+func (l *DutyCycleLimiter) available(now time.Time) time.Duration {
+	available := time.Duration(1<<63 - 1)
+	if l.limits.MaxRun > 0 {
+		available = l.limits.MaxRun
+	}
+
+	if l.limits.Window > 0 && l.limits.MaxWindowRun > 0 {
+		l.prune(now)
+		if left := l.limits.MaxWindowRun - l.used(); left < available {
+			available = left
+		}
+	}
+	return available
+}
+
+func (l *DutyCycleLimiter) prune(now time.Time) {
+	i := 0
+	for i < len(l.runs) && now.Sub(l.runs[i].end) >= l.limits.Window {
+		i++
+	}
+	l.runs = l.runs[i:]
+}
+
+func (l *DutyCycleLimiter) used() (used time.Duration) {
+	for _, run := range l.runs {
+		used += run.duration
+	}
+	return used
+}
+
+type dutyCycleRelay struct {
+	r Relay
+	l *DutyCycleLimiter
+}
+
+func (r *dutyCycleRelay) EnableFor(ctx context.Context, duration time.Duration) error {
+	return r.l.enableFor(ctx, r.r, duration)
+}
+
+func (r *dutyCycleRelay) IsEnabled() bool {
+	return r.r.IsEnabled()
+}
+
+func (r *dutyCycleRelay) Unwrap() Relay {
+	return r.r
+}
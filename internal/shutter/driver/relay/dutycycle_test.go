@@ -0,0 +1,87 @@
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDutyCycleLimiter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.Run("single run is cut and followed by a cool down", func(t *testing.T) {
+		l := NewDutyCycleLimiter("test", DutyCycleLimits{MaxRun: time.Millisecond * 10, CoolDown: time.Millisecond * 30})
+		up, down := l.Wrap(&Dumb{}), l.Wrap(&Dumb{})
+
+		start := time.Now()
+		err := up.EnableFor(ctx, time.Millisecond*50)
+		assert.Less(t, time.Since(start), time.Millisecond*40)
+
+		var coolingDown *CoolingDownError
+		assert.ErrorAs(t, err, &coolingDown)
+		assert.ErrorAs(t, down.EnableFor(ctx, time.Millisecond), &coolingDown, "relays share the motor budget")
+		assert.ErrorAs(t, l.Check(), &coolingDown)
+
+		time.Sleep(time.Until(coolingDown.Until))
+		assert.NoError(t, down.EnableFor(ctx, time.Millisecond))
+	})
+
+	t.Run("cumulative run time within a window", func(t *testing.T) {
+		l := NewDutyCycleLimiter("test", DutyCycleLimits{Window: time.Millisecond * 50, MaxWindowRun: time.Millisecond * 15})
+		r := l.Wrap(&Dumb{})
+
+		assert.NoError(t, r.EnableFor(ctx, time.Millisecond*10))
+		assert.Error(t, r.EnableFor(ctx, time.Millisecond*10), "run cut by the remaining budget")
+
+		cooling, until := l.CoolingDown()
+		assert.True(t, cooling)
+		assert.WithinDuration(t, time.Now().Add(time.Millisecond*50), until, time.Millisecond*15)
+	})
+
+	t.Run("deferred run waits for the cool down", func(t *testing.T) {
+		l := NewDutyCycleLimiter("test", DutyCycleLimits{MaxRun: time.Millisecond * 5, CoolDown: time.Millisecond * 20, Defer: true})
+		r := l.Wrap(&Dumb{})
+
+		assert.Error(t, r.EnableFor(ctx, time.Millisecond*10))
+		assert.NoError(t, l.Check())
+
+		start := time.Now()
+		assert.NoError(t, r.EnableFor(ctx, time.Millisecond))
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*15)
+	})
+
+	t.Run("waiting for a relay pool is not run time", func(t *testing.T) {
+		l := NewDutyCycleLimiter("test", DutyCycleLimits{Window: time.Second, MaxWindowRun: time.Millisecond * 100})
+		pool := make(chan struct{}, 1)
+		pool <- struct{}{}
+		r := l.Wrap(NewPoolProxy(&Dumb{}, pool))
+
+		go func() {
+			time.Sleep(time.Millisecond * 40)
+			<-pool
+		}()
+		runCtx, stop := context.WithTimeout(ctx, time.Millisecond*50)
+		defer stop()
+		assert.ErrorIs(t, r.EnableFor(runCtx, time.Millisecond*80), context.DeadlineExceeded)
+
+		l.l.Lock()
+		defer l.l.Unlock()
+		assert.Less(t, l.used(), time.Millisecond*30)
+	})
+
+	t.Run("change handlers on cool down start and end", func(t *testing.T) {
+		l := NewDutyCycleLimiter("test", DutyCycleLimits{MaxRun: time.Millisecond, CoolDown: time.Millisecond * 10})
+		changes := make(chan struct{}, 2)
+		l.OnChange(func() { changes <- struct{}{} })
+
+		assert.Error(t, l.Wrap(&Dumb{}).EnableFor(ctx, time.Millisecond*5))
+		<-changes
+		<-changes
+
+		cooling, _ := l.CoolingDown()
+		assert.False(t, cooling)
+	})
+}
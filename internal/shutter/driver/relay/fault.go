@@ -0,0 +1,162 @@
+package relay
+
+import (
+	"context"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/pkg/errors"
+)
+
+// FaultRecovery is how a relays shutter recovers from a relay fault. An attempt drives both relays to their
+// safe state, the fault is cleared once it succeeds. The backoff doubles after every failed attempt.
+type FaultRecovery struct {
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultFaultRecovery retries after 5 seconds first and at least every 5 minutes.
+var DefaultFaultRecovery = FaultRecovery{Backoff: time.Second * 5, MaxBackoff: time.Minute * 5}
+
+// SetFaultRecovery replaces DefaultFaultRecovery, zero backoff disables recovery attempts.
+// A completed move clears a fault anyway.
+func (s *RelaysShutter) SetFaultRecovery(r FaultRecovery) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.recovery = r
+}
+
+func (s *RelaysShutter) Fault() (shutter.Fault, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.fault == nil {
+		return shutter.Fault{}, false
+	}
+	return *s.fault, true
+}
+
+// currentFault returns a copy of the active fault for an event. It is called with l held.
+func (s *RelaysShutter) currentFault() *shutter.Fault {
+	if s.fault == nil {
+		return nil
+	}
+	f := *s.fault
+	return &f
+}
+
+// setFault sets the fault a move ended with. A relay fault gets recovery attempts, an obstruction does not,
+// it is cleared by the next move.
+func (s *RelaysShutter) setFault(code string, err error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	since := s.clock.Now()
+	if s.fault != nil && s.fault.Code == code {
+		since = s.fault.Since
+	}
+	s.fault = &shutter.Fault{Code: code, Message: err.Error(), Since: since}
+	s.log.WithField("fault", code).Errorf("fault: %s", err)
+
+	if code == shutter.FaultObstructed {
+		s.stopRecovery() // the relays worked
+		return
+	}
+	s.scheduleRecovery(s.recovery.Backoff)
+}
+
+// relayFailed sets the fault of a relay run which ended with err, unless it was canceled.
+func (s *RelaysShutter) relayFailed(err error) {
+	switch {
+	case err == nil || err == context.Canceled || err == context.DeadlineExceeded:
+	case errors.Is(err, ErrForceDisabled):
+		s.setFault(shutter.FaultWatchdog, err)
+	default:
+		s.setFault(shutter.FaultRelay, err)
+	}
+}
+
+// clearFault clears the active fault, of given codes only or any when none given.
+func (s *RelaysShutter) clearFault(codes ...string) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.fault == nil || len(codes) > 0 && !containsString(codes, s.fault.Code) {
+		return
+	}
+
+	s.log.WithField("fault", s.fault.Code).Info("fault cleared")
+	s.fault = nil
+	s.stopRecovery()
+}
+
+// stopRecovery cancels a pending recovery attempt. It is called with l held.
+func (s *RelaysShutter) stopRecovery() {
+	if s.recoveryTimer != nil {
+		s.recoveryTimer.Stop()
+		s.recoveryTimer = nil
+	}
+}
+
+// scheduleRecovery makes a recovery attempt after backoff, unless one is pending already.
+// It is called with l held.
+func (s *RelaysShutter) scheduleRecovery(backoff time.Duration) {
+	if backoff <= 0 || s.recoveryTimer != nil {
+		return
+	}
+
+	s.recoveryAttempt++
+	attempt := s.recoveryAttempt
+	s.recoveryTimer = s.clock.AfterFunc(backoff, func() { s.recoverFault(attempt, backoff) })
+}
+
+// recoverFault makes a recovery attempt. It takes the place of a move, so a command given meanwhile
+// waits until the relays are in their safe state. A running move is not aborted, the attempt is retried later.
+func (s *RelaysShutter) recoverFault(attempt int, backoff time.Duration) {
+	s.l.Lock()
+	if s.recoveryTimer == nil || s.recoveryAttempt != attempt { // cleared meanwhile
+		s.l.Unlock()
+		return
+	}
+	s.recoveryTimer = nil
+	if s.currentMove != nil && !s.currentMove.ended() {
+		s.scheduleRecovery(backoff)
+		s.l.Unlock()
+		return
+	}
+	_, mv := s.retainMove(context.Background())
+	next := backoff * 2
+	if max := s.recovery.MaxBackoff; max > 0 && next > max {
+		next = max
+	}
+	s.l.Unlock()
+
+	err, downErr := ForceDisable(s.rUp), ForceDisable(s.rDown)
+	if err == nil || errors.Is(downErr, ErrNotWatched) {
+		err = downErr
+	}
+	close(mv.done)
+
+	s.l.Lock()
+	if s.fault == nil || s.recoveryAttempt != attempt { // cleared or set again meanwhile
+		s.l.Unlock()
+		return
+	}
+	switch {
+	case errors.Is(err, ErrNotWatched):
+		s.log.Warnf("fault not recoverable, a completed move clears it: %s", err)
+		s.l.Unlock()
+		return
+	case err != nil:
+		s.log.Warnf("fault recovery failed, next attempt in %s: %s", next, err)
+		s.scheduleRecovery(next)
+		s.l.Unlock()
+		return
+	}
+	s.log.WithField("fault", s.fault.Code).Info("fault recovered, relays in safe state")
+	s.fault = nil
+	s.l.Unlock()
+
+	s.notifyUpdate()
+}
@@ -0,0 +1,106 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelaysShutterFault(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c := clock.NewFake(epoch)
+	pin := &fakeSetPin{err: errors.New("device not alive")}
+	up := &Wired{Pin: pin, Clock: c}
+	s := NewRelaysShutter("test", up, &Wired{Pin: &fakeSetPin{}, Clock: c}, 100, 0, time.Second*10)
+	s.SetClock(c)
+	s.SetFaultRecovery(FaultRecovery{Backoff: time.Second * 5, MaxBackoff: time.Second * 8})
+	events := make(chan shutter.Event, 100)
+	defer s.Subscribe(func(e shutter.Event) { events <- e })()
+	movements := make(chan shutter.Movement, 10)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	t.Run("relay error sets a fault", func(t *testing.T) {
+		assert.NoError(t, s.Open(ctx))
+
+		assert.Equal(t, shutter.OutcomeError, (<-movements).Outcome)
+		e := nextEvent(t, events, func(e shutter.Event) bool { return e.Fault != nil })
+		assert.Equal(t, shutter.ShutterClosedState, e.State, "not left opening")
+		assert.Equal(t, &shutter.Fault{Code: shutter.FaultRelay, Message: "device not alive", Since: epoch}, e.Fault)
+		assert.False(t, up.IsEnabled())
+		assert.False(t, pin.isHigh(), "safe state tried")
+	})
+
+	t.Run("failed recovery backs off", func(t *testing.T) {
+		c.BlockUntil(1) // recovery attempt
+		c.Advance(time.Second * 5)
+		c.BlockUntil(1) // next attempt
+
+		f, ok := s.Fault()
+		assert.True(t, ok)
+		assert.Equal(t, epoch, f.Since)
+
+		c.Advance(time.Second * 7)
+		assert.Equal(t, 1, c.Waiters(), "backoff doubled, up to the max")
+	})
+
+	t.Run("recovery clears the fault", func(t *testing.T) {
+		pin.l.Lock()
+		pin.err = nil
+		pin.l.Unlock()
+		c.Advance(time.Second)
+
+		nextEvent(t, events, func(e shutter.Event) bool { return e.Fault == nil })
+		_, ok := s.Fault()
+		assert.False(t, ok)
+		assert.Equal(t, 0, c.Waiters())
+	})
+
+	t.Run("completed move clears the fault", func(t *testing.T) {
+		s.SetFaultRecovery(FaultRecovery{})
+		pin.l.Lock()
+		pin.err = errors.New("device not alive")
+		pin.l.Unlock()
+		assert.NoError(t, s.Open(ctx))
+		assert.Equal(t, shutter.OutcomeError, (<-movements).Outcome)
+		nextEvent(t, events, func(e shutter.Event) bool { return e.Fault != nil })
+		assert.Equal(t, 0, c.Waiters(), "no recovery attempts")
+
+		pin.l.Lock()
+		pin.err = nil
+		pin.l.Unlock()
+		assert.NoError(t, s.SetPosition(ctx, 10))
+		c.BlockUntil(2)
+		c.Advance(time.Second)
+		assert.Equal(t, shutter.OutcomeCompleted, (<-movements).Outcome)
+		_, ok := s.Fault()
+		assert.False(t, ok)
+	})
+}
+
+func TestRelaysShutterFaultNotWatched(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c := clock.NewFake(epoch)
+	up := &Wired{Pin: &fakeSetPin{err: errors.New("device not alive")}, Clock: c}
+	s := NewRelaysShutter("test", up, &Dumb{Clock: c}, 100, 0, time.Second*10)
+	s.SetClock(c)
+	movements := make(chan shutter.Movement, 10)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	assert.NoError(t, s.Open(ctx))
+	assert.Equal(t, shutter.OutcomeError, (<-movements).Outcome)
+
+	c.BlockUntil(1) // recovery attempt
+	c.Advance(DefaultFaultRecovery.Backoff)
+	assert.Eventually(t, func() bool { return c.Waiters() == 0 }, time.Second, time.Millisecond, "no more attempts")
+	_, ok := s.Fault()
+	assert.True(t, ok, "the down relay can not be driven to its safe state")
+}
@@ -27,3 +27,7 @@ func (r *PairedRelay) EnableFor(ctx context.Context, duration time.Duration) err
 func (r *PairedRelay) IsEnabled() bool {
 	return r.r.IsEnabled()
 }
+
+func (r *PairedRelay) Unwrap() Relay {
+	return r.r
+}
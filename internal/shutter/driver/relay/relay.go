@@ -2,8 +2,11 @@ package relay
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
 	"github.com/sirupsen/logrus"
 )
 
@@ -12,17 +15,69 @@ type Relay interface {
 	IsEnabled() bool
 }
 
+// Wrapper is implemented by relays wrapping another one, e.g. PoolProxy.
+type Wrapper interface {
+	Unwrap() Relay
+}
+
+// ErrNotWatched is returned by ForceDisable for a relay which can not be driven to its safe state.
+var ErrNotWatched = errors.New("relay can not be force disabled, it is not watched")
+
+// ForceDisable drives r, or the relay it wraps, to its safe state when it is Watched.
+// It returns ErrNotWatched for other relays.
+func ForceDisable(r Relay) error {
+	for r != nil {
+		if w, ok := r.(Watched); ok {
+			return w.ForceDisable()
+		}
+		wrapper, ok := r.(Wrapper)
+		if !ok {
+			break
+		}
+		r = wrapper.Unwrap()
+	}
+	return ErrNotWatched
+}
+
+// waitEnabled waits until r is enabled, e.g. after a wrapped relay waited for a free pool slot.
+// It returns false when ctx is done first.
+func waitEnabled(ctx context.Context, r Relay) bool {
+	for !r.IsEnabled() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Millisecond): // waits for another goroutine, not for the motor
+		}
+	}
+	return true
+}
+
 type PoolProxy struct {
 	r Relay
 	c chan struct{}
+
+	onWait func(wait time.Duration)
 }
 
 func NewPoolProxy(r Relay, pool chan struct{}) *PoolProxy {
 	return &PoolProxy{r: r, c: pool}
 }
 
+// OnWait registers a handler called with the time spent waiting for a free pool slot.
+func (p *PoolProxy) OnWait(h func(wait time.Duration)) {
+	p.onWait = h
+}
+
 func (p *PoolProxy) EnableFor(ctx context.Context, duration time.Duration) error {
-	p.c <- struct{}{}
+	start := time.Now()
+	select {
+	case p.c <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if p.onWait != nil {
+		p.onWait(time.Since(start))
+	}
 	defer func() {
 		<-p.c
 	}()
@@ -34,32 +89,51 @@ func (p *PoolProxy) IsEnabled() bool {
 	return p.r.IsEnabled()
 }
 
+func (p *PoolProxy) Unwrap() Relay {
+	return p.r
+}
+
 type Dumb struct {
 	Name string
+	// Clock defaults to the system clock.
+	Clock clock.Clock
 
+	l         sync.Mutex
 	isEnabled bool
 }
 
 func (r *Dumb) EnableFor(ctx context.Context, duration time.Duration) error {
-	r.isEnabled = true
-	defer func() { r.isEnabled = false }()
+	r.setEnabled(true)
+	defer r.setEnabled(false)
 
-	t := time.After(duration)
+	t := clock.Or(r.Clock).NewTimer(duration)
+	defer t.Stop()
 
-	logrus.Warnf("%s: dumb shutter start (for %s)", r.Name, duration.String())
+	log := logrus.WithField("relay", r.Name)
+	log.Warnf("dumb shutter start (for %s)", duration.String())
 
 	for {
 		select {
-		case <-t:
-			logrus.Warnf("%s: dumb shutter done", r.Name)
+		case <-t.C():
+			log.Warn("dumb shutter done")
 			return nil
 		case <-ctx.Done():
-			logrus.Warnf("%s: dumb shutter exit", r.Name)
+			log.Warn("dumb shutter exit")
 			return ctx.Err()
 		}
 	}
 }
 
+func (r *Dumb) setEnabled(enabled bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.isEnabled = enabled
+}
+
 func (r *Dumb) IsEnabled() bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+
 	return r.isEnabled
 }
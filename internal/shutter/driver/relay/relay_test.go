@@ -6,9 +6,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
 	"github.com/stretchr/testify/assert"
 )
 
+var epoch = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
 func TestPoolEnableFor(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -16,55 +19,94 @@ func TestPoolEnableFor(t *testing.T) {
 	pool := make(chan struct{}, 4)
 
 	t.Run("2 relays will run at once on a pool of 4", func(t *testing.T) {
-		start := time.Now()
-		enableProxiedRelaysFor(ctx, pool, 4, time.Millisecond*5)
-		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*5)
+		assert.Equal(t, time.Millisecond*5, enableProxiedRelaysFor(t, ctx, pool, time.Millisecond*5, 2))
 	})
 
 	t.Run("4 relays will run at once on a pool of 4", func(t *testing.T) {
-		start := time.Now()
-		enableProxiedRelaysFor(ctx, pool, 4, time.Millisecond*5)
-		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*5)
+		assert.Equal(t, time.Millisecond*5, enableProxiedRelaysFor(t, ctx, pool, time.Millisecond*5, 4))
 	})
 
 	t.Run("6 relays will run in two batches on a pool of 4", func(t *testing.T) {
-		start := time.Now()
-		enableProxiedRelaysFor(ctx, pool, 6, time.Millisecond*5)
-		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*10)
+		assert.Equal(t, time.Millisecond*10, enableProxiedRelaysFor(t, ctx, pool, time.Millisecond*5, 4, 2))
 	})
 
 	t.Run("9 relays will run in three batches on a pool of 4", func(t *testing.T) {
-		start := time.Now()
-		enableProxiedRelaysFor(ctx, pool, 9, time.Millisecond*5)
-		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*15)
+		assert.Equal(t, time.Millisecond*15, enableProxiedRelaysFor(t, ctx, pool, time.Millisecond*5, 4, 4, 1))
 	})
 }
 
-func enableProxiedRelaysFor(ctx context.Context, pool chan struct{}, num int, duration time.Duration) {
+// enableProxiedRelaysFor runs relays in expected batches on a virtual clock and returns how long it took.
+func enableProxiedRelaysFor(t *testing.T, ctx context.Context, pool chan struct{}, duration time.Duration, batches ...int) time.Duration {
+	c := clock.NewFake(epoch)
 	var wg sync.WaitGroup
 
-	for i := 0; i < num; i++ {
-		relay := NewPoolProxy(&Dumb{}, pool)
-		wg.Add(1)
-		go func() {
-			relay.EnableFor(ctx, duration)
-			wg.Done()
-		}()
+	for _, batch := range batches {
+		for i := 0; i < batch; i++ {
+			relay := NewPoolProxy(&Dumb{Clock: c}, pool)
+			wg.Add(1)
+			go func() {
+				relay.EnableFor(ctx, duration)
+				wg.Done()
+			}()
+		}
+	}
+
+	for _, batch := range batches {
+		c.BlockUntil(batch)
+		assert.Equal(t, batch, c.Waiters(), "relays running at once")
+		c.Advance(duration)
 	}
 
 	wg.Wait()
+	return c.Since(epoch)
 }
 
 func TestDumbEnableFor(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	relay := Dumb{}
+	c := clock.NewFake(epoch)
+	relay := Dumb{Clock: c}
+
+	t.Run("relay enabled for a minute is enabled until a minute passes", func(t *testing.T) {
+		done := make(chan error)
+		go func() { done <- relay.EnableFor(ctx, time.Minute) }()
 
-	t.Run("relay enabled for 5ms will be executed at least 5ms", func(t *testing.T) {
-		expectedDuration := time.Millisecond * 5
-		start := time.Now()
-		relay.EnableFor(ctx, expectedDuration)
-		assert.GreaterOrEqual(t, time.Since(start), expectedDuration)
+		c.BlockUntil(1)
+		assert.True(t, relay.IsEnabled())
+		c.Advance(time.Second * 59)
+		assert.True(t, relay.IsEnabled())
+		c.Advance(time.Second)
+		assert.NoError(t, <-done)
+		assert.False(t, relay.IsEnabled())
 	})
 }
+
+func TestWiredNormalClosed(t *testing.T) {
+	c := clock.NewFake(epoch)
+	pin := &fakeSetPin{}
+	relay := &Wired{Pin: pin, NormalClosed: true, Clock: c}
+
+	assert.NoError(t, relay.ForceDisable())
+	assert.True(t, pin.isHigh(), "disabled by a high pin")
+
+	done := make(chan error)
+	go func() { done <- relay.EnableFor(context.Background(), time.Minute) }()
+	c.BlockUntil(1)
+	assert.Eventually(t, func() bool { return !pin.isHigh() }, time.Second, time.Millisecond, "enabled by a low pin")
+	c.Advance(time.Minute)
+	assert.NoError(t, <-done)
+	assert.True(t, pin.isHigh(), "disabled by a high pin")
+}
+
+func TestPoolProxyCancelledWhileWaiting(t *testing.T) {
+	pool := make(chan struct{}, 1)
+	pool <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+	defer cancel()
+
+	r := &Dumb{}
+	assert.ErrorIs(t, NewPoolProxy(r, pool).EnableFor(ctx, time.Millisecond), context.DeadlineExceeded)
+	assert.False(t, r.IsEnabled())
+}
@@ -2,8 +2,12 @@ package relay
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
 	"github.com/jkaflik/shutter2mqtt/internal/shutter"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -16,41 +20,271 @@ type RelaysShutter struct {
 	name              string
 	fullOpenPosition  int
 	fullClosePosition int
-	timeToClose       time.Duration
-
-	updateHandler shutter.ShutterUpdateHandler
-
+	startDelay        time.Duration
+	stopOverrun       time.Duration
+	minMove           int
+	publishEvery      time.Duration
+	publishStep       int
+
+	notifier shutter.Notifier
+	log      *logrus.Entry
+	clock    clock.Clock
+
+	// l guards the state of the shutter, changed by commands, their moves and recovery attempts.
+	l               sync.Mutex
+	timeToClose     time.Duration
+	timeToOpen      time.Duration // zero when opening takes as long as closing
 	currentState    string
 	currentPosition int
+	currentTarget   int
+	currentMove     *move
+	obstructed      bool
+	fault           *shutter.Fault
+	recovery        FaultRecovery
+	recoveryTimer   clock.Timer
+	recoveryAttempt int
+	maxOnTime       time.Duration // zero without a watchdog
+
+	calibrationL       sync.Mutex
+	calibrationReached chan struct{} // while calibrating
+
+	powerL           sync.Mutex
+	powerDrawing     bool          // the motor drew power since the move started
+	powerStopped     chan struct{} // the motor stopped drawing power, nil without a power meter
+	unsubscribePower func()
+	endStopTolerance int
+
+	dutyCycle *DutyCycleLimiter
+
+	movementHandlers []shutter.MovementHandler
+}
+
+// motorStop is why a move ended by the power drawn by the motor.
+type motorStop int
+
+const (
+	motorRunning motorStop = iota
+	motorAtEndStop
+	motorObstructed
+)
+
+// moveEndTimeout bounds waiting for a canceled move to release its relay.
+const moveEndTimeout = time.Second * 5
 
-	cancelCurrentContext context.CancelFunc
+// move is an in-flight command context. superseded is set when a newer command cancels it.
+type move struct {
+	cancel     context.CancelFunc
+	superseded int32
+	// done is closed once the relay is released and the position is final.
+	done chan struct{}
+	prev *move
+}
+
+// ended reports whether mv released its relay.
+func (mv *move) ended() bool {
+	select {
+	case <-mv.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (s *RelaysShutter) ResetPosition(position int) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.resetPosition(position)
+	return nil
+}
+
+// resetPosition sets the position the shutter stopped at. It is called with l held.
+func (s *RelaysShutter) resetPosition(position int) {
 	s.currentPosition = position
 	if s.currentPosition == s.fullClosePosition {
 		s.currentState = shutter.ShutterClosedState
+	} else {
+		s.currentState = shutter.ShutterOpenState
 	}
-	s.currentState = shutter.ShutterOpenState
-
-	return nil
 }
 
 func NewRelaysShutter(name string, up Relay, down Relay, fullOpenPosition int, fullClosePosition int, timeToClose time.Duration) *RelaysShutter {
 	s := &RelaysShutter{rUp: up, rDown: down, name: name, fullOpenPosition: fullOpenPosition, fullClosePosition: fullClosePosition, timeToClose: timeToClose}
+	s.log = logrus.WithField("shutter", name)
+	s.clock = clock.Real
+	s.recovery = DefaultFaultRecovery
 	s.currentState = shutter.ShutterOpenState
 	s.currentPosition = s.fullClosePosition
 	return s
 }
 
-func (s *RelaysShutter) retainContext(parent context.Context) (ctx context.Context) {
-	if s.cancelCurrentContext != nil {
-		logrus.Debugf("%s: found previous operation context, cancel", s.name)
-		s.cancelCurrentContext()
+// SetClock replaces the system clock the position is estimated with, e.g. by a clock.Fake in tests.
+// Relays keep their own clock.
+func (s *RelaysShutter) SetClock(c clock.Clock) {
+	s.clock = c
+	if s.dutyCycle != nil {
+		s.dutyCycle.clock = c
+	}
+}
+
+// SetTimeToOpen sets the full travel time when opening, if it differs from the one when closing.
+func (s *RelaysShutter) SetTimeToOpen(timeToOpen time.Duration) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.timeToOpen = timeToOpen
+}
+
+// CompensateLatency makes the position estimate account for the motor starting startDelay after
+// a relay is energised and coasting for stopOverrun after it is released.
+func (s *RelaysShutter) CompensateLatency(startDelay, stopOverrun time.Duration) {
+	s.startDelay = startDelay
+	s.stopOverrun = stopOverrun
+}
+
+// SetMinMove ignores moves by less than positions, as they are too short to be estimated well.
+// Moves to the full open and close positions always run, the end-stops resync the position.
+func (s *RelaysShutter) SetMinMove(positions int) {
+	s.minMove = positions
+}
+
+// SetMaxOnTime sets how long relays may be energised before a watchdog force-disables them,
+// calibration travels stop earlier. Zero is no limit.
+func (s *RelaysShutter) SetMaxOnTime(d time.Duration) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.maxOnTime = d
+}
+
+// SetPublishRate sets how often the position is published during a move, every interval
+// or, when interval is zero, every step positions. It is every position by default.
+func (s *RelaysShutter) SetPublishRate(interval time.Duration, step int) {
+	s.publishEvery = interval
+	s.publishStep = step
+}
+
+// SensePower ends a move once the motor stopped drawing power. It resyncs the position at the end-stops
+// and reports an obstruction when the motor stopped mid-travel. A meter set before is unsubscribed.
+func (s *RelaysShutter) SensePower(m shutter.PowerMeter, threshold float64, endStopTolerance int) (stop func()) {
+	if s.unsubscribePower != nil {
+		s.unsubscribePower()
+	}
+
+	stopped := make(chan struct{}, 1)
+	s.powerStopped = stopped
+	s.endStopTolerance = endStopTolerance
+
+	// readings come faster than a move handles them, so the drop below threshold is detected here
+	s.unsubscribePower = m.SubscribePower(func(watts float64) {
+		s.powerL.Lock()
+		defer s.powerL.Unlock()
+
+		if watts >= threshold {
+			s.powerDrawing = true
+			return
+		}
+		if !s.powerDrawing { // not started yet
+			return
+		}
+		s.powerDrawing = false
+		select {
+		case stopped <- struct{}{}:
+		default:
+		}
+	})
+	return s.unsubscribePower
+}
+
+// resetPowerSensing forgets power readings from before a move.
+func (s *RelaysShutter) resetPowerSensing() {
+	s.powerL.Lock()
+	defer s.powerL.Unlock()
+
+	s.powerDrawing = false
+	select {
+	case <-s.powerStopped:
+	default:
+	}
+}
+
+// LimitDutyCycle protects the motor from overheating. Both relays share the run time budget.
+func (s *RelaysShutter) LimitDutyCycle(limits DutyCycleLimits) {
+	s.dutyCycle = NewDutyCycleLimiter(s.name, limits)
+	s.dutyCycle.clock = s.clock
+	s.dutyCycle.OnChange(s.notifyUpdate)
+	s.rUp = s.dutyCycle.Wrap(s.rUp)
+	s.rDown = s.dutyCycle.Wrap(s.rDown)
+}
+
+func (s *RelaysShutter) retainContext(parent context.Context) (context.Context, *move) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.retainMove(parent)
+}
+
+// retainMove cancels the current move and makes a new one, waiting for it. It is called with l held.
+func (s *RelaysShutter) retainMove(parent context.Context) (context.Context, *move) {
+	if s.currentMove != nil {
+		s.log.Debug("found previous operation context, cancel")
+		atomic.StoreInt32(&s.currentMove.superseded, 1)
+		s.currentMove.cancel()
 	}
 
-	ctx, s.cancelCurrentContext = context.WithCancel(parent)
-	return ctx
+	ctx, cancel := context.WithCancel(parent)
+	s.currentMove = &move{cancel: cancel, done: make(chan struct{}), prev: s.currentMove}
+	return ctx, s.currentMove
+}
+
+// waitMoveEnd waits until mv released its relay, so the position is not changed by it anymore.
+func (s *RelaysShutter) waitMoveEnd(mv *move) {
+	select {
+	case <-mv.done:
+		return
+	default:
+	}
+
+	timeout := s.clock.NewTimer(moveEndTimeout)
+	defer timeout.Stop()
+	select {
+	case <-mv.done:
+	case <-timeout.C():
+		s.log.Warnf("previous move did not end within %s", moveEndTimeout)
+	}
+}
+
+// fullTravelTime returns how long the motor takes to travel fully up or down. It is called with l held,
+// as are the other funcs estimating travel.
+func (s *RelaysShutter) fullTravelTime(up bool) time.Duration {
+	if up && s.timeToOpen > 0 {
+		return s.timeToOpen
+	}
+	return s.timeToClose
+}
+
+// travelTime returns how long the motor takes to move up or down by positions.
+func (s *RelaysShutter) travelTime(up bool, positions int) time.Duration {
+	return (s.fullTravelTime(up) * time.Duration(positions)) / 100
+}
+
+// travelPositions returns by how many positions the motor moves up or down in d, rounded.
+func (s *RelaysShutter) travelPositions(up bool, d time.Duration) int {
+	full := s.fullTravelTime(up)
+	if full <= 0 {
+		return 0
+	}
+	return int((d*100 + full/2) / full)
+}
+
+func (s *RelaysShutter) OnMovement(h shutter.MovementHandler) {
+	s.movementHandlers = append(s.movementHandlers, h)
+}
+
+func (s *RelaysShutter) reportMovement(m shutter.Movement) {
+	for _, h := range s.movementHandlers {
+		h(m)
+	}
 }
 
 func (s *RelaysShutter) Name() string {
@@ -58,10 +292,16 @@ func (s *RelaysShutter) Name() string {
 }
 
 func (s *RelaysShutter) Position() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
 	return s.currentPosition
 }
 
 func (s *RelaysShutter) State() string {
+	s.l.Lock()
+	defer s.l.Unlock()
+
 	return s.currentState
 }
 
@@ -73,153 +313,417 @@ func (s *RelaysShutter) FullClosePosition() int {
 	return s.fullClosePosition
 }
 
-func (s *RelaysShutter) OnUpdate(h shutter.ShutterUpdateHandler) {
-	s.updateHandler = h
+func (s *RelaysShutter) Subscribe(h shutter.EventHandler) (unsubscribe func()) {
+	return s.notifier.Subscribe(h)
+}
+
+// notifyUpdate notifies about the current state. The event is sent with l held, so events are in order.
+func (s *RelaysShutter) notifyUpdate() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	e := shutter.Event{
+		Shutter:  s.name,
+		State:    s.currentState,
+		Position: s.currentPosition,
+		Target:   s.currentPosition,
+		Time:     s.clock.Now(),
+	}
+
+	switch s.currentState {
+	case shutter.ShutterOpeningState:
+		e.Direction = shutter.DirectionUp
+		e.Target = s.currentTarget
+	case shutter.ShutterClosingState:
+		e.Direction = shutter.DirectionDown
+		e.Target = s.currentTarget
+	}
+
+	if s.dutyCycle != nil {
+		e.CoolingDown, _ = s.dutyCycle.CoolingDown()
+	}
+	e.Obstructed = s.obstructed
+	e.Fault = s.currentFault()
+
+	s.notifier.Notify(e)
+}
+
+func (s *RelaysShutter) newMovement(ctx context.Context, command string, start, target int) shutter.Movement {
+	m := shutter.NewMovement(ctx, s.name, command, start, target)
+	m.Time = s.clock.Now()
+	return m
+}
+
+func (s *RelaysShutter) commandLog(ctx context.Context, command string) *logrus.Entry {
+	return shutter.Logger(ctx, s.log).WithField("command", command)
 }
 
 func (s *RelaysShutter) Open(ctx context.Context) error {
-	logrus.Infof("%s: open", s.name)
-	ctx = s.retainContext(ctx)
+	log := s.commandLog(ctx, "open")
+	log.Info("open")
+	movement := s.newMovement(ctx, "open", s.Position(), s.fullOpenPosition)
+	ctx, mv := s.retainContext(ctx)
 
-	return s.setPosition(ctx, s.fullOpenPosition)
+	return s.setPosition(ctx, log, movement, mv)
 }
 
 func (s *RelaysShutter) Close(ctx context.Context) error {
-	logrus.Infof("%s: close", s.name)
-	ctx = s.retainContext(ctx)
+	log := s.commandLog(ctx, "close")
+	log.Info("close")
+	movement := s.newMovement(ctx, "close", s.Position(), s.fullClosePosition)
+	ctx, mv := s.retainContext(ctx)
 
-	return s.setPosition(ctx, s.fullClosePosition)
+	return s.setPosition(ctx, log, movement, mv)
 }
 
-func (s *RelaysShutter) Stop(_ context.Context) error {
-	logrus.Infof("%s: stop", s.name)
-
-	if s.cancelCurrentContext != nil {
-		s.cancelCurrentContext()
+func (s *RelaysShutter) Stop(ctx context.Context) error {
+	s.commandLog(ctx, "stop").Info("stop")
+	position := s.Position()
+	movement := s.newMovement(ctx, "stop", position, position)
+
+	s.l.Lock()
+	mv := s.currentMove
+	s.l.Unlock()
+	if mv != nil {
+		mv.cancel()
+		s.waitMoveEnd(mv)
 	}
 
-	if s.currentPosition == s.fullClosePosition {
-		s.currentState = shutter.ShutterClosedState
-	} else {
-		s.currentState = shutter.ShutterOpenState
-	}
+	s.l.Lock()
+	s.resetPosition(s.currentPosition)
+	movement.EndPosition = s.currentPosition
+	s.l.Unlock()
 
-	s.updateHandler(s.currentState, s.currentPosition)
+	s.notifyUpdate()
+
+	movement.Outcome = shutter.OutcomeCompleted
+	s.reportMovement(movement)
 
 	return nil
 }
-func (s *RelaysShutter) SetPosition(ctx context.Context, targetPosition int) error {
-	logrus.Infof("%s: set targetPosition to %d", s.name, targetPosition)
-	ctx = s.retainContext(ctx)
 
-	if targetPosition > s.fullOpenPosition || targetPosition < s.fullClosePosition {
-		return errors.Errorf(
-			"%s: %d is out of range open/close targetPosition for (%d/%d)",
-			s.name,
-			targetPosition,
-			s.fullOpenPosition,
-			s.fullClosePosition,
-		)
-	}
+func (s *RelaysShutter) SetPosition(ctx context.Context, targetPosition int) error {
+	log := s.commandLog(ctx, "set_position")
+	log.WithField("target", targetPosition).Info("set position")
+	movement := s.newMovement(ctx, "set_position", s.Position(), targetPosition)
+	ctx, mv := s.retainContext(ctx)
 
-	return s.setPosition(ctx, targetPosition)
+	return s.setPosition(ctx, log, movement, mv)
 }
 
-func (s *RelaysShutter) setPosition(ctx context.Context, targetPosition int) error {
-	logrus.Infof("%s: set targetPosition to %d", s.name, targetPosition)
+func (s *RelaysShutter) setPosition(ctx context.Context, log *logrus.Entry, movement shutter.Movement, mv *move) error {
+	targetPosition := movement.Target
+	log = log.WithField("target", targetPosition)
 
 	if targetPosition > s.fullOpenPosition || targetPosition < s.fullClosePosition {
-		return errors.Errorf(
+		err := errors.Errorf(
 			"%s: %d is out of range open/close targetPosition for (%d/%d)",
 			s.name,
 			targetPosition,
 			s.fullOpenPosition,
 			s.fullClosePosition,
 		)
+
+		movement.Outcome = shutter.OutcomeError
+		movement.Error = err.Error()
+		s.reportMovement(movement)
+		close(mv.done)
+		return err
+	}
+
+	if s.dutyCycle != nil && s.Position() != targetPosition {
+		if err := s.dutyCycle.Check(); err != nil {
+			log.Warnf("rejected: %s", err)
+			movement.Outcome = shutter.OutcomeError
+			movement.Error = err.Error()
+			s.reportMovement(movement)
+			close(mv.done)
+			return errors.Wrap(err, s.name)
+		}
 	}
 
 	go func() {
-		if s.currentPosition == targetPosition {
-			logrus.Debugf("%s: already on a position %d", s.name, targetPosition)
+		defer close(mv.done)
+		if mv.prev != nil {
+			s.waitMoveEnd(mv.prev)
+			mv.prev = nil
+		}
+
+		s.l.Lock()
+		from := s.currentPosition
+		if from == targetPosition {
+			s.l.Unlock()
+			log.Debug("already on a target position")
+			movement.Outcome = shutter.OutcomeCompleted
+			s.reportMovement(movement)
 			return
 		}
 
 		// todo refactor
 
-		diff := targetPosition - s.currentPosition
+		diff := targetPosition - from
 		if diff < 0 {
 			diff = -diff
 		}
 
-		timeToMove := (s.timeToClose * time.Duration(diff)) / 100
-		logrus.Debugf("%s: move by %d (%s)", s.name, diff, timeToMove.String())
+		if diff < s.minMove && targetPosition != s.fullOpenPosition && targetPosition != s.fullClosePosition {
+			s.l.Unlock()
+			log.Infof("move by %d ignored, below min move %d", diff, s.minMove)
+			movement.EndPosition = from
+			movement.Outcome = shutter.OutcomeCompleted
+			s.reportMovement(movement)
+			return
+		}
+
+		// the motor starts late and coasts after the relay is released
+		timeToMove := s.travelTime(targetPosition > from, diff) - s.stopOverrun
+		if timeToMove < 0 {
+			timeToMove = 0
+		}
+		timeToMove += s.startDelay
+		interval := s.publishInterval(targetPosition > from)
+
+		s.currentTarget = targetPosition
+		s.obstructed = false
 
 		// todo refactor
 		var relay Relay
-		if targetPosition > s.currentPosition {
+		if targetPosition > from {
 			s.currentState = shutter.ShutterOpeningState
 			relay = s.rUp
+			movement.Relay = shutter.DirectionUp
 		} else {
 			s.currentState = shutter.ShutterClosingState
 			relay = s.rDown
+			movement.Relay = shutter.DirectionDown
 		}
+		s.l.Unlock()
+
+		log.Debugf("move by %d (%s)", diff, timeToMove.String())
+		s.clearFault(shutter.FaultObstructed)
+		s.resetPowerSensing()
+
+		enabledAt := make(chan time.Time, 1)
+		relayCtx, releaseRelay := context.WithCancel(ctx)
+		defer releaseRelay()
+		calculation, stopCalculation := context.WithCancel(ctx)
+		calculated := make(chan struct{})
+		stop := motorRunning
+		go func() {
+			defer close(calculated)
+			stop = s.calculatePositionDuringMove(calculation, log, relay, from, targetPosition, interval, enabledAt)
+			if stop != motorRunning {
+				releaseRelay()
+			}
+		}()
+
+		log.Debugf("enable relay for %s", timeToMove.String())
+		start := s.clock.Now()
+		s.notifyUpdate()
+		err := relay.EnableFor(relayCtx, timeToMove)
+		stopCalculation()
+		<-calculated
 
-		go s.calculatePositionDuringMove(ctx, relay, targetPosition, timeToMove)
+		select {
+		case t := <-enabledAt:
+			movement.RunTime = s.clock.Since(t)
+		default:
+			if err == nil { // relay was done before it was noticed as enabled
+				movement.RunTime = s.clock.Since(start)
+			}
+		}
 
-		logrus.Debugf("%s: enable relay for %s", s.name, timeToMove.String())
-		s.updateHandler(s.currentState, s.currentPosition)
-		if err := relay.EnableFor(ctx, timeToMove); err != nil {
-			if err == context.Canceled || err == context.DeadlineExceeded {
-				logrus.Infof("%s: set position %d canceled", s.name, targetPosition)
+		if stop != motorRunning {
+			// the calculation already set the position the motor stopped at
+			s.l.Lock()
+			s.resetPosition(s.currentPosition)
+			s.obstructed = stop == motorObstructed
+			state, position := s.currentState, s.currentPosition
+			s.l.Unlock()
+
+			movement.Outcome = shutter.OutcomeCompleted
+			if stop == motorObstructed {
+				movement.Outcome = shutter.OutcomeObstructed
+				movement.Error = fmt.Sprintf("%s: obstructed at position %d", s.name, position)
+				s.setFault(shutter.FaultObstructed, errors.New(movement.Error))
 			} else {
-				logrus.Errorf("%s: enable relay error: %s", s.name, err)
+				s.clearFault()
 			}
+			s.notifyUpdate()
+
+			log.WithField("duration", s.clock.Since(start)).Infof("motor stopped, state %s, position %d", state, position)
+			movement.EndPosition = position
+			s.reportMovement(movement)
 			return
 		}
 
-		if targetPosition == s.fullClosePosition {
-			s.currentState = shutter.ShutterClosedState
-		} else {
-			s.currentState = shutter.ShutterOpenState
+		if err != nil {
+			// the move ended early, commit where it got to
+			s.l.Lock()
+			s.currentPosition = s.estimatePosition(from, targetPosition, movement.RunTime)
+			if err == context.Canceled && movement.RunTime > s.startDelay {
+				s.coast(targetPosition)
+			}
+			s.l.Unlock()
+
+			movement.Outcome = moveOutcome(err, mv)
+			switch movement.Outcome {
+			case shutter.OutcomeSuperseded:
+				log.WithField("duration", s.clock.Since(start)).Info("set position superseded")
+			case shutter.OutcomeCancelled:
+				log.WithField("duration", s.clock.Since(start)).Info("set position canceled")
+			default:
+				movement.Error = err.Error()
+				log.WithField("duration", s.clock.Since(start)).Errorf("enable relay error: %s", err)
+			}
+
+			var coolingDown *CoolingDownError
+			switch {
+			case errors.As(err, &coolingDown):
+				// the motor stopped before reaching the target, keep the estimated position
+				s.l.Lock()
+				s.resetPosition(s.currentPosition)
+				s.l.Unlock()
+				s.notifyUpdate()
+			case movement.Outcome == shutter.OutcomeError:
+				// the relay state is unknown, do not report the move as running
+				s.l.Lock()
+				s.resetPosition(s.currentPosition)
+				s.l.Unlock()
+				s.relayFailed(err)
+				s.notifyUpdate()
+			}
+
+			movement.EndPosition = s.Position()
+			s.reportMovement(movement)
+			return
 		}
-		s.currentPosition = targetPosition
-		s.updateHandler(s.currentState, s.currentPosition)
 
-		logrus.Infof("%s: updated state %s, position %d", s.name, s.currentState, s.currentPosition)
+		s.l.Lock()
+		s.resetPosition(targetPosition)
+		state := s.currentState
+		s.l.Unlock()
+		s.clearFault()
+		s.notifyUpdate()
+
+		log.WithField("duration", s.clock.Since(start)).Infof("updated state %s, position %d", state, targetPosition)
+
+		movement.EndPosition = targetPosition
+		movement.Outcome = shutter.OutcomeCompleted
+		s.reportMovement(movement)
 	}()
 
 	return nil
 }
 
-func (s *RelaysShutter) calculatePositionDuringMove(ctx context.Context, r Relay, targetPosition int, timeToMove time.Duration) {
-	for !r.IsEnabled() { // wait until relay is enabled, e.g. waiting for empty pool or something
-		time.Sleep(time.Millisecond)
+// coast moves the estimated position by what the motor travels in stopOverrun after a relay was
+// released mid-travel towards targetPosition. It is called with l held.
+func (s *RelaysShutter) coast(targetPosition int) {
+	if s.stopOverrun <= 0 {
+		return
+	}
+
+	positions := s.travelPositions(targetPosition > s.currentPosition, s.stopOverrun)
+	if targetPosition < s.currentPosition {
+		positions = -positions
 	}
 
-	logrus.Debugf("%s: begin position calculation", s.name)
-	s.updateHandler(s.currentState, s.currentPosition)
+	position := s.currentPosition + positions
+	if position > s.fullOpenPosition {
+		position = s.fullOpenPosition
+	}
+	if position < s.fullClosePosition {
+		position = s.fullClosePosition
+	}
+	s.currentPosition = position
+}
 
-	after := time.After(timeToMove)
-	every := time.NewTicker(s.timeToClose / time.Duration(s.fullOpenPosition-s.fullClosePosition))
+// calculatePositionDuringMove publishes the position estimated from the time elapsed since the relay got energised.
+// With a power meter, it returns why the motor stopped before the relay was released.
+func (s *RelaysShutter) calculatePositionDuringMove(ctx context.Context, log *logrus.Entry, r Relay, from, targetPosition int, interval time.Duration, enabledAt chan<- time.Time) motorStop {
+	if !waitEnabled(ctx, r) {
+		return motorRunning
+	}
+	since := s.clock.Now()
+	enabledAt <- since
+
+	log.Debug("begin position calculation")
+	s.notifyUpdate()
+
+	every := s.clock.NewTicker(interval)
 	defer every.Stop()
 	for {
 		select {
-		case <-after:
-			logrus.Debugf("%s: timeout position calculation", s.name)
-			return
 		case <-ctx.Done():
-			logrus.Debugf("%s: exit position calculation", s.name)
-			return
-		case <-every.C:
-			if s.currentPosition < targetPosition {
-				logrus.Tracef("%s: increase position", s.name)
-				s.currentPosition++
-			} else {
-				logrus.Tracef("%s: decrease position", s.name)
-				s.currentPosition--
+			log.Debug("exit position calculation")
+			return motorRunning
+		case <-s.powerStopped:
+			s.l.Lock()
+			position := s.estimatePosition(from, targetPosition, s.clock.Since(since))
+			endStop := s.fullClosePosition
+			if targetPosition > from {
+				endStop = s.fullOpenPosition
+			}
+			if distance := endStop - position; distance <= s.endStopTolerance && -distance <= s.endStopTolerance {
+				s.currentPosition = endStop
+				s.l.Unlock()
+				log.Infof("motor stopped at the end-stop, estimated position was %d", position)
+				return motorAtEndStop
+			}
+			s.currentPosition = position
+			s.l.Unlock()
+
+			log.Warnf("motor stopped mid-travel at position %d, obstructed", position)
+			return motorObstructed
+		case <-every.C():
+			s.l.Lock()
+			position := s.estimatePosition(from, targetPosition, s.clock.Since(since))
+			moved := position != s.currentPosition
+			s.currentPosition = position
+			s.l.Unlock()
+			if !moved {
+				continue
 			}
 
-			s.updateHandler(s.currentState, s.currentPosition)
+			log.Tracef("position %d", position)
+			s.notifyUpdate()
 		}
 	}
 }
+
+// estimatePosition returns the position of a move from towards targetPosition, after the relay was
+// energised for elapsed. It never passes the target, the move ends there.
+func (s *RelaysShutter) estimatePosition(from, targetPosition int, elapsed time.Duration) int {
+	moving := elapsed - s.startDelay
+	if moving <= 0 {
+		return from
+	}
+
+	positions := s.travelPositions(targetPosition > from, moving)
+	if targetPosition > from {
+		if from+positions > targetPosition {
+			return targetPosition
+		}
+		return from + positions
+	}
+	if from-positions < targetPosition {
+		return targetPosition
+	}
+	return from - positions
+}
+
+// publishInterval returns how often the position is published during a move up or down, every position by default.
+func (s *RelaysShutter) publishInterval(up bool) time.Duration {
+	interval := s.travelTime(up, 1)
+	switch {
+	case s.publishEvery > 0:
+		interval = s.publishEvery
+	case s.publishStep > 0:
+		interval = s.travelTime(up, s.publishStep)
+	}
+
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	return interval
+}
@@ -0,0 +1,324 @@
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelaysShutterMovement(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s := NewRelaysShutter("test", &Dumb{}, &Dumb{}, 100, 0, time.Millisecond*100)
+	movements := make(chan shutter.Movement, 10)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	t.Run("completed move", func(t *testing.T) {
+		assert.NoError(t, s.SetPosition(shutter.WithSource(ctx, shutter.SourceHTTP), 20))
+
+		m := <-movements
+		assert.Equal(t, shutter.OutcomeCompleted, m.Outcome)
+		assert.Equal(t, shutter.SourceHTTP, m.Source)
+		assert.Equal(t, "set_position", m.Command)
+		assert.Equal(t, 0, m.StartPosition)
+		assert.Equal(t, 20, m.EndPosition)
+		assert.Equal(t, shutter.DirectionUp, m.Relay)
+		assert.InDelta(t, time.Millisecond*20, m.RunTime, float64(time.Millisecond*15))
+	})
+
+	t.Run("superseded and cancelled moves", func(t *testing.T) {
+		assert.NoError(t, s.Open(ctx))
+		time.Sleep(time.Millisecond * 10)
+		assert.NoError(t, s.Close(ctx))
+		time.Sleep(time.Millisecond * 10)
+		assert.NoError(t, s.Stop(ctx))
+
+		outcomes := map[string]string{}
+		for i := 0; i < 3; i++ {
+			m := <-movements
+			outcomes[m.Command] = m.Outcome
+		}
+		assert.Equal(t, map[string]string{
+			"open":  shutter.OutcomeSuperseded,
+			"close": shutter.OutcomeCancelled,
+			"stop":  shutter.OutcomeCompleted,
+		}, outcomes)
+	})
+
+	t.Run("out of range position", func(t *testing.T) {
+		assert.Error(t, s.SetPosition(ctx, 120))
+		assert.Equal(t, shutter.OutcomeError, (<-movements).Outcome)
+	})
+}
+
+func TestRelaysShutterPositionProgress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c := clock.NewFake(epoch)
+	s := NewRelaysShutter("test", &Dumb{Clock: c}, &Dumb{Clock: c}, 100, 0, time.Second*45)
+	s.SetClock(c)
+	events := make(chan shutter.Event, 100)
+	defer s.Subscribe(func(e shutter.Event) { events <- e })()
+	movements := make(chan shutter.Movement, 1)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	assert.NoError(t, s.SetPosition(ctx, 5))
+	c.BlockUntil(2) // relay run and position calculation ticker
+
+	step := time.Millisecond * 450 // 45s per 100%
+	for position := 1; position < 5; position++ {
+		c.Advance(step)
+		e := nextEvent(t, events, func(e shutter.Event) bool { return e.Position == position })
+		assert.Equal(t, shutter.ShutterOpeningState, e.State)
+		assert.Equal(t, 5, e.Target)
+		assert.Equal(t, epoch.Add(step*time.Duration(position)), e.Time)
+	}
+
+	c.Advance(step)
+	e := nextEvent(t, events, func(e shutter.Event) bool { return e.State == shutter.ShutterOpenState })
+	assert.Equal(t, 5, e.Position)
+
+	m := <-movements
+	assert.Equal(t, shutter.OutcomeCompleted, m.Outcome)
+	assert.Equal(t, epoch, m.Time)
+	assert.Equal(t, step*5, m.RunTime)
+}
+
+func TestRelaysShutterLatencyCompensation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c := clock.NewFake(epoch)
+	s := NewRelaysShutter("test", &Dumb{Clock: c}, &Dumb{Clock: c}, 100, 0, time.Second*10)
+	s.SetClock(c)
+	s.CompensateLatency(time.Millisecond*300, time.Millisecond*100)
+	s.SetMinMove(5)
+	events := make(chan shutter.Event, 100)
+	defer s.Subscribe(func(e shutter.Event) { events <- e })()
+	movements := make(chan shutter.Movement, 10)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	t.Run("relay runs longer by start delay and shorter by stop overrun", func(t *testing.T) {
+		assert.NoError(t, s.SetPosition(ctx, 20))
+		c.BlockUntil(2) // relay run and position calculation ticker
+		c.Advance(time.Millisecond * 2199)
+		assert.Empty(t, movements)
+
+		c.Advance(time.Millisecond)
+		m := <-movements
+		assert.Equal(t, time.Millisecond*2200, m.RunTime)
+		assert.Equal(t, 20, m.EndPosition)
+	})
+
+	t.Run("moves below min move are ignored", func(t *testing.T) {
+		assert.NoError(t, s.SetPosition(ctx, 23))
+		m := <-movements
+		assert.Equal(t, shutter.OutcomeCompleted, m.Outcome)
+		assert.Equal(t, 20, m.EndPosition)
+		assert.Equal(t, time.Duration(0), m.RunTime)
+	})
+
+	t.Run("stop adds the stop overrun", func(t *testing.T) {
+		assert.NoError(t, s.Open(ctx))
+		c.BlockUntil(2)
+		c.Advance(time.Millisecond * 300)
+		for position := 21; position <= 30; position++ {
+			c.Advance(time.Millisecond * 100)
+			nextEvent(t, events, func(e shutter.Event) bool { return e.Position == position })
+		}
+
+		assert.NoError(t, s.Stop(ctx))
+		assert.Equal(t, 31, s.Position(), "coasted by 100ms")
+		assert.Equal(t, shutter.ShutterOpenState, s.State())
+		assert.Equal(t, shutter.OutcomeCancelled, (<-movements).Outcome)
+		assert.Equal(t, 31, (<-movements).EndPosition, "stop")
+	})
+
+	t.Run("stop before the motor started does not move", func(t *testing.T) {
+		assert.NoError(t, s.Close(ctx))
+		c.BlockUntil(2)
+		c.Advance(time.Millisecond * 200)
+
+		assert.NoError(t, s.Stop(ctx))
+		assert.Equal(t, 31, s.Position())
+		<-movements
+		<-movements
+	})
+}
+
+func TestRelaysShutterInterimPosition(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c := clock.NewFake(epoch)
+	s := NewRelaysShutter("test", &Dumb{Clock: c}, &Dumb{Clock: c}, 100, 0, time.Second*10)
+	s.SetClock(c)
+	s.SetPublishRate(0, 10)
+	events := make(chan shutter.Event, 100)
+	defer s.Subscribe(func(e shutter.Event) { events <- e })()
+	movements := make(chan shutter.Movement, 10)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	t.Run("published every step", func(t *testing.T) {
+		assert.NoError(t, s.SetPosition(ctx, 50))
+		c.BlockUntil(2) // relay run and position calculation ticker
+		for position := 10; position <= 20; position += 10 {
+			c.Advance(time.Second)
+			e := nextEvent(t, events, func(e shutter.Event) bool { return e.Position != 0 })
+			assert.Equal(t, position, e.Position)
+		}
+	})
+
+	t.Run("superseded move commits the elapsed position", func(t *testing.T) {
+		c.Advance(time.Millisecond * 340)
+		assert.NoError(t, s.SetPosition(ctx, 0))
+
+		m := <-movements
+		assert.Equal(t, shutter.OutcomeSuperseded, m.Outcome)
+		assert.Equal(t, 23, m.EndPosition, "2.34s of 10s")
+		assert.Equal(t, time.Millisecond*2340, m.RunTime)
+	})
+
+	t.Run("stopped move commits the elapsed position", func(t *testing.T) {
+		c.BlockUntil(2)
+		c.Advance(time.Millisecond * 1050)
+		assert.NoError(t, s.Stop(ctx))
+
+		assert.Equal(t, 12, s.Position(), "10.5 positions down from 23")
+		assert.Equal(t, shutter.ShutterOpenState, s.State())
+		m := <-movements
+		assert.Equal(t, shutter.OutcomeCancelled, m.Outcome)
+		assert.Equal(t, 12, m.EndPosition)
+	})
+}
+
+type testPowerMeter struct {
+	h shutter.PowerHandler
+}
+
+func (m *testPowerMeter) SubscribePower(h shutter.PowerHandler) func() {
+	m.h = h
+	return func() {}
+}
+
+func TestRelaysShutterPowerSensing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c := clock.NewFake(epoch)
+	s := NewRelaysShutter("test", &Dumb{Clock: c}, &Dumb{Clock: c}, 100, 0, time.Second*10)
+	s.SetClock(c)
+	meter := &testPowerMeter{}
+	s.SensePower(meter, 10, 5)
+	events := make(chan shutter.Event, 100)
+	defer s.Subscribe(func(e shutter.Event) { events <- e })()
+	movements := make(chan shutter.Movement, 10)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	// power delivers a reading and waits until the position calculation took it
+	power := func(watts float64) {
+		meter.h(watts)
+		assert.Eventually(t, func() bool { return len(s.powerStopped) == 0 }, time.Second, time.Millisecond)
+	}
+
+	t.Run("stop near the end-stop snaps to it", func(t *testing.T) {
+		assert.NoError(t, s.Open(ctx))
+		c.BlockUntil(2) // relay run and position calculation ticker
+		power(0)
+		power(120)
+		c.Advance(time.Millisecond * 9600)
+		nextEvent(t, events, func(e shutter.Event) bool { return e.Position == 96 })
+		power(2)
+
+		m := <-movements
+		assert.Equal(t, shutter.OutcomeCompleted, m.Outcome)
+		assert.Equal(t, 100, m.EndPosition)
+		assert.Equal(t, shutter.ShutterOpenState, s.State())
+		assert.Equal(t, time.Millisecond*9600, m.RunTime, "relay released")
+	})
+
+	t.Run("stop mid-travel is an obstruction", func(t *testing.T) {
+		assert.NoError(t, s.Close(ctx))
+		c.BlockUntil(2)
+		power(120)
+		c.Advance(time.Second * 3)
+		nextEvent(t, events, func(e shutter.Event) bool { return e.Position == 70 })
+		power(0)
+
+		m := <-movements
+		assert.Equal(t, shutter.OutcomeObstructed, m.Outcome)
+		assert.Equal(t, "test: obstructed at position 70", m.Error)
+		assert.Equal(t, 70, s.Position())
+		e := nextEvent(t, events, func(e shutter.Event) bool { return e.State == shutter.ShutterOpenState })
+		assert.True(t, e.Obstructed)
+		if assert.NotNil(t, e.Fault) {
+			assert.Equal(t, shutter.FaultObstructed, e.Fault.Code)
+		}
+	})
+
+	t.Run("next move clears the obstruction", func(t *testing.T) {
+		assert.NoError(t, s.SetPosition(ctx, 60))
+		c.BlockUntil(2)
+		e := nextEvent(t, events, func(e shutter.Event) bool { return e.State == shutter.ShutterClosingState })
+		assert.False(t, e.Obstructed)
+		assert.Nil(t, e.Fault)
+
+		c.Advance(time.Second)
+		assert.Equal(t, shutter.OutcomeCompleted, (<-movements).Outcome)
+		assert.Equal(t, 60, s.Position())
+	})
+
+	t.Run("readings faster than the move takes them", func(t *testing.T) {
+		assert.NoError(t, s.Close(ctx))
+		c.BlockUntil(2)
+		c.Advance(time.Millisecond * 5500)
+		nextEvent(t, events, func(e shutter.Event) bool { return e.Position == 5 })
+		meter.h(120)
+		meter.h(0)
+
+		m := <-movements
+		assert.Equal(t, shutter.OutcomeCompleted, m.Outcome, "a draw followed by a drop is not merged into no draw")
+		assert.Equal(t, 0, s.Position(), "snapped to the end-stop")
+	})
+}
+
+func nextEvent(t *testing.T, events <-chan shutter.Event, match func(e shutter.Event) bool) shutter.Event {
+	t.Helper()
+
+	for {
+		select {
+		case e := <-events:
+			if match(e) {
+				return e
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no matching event")
+			return shutter.Event{}
+		}
+	}
+}
+
+func TestRelaysShutterDutyCycle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s := NewRelaysShutter("test", &Dumb{}, &Dumb{}, 100, 0, time.Millisecond*100)
+	s.LimitDutyCycle(DutyCycleLimits{MaxRun: time.Millisecond * 20, CoolDown: time.Second})
+	movements := make(chan shutter.Movement, 10)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+
+	assert.NoError(t, s.Open(ctx))
+	m := <-movements
+	assert.Equal(t, shutter.OutcomeError, m.Outcome, "run cut by max run")
+	assert.Equal(t, shutter.ShutterOpenState, s.State())
+	assert.Less(t, s.Position(), 100)
+
+	assert.Error(t, s.Close(ctx), "rejected while cooling down")
+	assert.Equal(t, shutter.OutcomeError, (<-movements).Outcome)
+}
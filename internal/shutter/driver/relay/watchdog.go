@@ -0,0 +1,234 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/sirupsen/logrus"
+)
+
+// Watched relays can be supervised by a Watchdog.
+type Watched interface {
+	// EnabledSince returns when the relay got energised, enabled is false when it is off.
+	EnabledSince() (since time.Time, enabled bool)
+	// ForceDisable drives the relay output to its safe state, aborting a run in progress.
+	ForceDisable() error
+}
+
+type Violation struct {
+	Shutter string
+	Relay   string
+	OnTime  time.Duration
+	// Err is set when the relay could not be force-disabled.
+	Err error
+}
+
+type watchedRelay struct {
+	shutter string
+	relay   string
+	r       Watched
+}
+
+// Watchdog force-disables relays energised longer than a hard maximum on-time.
+// It works on the relay outputs only, so it still protects motors when the shutter logic hangs.
+type Watchdog struct {
+	maxOnTime time.Duration
+	clock     clock.Clock
+
+	l        sync.Mutex
+	relays   []watchedRelay
+	handlers []func(v Violation)
+	// checked is when Run checked relays last
+	checked time.Time
+}
+
+// NewWatchdog creates a watchdog, zero maxOnTime disables the on-time check, but not the safe state.
+func NewWatchdog(maxOnTime time.Duration) *Watchdog {
+	return &Watchdog{maxOnTime: maxOnTime, clock: clock.Real}
+}
+
+// SetClock replaces the system clock Run checks on-times with, it has to be the clock of watched relays.
+func (w *Watchdog) SetClock(c clock.Clock) {
+	w.clock = c
+}
+
+func (w *Watchdog) Watch(shutterName, relayName string, r Watched) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	w.relays = append(w.relays, watchedRelay{shutter: shutterName, relay: relayName, r: r})
+}
+
+// Unwatch stops watching relays of a shutter, e.g. when it gets removed.
+func (w *Watchdog) Unwatch(shutterName string) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	relays := make([]watchedRelay, 0, len(w.relays))
+	for _, wr := range w.relays {
+		if wr.shutter != shutterName {
+			relays = append(relays, wr)
+		}
+	}
+	w.relays = relays
+}
+
+// UnwatchRelays stops watching the relays, e.g. of a shutter replaced by one of the same name.
+func (w *Watchdog) UnwatchRelays(rs ...Watched) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	relays := make([]watchedRelay, 0, len(w.relays))
+	for _, wr := range w.relays {
+		if !containsWatched(rs, wr.r) {
+			relays = append(relays, wr)
+		}
+	}
+	w.relays = relays
+}
+
+// OnViolation registers a handler called for every relay force-disabled by the watchdog.
+func (w *Watchdog) OnViolation(h func(v Violation)) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	w.handlers = append(w.handlers, h)
+}
+
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	if w.maxOnTime <= 0 {
+		return
+	}
+
+	every := w.clock.NewTicker(interval)
+	defer every.Stop()
+
+	w.setChecked(w.clock.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-every.C():
+			w.Check(now)
+			w.setChecked(now)
+		}
+	}
+}
+
+func (w *Watchdog) setChecked(now time.Time) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	w.checked = now
+}
+
+// Stalled returns an error when Run did not check relays for longer than timeout before now,
+// e.g. stuck on a relay. It is nil when the on-time check is disabled or Run did not start.
+func (w *Watchdog) Stalled(now time.Time, timeout time.Duration) error {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	if w.maxOnTime <= 0 || w.checked.IsZero() || now.Sub(w.checked) <= timeout {
+		return nil
+	}
+	return fmt.Errorf("relay watchdog did not check relays for %s", now.Sub(w.checked))
+}
+
+// Check force-disables relays enabled for longer than the max on-time and returns the violations.
+func (w *Watchdog) Check(now time.Time) (violations []Violation) {
+	if w.maxOnTime <= 0 {
+		return nil
+	}
+
+	w.l.Lock()
+	relays := w.relays
+	handlers := w.handlers
+	w.l.Unlock()
+
+	for _, wr := range relays {
+		since, enabled := wr.r.EnabledSince()
+		if !enabled || now.Sub(since) <= w.maxOnTime {
+			continue
+		}
+
+		v := Violation{Shutter: wr.shutter, Relay: wr.relay, OnTime: now.Sub(since), Err: wr.r.ForceDisable()}
+		log := logrus.WithFields(logrus.Fields{"shutter": v.Shutter, "relay": v.Relay})
+		if v.Err != nil {
+			log.Errorf("watchdog: relay on for %s, force disable failed: %s", v.OnTime, v.Err)
+		} else {
+			log.Errorf("watchdog: relay on for %s exceeded %s, force disabled", v.OnTime, w.maxOnTime)
+		}
+
+		for _, h := range handlers {
+			h(v)
+		}
+		violations = append(violations, v)
+	}
+	return violations
+}
+
+// WaitOff waits until watched relays are off, of given shutters only or all when none given.
+func (w *Watchdog) WaitOff(ctx context.Context, shutterNames ...string) error {
+	w.l.Lock()
+	relays := w.relays
+	w.l.Unlock()
+
+	for _, wr := range relays {
+		if len(shutterNames) > 0 && !containsString(shutterNames, wr.shutter) {
+			continue
+		}
+
+		for {
+			if _, enabled := wr.r.EnabledSince(); !enabled {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%s/%s: relay still on: %w", wr.shutter, wr.relay, ctx.Err())
+			case <-time.After(time.Millisecond * 10):
+			}
+		}
+	}
+	return nil
+}
+
+// SafeState drives all watched relays to their safe state, no matter whether they are enabled.
+func (w *Watchdog) SafeState() error {
+	w.l.Lock()
+	relays := w.relays
+	w.l.Unlock()
+
+	var failed []string
+	for _, wr := range relays {
+		if err := wr.r.ForceDisable(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s/%s: %s", wr.shutter, wr.relay, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("safe state failed for %d relays: %v", len(failed), failed)
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsWatched(relays []Watched, r Watched) bool {
+	for _, v := range relays {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSetPin struct {
+	l    sync.Mutex
+	high bool
+	err  error
+}
+
+func (p *fakeSetPin) High() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	p.high = true
+	return p.err
+}
+
+func (p *fakeSetPin) Low() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	p.high = false
+	return p.err
+}
+
+func (p *fakeSetPin) isHigh() bool {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	return p.high
+}
+
+func TestWatchdog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.Run("relay over max on-time gets force disabled", func(t *testing.T) {
+		pin := &fakeSetPin{}
+		r := &Wired{Pin: pin}
+		w := NewWatchdog(time.Millisecond * 10)
+		w.Watch("test", "up", r)
+
+		var violations []Violation
+		w.OnViolation(func(v Violation) { violations = append(violations, v) })
+
+		done := make(chan error)
+		go func() { done <- r.EnableFor(ctx, time.Minute) }()
+		time.Sleep(time.Millisecond * 5)
+
+		assert.Empty(t, w.Check(time.Now()))
+		assert.True(t, pin.isHigh())
+
+		assert.Len(t, w.Check(time.Now().Add(time.Millisecond*10)), 1)
+		assert.ErrorIs(t, <-done, ErrForceDisabled)
+		assert.False(t, pin.isHigh())
+		assert.False(t, r.IsEnabled())
+
+		if assert.Len(t, violations, 1) {
+			assert.Equal(t, "test", violations[0].Shutter)
+			assert.Equal(t, "up", violations[0].Relay)
+			assert.NoError(t, violations[0].Err)
+		}
+	})
+
+	t.Run("safe state disables all relays", func(t *testing.T) {
+		up, down := &fakeSetPin{high: true}, &fakeSetPin{high: true, err: errors.New("device not alive")}
+		w := NewWatchdog(0)
+		w.Watch("test", "up", &Wired{Pin: up})
+		w.Watch("test", "down", &Wired{Pin: down})
+
+		assert.Error(t, w.SafeState())
+		assert.False(t, up.isHigh())
+		assert.False(t, down.isHigh())
+	})
+}
+
+func TestWatchdogWaitOff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r := &Wired{Pin: &fakeSetPin{}}
+	w := NewWatchdog(0)
+	w.Watch("test", "up", r)
+
+	go r.EnableFor(ctx, time.Millisecond*20)
+	time.Sleep(time.Millisecond * 5)
+
+	start := time.Now()
+	assert.NoError(t, w.WaitOff(ctx))
+	assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*10)
+
+	go r.EnableFor(ctx, time.Minute)
+	time.Sleep(time.Millisecond * 5)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Millisecond*20)
+	defer waitCancel()
+	assert.ErrorIs(t, w.WaitOff(waitCtx), context.DeadlineExceeded)
+}
+
+func TestWatchdogUnwatch(t *testing.T) {
+	pin := &fakeSetPin{high: true}
+	w := NewWatchdog(0)
+	w.Watch("test", "up", &Wired{Pin: pin})
+	w.Watch("other", "up", &Wired{Pin: &fakeSetPin{}})
+
+	w.Unwatch("test")
+	assert.NoError(t, w.SafeState())
+	assert.True(t, pin.isHigh(), "unwatched relay left untouched")
+
+	replaced, replacement := &fakeSetPin{high: true}, &fakeSetPin{high: true}
+	r := &Wired{Pin: replaced}
+	w.Watch("test", "up", r)
+	w.Watch("test", "up", &Wired{Pin: replacement})
+
+	w.UnwatchRelays(r)
+	assert.NoError(t, w.SafeState())
+	assert.True(t, replaced.isHigh(), "unwatched relay left untouched")
+	assert.False(t, replacement.isHigh(), "relay of the same shutter name still watched")
+}
+
+func TestWatchdogStalled(t *testing.T) {
+	c := clock.NewFake(time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC))
+	w := NewWatchdog(time.Minute)
+	w.SetClock(c)
+	assert.NoError(t, w.Stalled(c.Now().Add(time.Hour), time.Second*3), "not running")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx, time.Second)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	c.BlockUntil(1)
+
+	assert.Eventually(t, func() bool {
+		return w.Stalled(c.Now().Add(time.Second*4), time.Second*3) != nil
+	}, time.Second, time.Millisecond, "no check since the start")
+
+	c.Advance(time.Second * 2)
+	assert.Eventually(t, func() bool {
+		return w.Stalled(c.Now().Add(time.Second*2), time.Second*3) == nil
+	}, time.Second, time.Millisecond, "checked on a tick")
+}
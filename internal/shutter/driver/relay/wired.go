@@ -3,8 +3,10 @@ package relay
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
 	"github.com/racerxdl/go-mcp23017"
 	"github.com/sirupsen/logrus"
 )
@@ -47,16 +49,31 @@ type SetPin interface {
 	Low() error
 }
 
+// ErrForceDisabled is returned by a run aborted by a Watchdog.
+var ErrForceDisabled = errors.New("relay force disabled by watchdog")
+
 type Wired struct {
-	Pin          SetPin
+	Pin SetPin
+	// NormalClosed relays are enabled by a low pin and disabled, also force disabled, by a high one.
 	NormalClosed bool
+	// Clock defaults to the system clock.
+	Clock clock.Clock
 
+	l         sync.Mutex
 	isEnabled bool
+	enabledAt time.Time
+	forced    chan struct{}
 }
 
 func (p *Wired) EnableFor(ctx context.Context, duration time.Duration) error {
-	after := time.After(duration)
-	if err := p.enable(); err != nil {
+	after := clock.Or(p.Clock).NewTimer(duration)
+	defer after.Stop()
+	forced, err := p.enable()
+	if err != nil {
+		// the output state is unknown, try the safe state
+		if err := p.disable(); err != nil {
+			logrus.Error(err)
+		}
 		return err
 	}
 	defer func() {
@@ -67,8 +84,10 @@ func (p *Wired) EnableFor(ctx context.Context, duration time.Duration) error {
 
 	for {
 		select {
-		case <-after:
+		case <-after.C():
 			return nil
+		case <-forced:
+			return ErrForceDisabled
 		case <-ctx.Done():
 			logrus.Debug("wired relay context exit")
 			return ctx.Err()
@@ -77,17 +96,53 @@ func (p *Wired) EnableFor(ctx context.Context, duration time.Duration) error {
 }
 
 func (p *Wired) IsEnabled() bool {
+	p.l.Lock()
+	defer p.l.Unlock()
+
 	return p.isEnabled
 }
 
-func (p *Wired) enable() error {
+func (p *Wired) EnabledSince() (time.Time, bool) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	return p.enabledAt, p.isEnabled
+}
+
+func (p *Wired) ForceDisable() error {
+	p.l.Lock()
+	if p.forced != nil {
+		close(p.forced)
+		p.forced = nil
+	}
+	p.l.Unlock()
+
+	return p.disable()
+}
+
+func (p *Wired) enable() (<-chan struct{}, error) {
+	p.l.Lock()
 	p.isEnabled = true
+	p.enabledAt = clock.Or(p.Clock).Now()
+	p.forced = make(chan struct{})
+	forced := p.forced
+	p.l.Unlock()
 
-	return p.Pin.High()
+	return forced, p.setPin(true)
 }
 
 func (p *Wired) disable() error {
+	p.l.Lock()
 	p.isEnabled = false
+	p.forced = nil
+	p.l.Unlock()
 
+	return p.setPin(false)
+}
+
+func (p *Wired) setPin(enabled bool) error {
+	if enabled != p.NormalClosed {
+		return p.Pin.High()
+	}
 	return p.Pin.Low()
 }
@@ -0,0 +1,119 @@
+// Package simulated is a shutter driver without hardware, for developing automations.
+// Its Motor travels at its own speed per direction, starts with a delay, stops at the end-stops
+// and varies run to run, so the estimated position drifts from the true one like with a real motor.
+package simulated
+
+import (
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
+)
+
+// Config is the config of simulated shutter kind. Positions and TimeToClose are what the position
+// is estimated with, like for relays shutter kind, Motor is how the simulated shutter really moves.
+type Config struct {
+	FullOpenPosition  int           `yaml:"full_open_position"`
+	FullClosePosition int           `yaml:"full_close_position"`
+	TimeToClose       time.Duration `yaml:"time_to_close"`
+
+	Motor MotorConfig `yaml:"motor"`
+}
+
+func (c *Config) Validate() error {
+	var errs driver.FieldErrors
+	if c.FullOpenPosition <= c.FullClosePosition {
+		errs.Add([]string{"full_open_position"}, "must be greater than full_close_position (%d)", c.FullClosePosition)
+	}
+	if c.TimeToClose <= 0 {
+		errs.Add([]string{"time_to_close"}, "must be positive")
+	}
+
+	if c.Motor.TimeToOpen < 0 {
+		errs.Add([]string{"motor", "time_to_open"}, "must not be negative")
+	}
+	if c.Motor.TimeToClose < 0 {
+		errs.Add([]string{"motor", "time_to_close"}, "must not be negative")
+	}
+	if c.Motor.StartupDelay < 0 {
+		errs.Add([]string{"motor", "startup_delay"}, "must not be negative")
+	}
+	if c.Motor.Jitter < 0 || c.Motor.Jitter >= 1 {
+		errs.Add([]string{"motor", "jitter"}, "%g is out of range 0-1", c.Motor.Jitter)
+	}
+	if p := c.Motor.InitialPosition; p != nil && (*p > c.FullOpenPosition || *p < c.FullClosePosition) {
+		errs.Add(
+			[]string{"motor", "initial_position"},
+			"%d is out of range open/close position (%d/%d)",
+			*p,
+			c.FullOpenPosition,
+			c.FullClosePosition,
+		)
+	}
+	return errs.Err()
+}
+
+func (c *Config) Positions() (fullOpen, fullClose int) {
+	return c.FullOpenPosition, c.FullClosePosition
+}
+
+// MeasuresPower returns true, the simulated motor reports the power it draws.
+func (c *Config) MeasuresPower() bool {
+	return true
+}
+
+func init() {
+	driver.RegisterShutter("simulated", driver.ShutterDriver{
+		Config: func() interface{} { return &Config{} },
+		New: func(b driver.Builder, name string, config interface{}) (shutter.Shutter, error) {
+			return New(name, *config.(*Config), driver.BuilderClock(b)), nil
+		},
+	})
+}
+
+// Shutter is a relays shutter moving a simulated motor. It reports the true position of the motor
+// next to the estimated one, and the power the motor draws.
+type Shutter struct {
+	*relay.RelaysShutter
+	motor *Motor
+}
+
+// defaultMotorPower is what a shutter motor typically draws, in watts.
+const defaultMotorPower = 120
+
+// New returns a simulated shutter on clock c, the system clock when nil.
+func New(name string, cfg Config, c clock.Clock) *Shutter {
+	if cfg.Motor.TimeToOpen <= 0 {
+		cfg.Motor.TimeToOpen = cfg.TimeToClose
+	}
+	if cfg.Motor.TimeToClose <= 0 {
+		cfg.Motor.TimeToClose = cfg.TimeToClose
+	}
+	if cfg.Motor.Power <= 0 {
+		cfg.Motor.Power = defaultMotorPower
+	}
+
+	m := NewMotor(name, cfg.FullOpenPosition, cfg.FullClosePosition, cfg.Motor, c)
+	s := relay.NewRelaysShutter(name, m.Relay(true), m.Relay(false), cfg.FullOpenPosition, cfg.FullClosePosition, cfg.TimeToClose)
+	s.SetClock(m.clock)
+	return &Shutter{RelaysShutter: s, motor: m}
+}
+
+func (s *Shutter) Motor() *Motor {
+	return s.motor
+}
+
+func (s *Shutter) TruePosition() int {
+	return s.motor.Position()
+}
+
+func (s *Shutter) SubscribeTruePosition(h shutter.EventHandler) (unsubscribe func()) {
+	return s.motor.Subscribe(h)
+}
+
+// SubscribePower makes the motor a power meter of the shutter.
+func (s *Shutter) SubscribePower(h shutter.PowerHandler) (unsubscribe func()) {
+	return s.motor.SubscribePower(h)
+}
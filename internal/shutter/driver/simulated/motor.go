@@ -0,0 +1,261 @@
+package simulated
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/sirupsen/logrus"
+)
+
+// MotorConfig is the physics of a simulated motor. Zero travel times default to the estimated one of the shutter.
+type MotorConfig struct {
+	// TimeToOpen and TimeToClose are true full travel times per direction.
+	TimeToOpen  time.Duration `yaml:"time_to_open"`
+	TimeToClose time.Duration `yaml:"time_to_close"`
+	// StartupDelay passes between energising a relay and the motor moving.
+	StartupDelay time.Duration `yaml:"startup_delay"`
+	// Jitter varies the speed of every run randomly by up to the given fraction, e.g. 0.05 for ±5%.
+	Jitter float64 `yaml:"jitter"`
+	// Seed makes jitter reproducible, 0 seeds it randomly.
+	Seed int64 `yaml:"seed"`
+	// InitialPosition is the true position on start, full close position when not set.
+	InitialPosition *int `yaml:"initial_position"`
+	// Power is drawn while a relay is energised, until the motor reaches the end-stop, in watts.
+	// A simulated shutter defaults it to 120.
+	Power float64 `yaml:"power"`
+}
+
+// Motor models a shutter motor moved by an up and a down relay. It stops at the end-stops on its own,
+// while the relays stay energised, and does not move while both relays are.
+type Motor struct {
+	name      string
+	cfg       MotorConfig
+	fullOpen  float64
+	fullClose float64
+	clock     clock.Clock
+	notifier  shutter.Notifier
+	power     shutter.PowerNotifier
+
+	l        sync.Mutex
+	rand     *rand.Rand
+	up       bool
+	down     bool
+	since    time.Time // when the relays last changed
+	start    float64   // position at since
+	speed    float64   // positions per second once started, negative when closing
+	reported int
+	drawn    float64 // last reported power
+}
+
+// NewMotor returns a motor at its initial position. Travel times of cfg have to be set.
+func NewMotor(name string, fullOpenPosition, fullClosePosition int, cfg MotorConfig, c clock.Clock) *Motor {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	m := &Motor{
+		name:      name,
+		cfg:       cfg,
+		fullOpen:  float64(fullOpenPosition),
+		fullClose: float64(fullClosePosition),
+		clock:     clock.Or(c),
+		rand:      rand.New(rand.NewSource(seed)),
+		start:     float64(fullClosePosition),
+	}
+	if cfg.InitialPosition != nil {
+		m.start = m.clamp(float64(*cfg.InitialPosition))
+	}
+	m.since = m.clock.Now()
+	m.reported = m.round(m.start)
+	return m
+}
+
+// Relay returns the up or down relay of the motor.
+func (m *Motor) Relay(up bool) *Relay {
+	return &Relay{motor: m, up: up}
+}
+
+// Position returns the true position.
+func (m *Motor) Position() int {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	return m.round(m.positionAt(m.clock.Now()))
+}
+
+// Subscribe registers a handler called asynchronously when the true position or state changes.
+func (m *Motor) Subscribe(h shutter.EventHandler) (unsubscribe func()) {
+	return m.notifier.Subscribe(h)
+}
+
+// SubscribePower registers a handler called when the power drawn changes.
+func (m *Motor) SubscribePower(h shutter.PowerHandler) (unsubscribe func()) {
+	return m.power.SubscribePower(h)
+}
+
+func (m *Motor) energise(up, on bool) {
+	m.l.Lock()
+	now := m.clock.Now()
+	m.start = m.positionAt(now)
+	m.since = now
+	if up {
+		m.up = on
+	} else {
+		m.down = on
+	}
+
+	m.speed = 0
+	switch {
+	case m.up && m.down:
+		logrus.WithField("shutter", m.name).Warn("simulated motor: both relays energised, motor stalled")
+	case m.up:
+		m.speed = m.runSpeed(m.cfg.TimeToOpen)
+	case m.down:
+		m.speed = -m.runSpeed(m.cfg.TimeToClose)
+	}
+	e := m.event(now)
+	watts, drawnChanged := m.drawnAt(now)
+	m.l.Unlock()
+
+	m.notifier.Notify(e)
+	if drawnChanged {
+		m.power.Notify(watts)
+	}
+}
+
+// runSpeed returns the speed of a new run, varied by jitter.
+func (m *Motor) runSpeed(travel time.Duration) float64 {
+	speed := (m.fullOpen - m.fullClose) / travel.Seconds()
+	if m.cfg.Jitter > 0 {
+		speed *= 1 + m.cfg.Jitter*(m.rand.Float64()*2-1)
+	}
+	return speed
+}
+
+// report notifies subscribers when the rounded true position or the power drawn changed.
+func (m *Motor) report() {
+	m.l.Lock()
+	now := m.clock.Now()
+	watts, drawnChanged := m.drawnAt(now)
+	position := m.round(m.positionAt(now))
+	if position == m.reported {
+		m.l.Unlock()
+		if drawnChanged {
+			m.power.Notify(watts)
+		}
+		return
+	}
+	e := m.event(now)
+	m.l.Unlock()
+
+	m.notifier.Notify(e)
+	if drawnChanged {
+		m.power.Notify(watts)
+	}
+}
+
+// drawnAt returns the power drawn at now and whether it changed since reported last time.
+func (m *Motor) drawnAt(now time.Time) (watts float64, changed bool) {
+	position := m.positionAt(now)
+	if m.speed > 0 && position < m.fullOpen || m.speed < 0 && position > m.fullClose {
+		watts = m.cfg.Power
+	}
+
+	changed = watts != m.drawn
+	m.drawn = watts
+	return watts, changed
+}
+
+// reportInterval is how long the faster direction takes to travel by a position.
+func (m *Motor) reportInterval() time.Duration {
+	travel := m.cfg.TimeToOpen
+	if m.cfg.TimeToClose < travel {
+		travel = m.cfg.TimeToClose
+	}
+	return time.Duration(float64(travel) / (m.fullOpen - m.fullClose))
+}
+
+func (m *Motor) positionAt(now time.Time) float64 {
+	moving := now.Sub(m.since) - m.cfg.StartupDelay
+	if moving <= 0 || m.speed == 0 {
+		return m.start
+	}
+	return m.clamp(m.start + m.speed*moving.Seconds())
+}
+
+func (m *Motor) event(now time.Time) shutter.Event {
+	position := m.positionAt(now)
+	m.reported = m.round(position)
+
+	e := shutter.Event{Shutter: m.name, Position: m.reported, Target: m.reported, Time: now}
+	switch {
+	case m.speed > 0 && position < m.fullOpen:
+		e.State, e.Direction, e.Target = shutter.ShutterOpeningState, shutter.DirectionUp, m.round(m.fullOpen)
+	case m.speed < 0 && position > m.fullClose:
+		e.State, e.Direction, e.Target = shutter.ShutterClosingState, shutter.DirectionDown, m.round(m.fullClose)
+	case position <= m.fullClose:
+		e.State = shutter.ShutterClosedState
+	default:
+		e.State = shutter.ShutterOpenState
+	}
+	return e
+}
+
+func (m *Motor) clamp(position float64) float64 {
+	return math.Max(m.fullClose, math.Min(m.fullOpen, position))
+}
+
+func (m *Motor) round(position float64) int {
+	return int(math.Round(position))
+}
+
+// Relay energises the motor in one direction, it implements relay.Relay.
+type Relay struct {
+	motor *Motor
+	up    bool
+
+	l         sync.Mutex
+	isEnabled bool
+}
+
+func (r *Relay) EnableFor(ctx context.Context, duration time.Duration) error {
+	after := r.motor.clock.NewTimer(duration)
+	defer after.Stop()
+	every := r.motor.clock.NewTicker(r.motor.reportInterval())
+	defer every.Stop()
+
+	r.setEnabled(true)
+	defer r.setEnabled(false)
+
+	for {
+		select {
+		case <-after.C():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-every.C():
+			r.motor.report()
+		}
+	}
+}
+
+func (r *Relay) IsEnabled() bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	return r.isEnabled
+}
+
+func (r *Relay) setEnabled(enabled bool) {
+	r.l.Lock()
+	r.isEnabled = enabled
+	r.l.Unlock()
+
+	r.motor.energise(r.up, enabled)
+}
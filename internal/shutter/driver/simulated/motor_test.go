@@ -0,0 +1,193 @@
+package simulated
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+var epoch = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// run enables r for d on the fake clock c and waits until the run is over.
+func run(t *testing.T, c *clock.Fake, r *Relay, d time.Duration) {
+	t.Helper()
+
+	done := make(chan error)
+	go func() { done <- r.EnableFor(context.Background(), d) }()
+	c.BlockUntil(2) // run timer and report ticker
+	c.Advance(d)
+	assert.NoError(t, <-done)
+}
+
+func TestMotor(t *testing.T) {
+	c := clock.NewFake(epoch)
+	m := NewMotor("kitchen", 100, 0, MotorConfig{
+		TimeToOpen:   time.Second * 10,
+		TimeToClose:  time.Second * 8,
+		StartupDelay: time.Second,
+	}, c)
+	up, down := m.Relay(true), m.Relay(false)
+	assert.Equal(t, 0, m.Position())
+
+	t.Run("travels per direction after the startup delay", func(t *testing.T) {
+		run(t, c, up, time.Millisecond*500)
+		assert.Equal(t, 0, m.Position(), "did not start")
+
+		run(t, c, up, time.Second*6)
+		assert.Equal(t, 50, m.Position())
+
+		run(t, c, down, time.Second*3)
+		assert.Equal(t, 25, m.Position())
+	})
+
+	t.Run("stops at the end-stops", func(t *testing.T) {
+		events := make(chan shutter.Event, 100)
+		defer m.Subscribe(func(e shutter.Event) { events <- e })()
+
+		run(t, c, up, time.Minute)
+		assert.Equal(t, 100, m.Position())
+		assert.Equal(t, shutter.ShutterOpenState, lastEvent(events).State)
+
+		run(t, c, down, time.Minute)
+		assert.Equal(t, 0, m.Position())
+		assert.Equal(t, shutter.ShutterClosedState, lastEvent(events).State)
+	})
+
+	t.Run("stalls with both relays energised", func(t *testing.T) {
+		m.energise(true, true)
+		m.energise(false, true)
+		c.Advance(time.Minute)
+		assert.Equal(t, 0, m.Position())
+
+		m.energise(false, false)
+		c.Advance(time.Second * 6)
+		assert.Equal(t, 50, m.Position(), "up relay left energised moves it")
+		m.energise(true, false)
+	})
+}
+
+func TestMotorReportsPositionWhileMoving(t *testing.T) {
+	c := clock.NewFake(epoch)
+	m := NewMotor("kitchen", 100, 0, MotorConfig{TimeToOpen: time.Second * 10, TimeToClose: time.Second * 10}, c)
+	events := make(chan shutter.Event, 100)
+	defer m.Subscribe(func(e shutter.Event) { events <- e })()
+
+	done := make(chan error)
+	go func() { done <- m.Relay(true).EnableFor(context.Background(), time.Second) }()
+	c.BlockUntil(2)
+	assert.Equal(t, shutter.ShutterOpeningState, (<-events).State)
+
+	for position := 1; position < 10; position++ {
+		c.Advance(time.Millisecond * 100)
+		e := <-events
+		assert.Equal(t, position, e.Position)
+		assert.Equal(t, 100, e.Target)
+	}
+
+	c.Advance(time.Millisecond * 100)
+	assert.NoError(t, <-done)
+	e := lastEvent(events)
+	assert.Equal(t, shutter.ShutterOpenState, e.State, "stopped")
+	assert.Equal(t, 10, e.Position)
+}
+
+func TestMotorJitter(t *testing.T) {
+	cfg := MotorConfig{TimeToOpen: time.Second * 10, TimeToClose: time.Second * 10, Jitter: 0.1, Seed: 1}
+
+	var positions []int
+	for i := 0; i < 2; i++ {
+		c := clock.NewFake(epoch)
+		m := NewMotor("kitchen", 100, 0, cfg, c)
+		run(t, c, m.Relay(true), time.Second*5)
+		positions = append(positions, m.Position())
+	}
+
+	assert.Equal(t, positions[0], positions[1], "reproducible with a seed")
+	assert.InDelta(t, 50, positions[0], 5)
+	assert.NotEqual(t, 50, positions[0])
+}
+
+func TestSimulatedShutterDrifts(t *testing.T) {
+	var cfg driver.Config
+	assert.NoError(t, yaml.Unmarshal([]byte(`
+full_open_position: 100
+full_close_position: 0
+time_to_close: 10s
+motor: {time_to_open: 12s, startup_delay: 500ms, initial_position: 20}
+`), &cfg))
+
+	c := clock.NewFake(epoch)
+	sh, err := driver.NewShutter(testBuilder{c}, "simulated", "kitchen", cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	s := sh.(*Shutter)
+	assert.Equal(t, 20, s.TruePosition())
+	assert.NoError(t, s.ResetPosition(20))
+
+	movements := make(chan shutter.Movement, 1)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+	assert.NoError(t, s.SetPosition(context.Background(), 80))
+	c.BlockUntil(3) // position calculation and motor relay
+	c.Advance(time.Second * 6)
+
+	assert.Equal(t, shutter.OutcomeCompleted, (<-movements).Outcome)
+	assert.Equal(t, 80, s.Position(), "estimated")
+	assert.Equal(t, 66, s.TruePosition(), "5.5s of 12s travel")
+}
+
+func TestSimulatedShutterSensesEndStop(t *testing.T) {
+	c := clock.NewFake(epoch)
+	s := New("kitchen", Config{
+		FullOpenPosition: 100,
+		TimeToClose:      time.Second * 10,
+		Motor:            MotorConfig{TimeToOpen: time.Second * 8},
+	}, c)
+	s.SensePower(s, 10, 25)
+
+	movements := make(chan shutter.Movement, 1)
+	s.OnMovement(func(m shutter.Movement) { movements <- m })
+	assert.NoError(t, s.Open(context.Background()))
+	c.BlockUntil(3) // position calculation and motor relay
+	c.Advance(time.Second * 8)
+
+	m := <-movements
+	assert.Equal(t, shutter.OutcomeCompleted, m.Outcome)
+	assert.Equal(t, 100, m.EndPosition, "snapped to the end-stop")
+	assert.Equal(t, time.Second*8, m.RunTime, "released once the motor stopped")
+	assert.Equal(t, 100, s.TruePosition())
+}
+
+type testBuilder struct {
+	clock clock.Clock
+}
+
+func (b testBuilder) Relay(shutterName, relayName string, cfg driver.Config) (driver.Relay, error) {
+	return driver.NewRelay(b, shutterName, relayName, cfg)
+}
+
+func (b testBuilder) Pin(cfg driver.Config) (driver.Pin, error) {
+	return driver.NewPin(b, cfg)
+}
+
+func (b testBuilder) Clock() clock.Clock {
+	return b.clock
+}
+
+// lastEvent returns the last event delivered within a short while.
+func lastEvent(events <-chan shutter.Event) (last shutter.Event) {
+	for {
+		select {
+		case e := <-events:
+			last = e
+		case <-time.After(time.Millisecond * 50):
+			return last
+		}
+	}
+}
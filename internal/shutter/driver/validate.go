@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Validator is implemented by driver config types checking their values, e.g. ranges.
+// Nested driver sections, e.g. relays of a shutter, are validated by their own drivers.
+// Validate returns FieldErrors, so problems point at the values.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError is a problem with a value of a driver config section.
+// Path is relative to the section, elements are YAML keys, e.g. motor, jitter.
+type FieldError struct {
+	Path []string
+	Msg  string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", strings.Join(e.Path, "."), e.Msg)
+}
+
+// FieldErrors aggregates all problems found in a driver config section.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Add appends a problem with the value at path.
+func (e *FieldErrors) Add(path []string, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e as an error, nil when there are no problems.
+func (e FieldErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Positioned is implemented by shutter driver configs, so positions set in other config sections can be checked.
+type Positioned interface {
+	Positions() (fullOpen, fullClose int)
+}
+
+// RelayTimed is implemented by shutter driver configs running relays, so their runs can be checked against
+// limits of the application, e.g. a relay watchdog. RelayRuns returns the longest run by the key setting it.
+type RelayTimed interface {
+	RelayRuns() map[string]time.Duration
+}
+
+// PowerMetered is implemented by shutter driver configs of shutters measuring the power drawn by their motor.
+type PowerMetered interface {
+	MeasuresPower() bool
+}
+
+// Validate validates config, a value returned by a driver Config func after decoding.
+// It does nothing for config types not implementing Validator.
+func Validate(config interface{}) error {
+	if v, ok := config.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package shutter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DirectionNone = ""
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+const subscriptionBufferSize = 32
+
+type Event struct {
+	Shutter   string `json:"shutter"`
+	State     string `json:"state"`
+	Position  int    `json:"position"`
+	Target    int    `json:"target"`
+	Direction string `json:"direction,omitempty"`
+	// CoolingDown is set while the motor is protected from overheating and does not accept moves.
+	CoolingDown bool `json:"cooling_down,omitempty"`
+	// Obstructed is set after the motor stopped mid-travel, until the next move.
+	Obstructed bool `json:"obstructed,omitempty"`
+	// Fault is the active fault, nil when there is none.
+	Fault *Fault    `json:"fault,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+type EventHandler func(e Event)
+
+type subscription struct {
+	handler EventHandler
+	events  chan Event
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Notifier delivers events to subscribers asynchronously. Every subscriber gets its own goroutine
+// and buffer, so a slow one does not stall the notifying side nor other subscribers.
+// When a buffer is full, the oldest pending event is dropped in favour of the newest one.
+type Notifier struct {
+	l             sync.Mutex
+	subscriptions map[*subscription]struct{}
+}
+
+func (n *Notifier) Subscribe(h EventHandler) (unsubscribe func()) {
+	sub := &subscription{handler: h, events: make(chan Event, subscriptionBufferSize), done: make(chan struct{})}
+
+	n.l.Lock()
+	if n.subscriptions == nil {
+		n.subscriptions = map[*subscription]struct{}{}
+	}
+	n.subscriptions[sub] = struct{}{}
+	n.l.Unlock()
+
+	go sub.run()
+
+	return func() {
+		n.l.Lock()
+		delete(n.subscriptions, sub)
+		n.l.Unlock()
+
+		sub.once.Do(func() { close(sub.done) })
+	}
+}
+
+func (n *Notifier) Notify(e Event) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	for sub := range n.subscriptions {
+		select {
+		case sub.events <- e:
+			continue
+		default:
+		}
+
+		select {
+		case <-sub.events:
+			logrus.WithField("shutter", e.Shutter).Debug("subscriber too slow, event dropped")
+		default:
+		}
+		// only Notify sends, under the lock, so there is room now
+		sub.events <- e
+	}
+}
+
+func (s *subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case e := <-s.events:
+			s.handler(e)
+		}
+	}
+}
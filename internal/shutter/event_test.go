@@ -0,0 +1,75 @@
+package shutter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifier(t *testing.T) {
+	t.Run("events are delivered in order to every subscriber", func(t *testing.T) {
+		var n Notifier
+		first, second := make(chan Event, 10), make(chan Event, 10)
+		defer n.Subscribe(func(e Event) { first <- e })()
+		defer n.Subscribe(func(e Event) { second <- e })()
+
+		for i := 0; i < 3; i++ {
+			n.Notify(Event{Position: i})
+		}
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, i, (<-first).Position)
+			assert.Equal(t, i, (<-second).Position)
+		}
+	})
+
+	t.Run("slow subscriber does not block and receives the latest event", func(t *testing.T) {
+		var n Notifier
+		release := make(chan struct{})
+		received := make(chan Event, subscriptionBufferSize*2)
+		defer n.Subscribe(func(e Event) {
+			<-release
+			received <- e
+		})()
+
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < subscriptionBufferSize*2; i++ {
+				n.Notify(Event{Position: i})
+			}
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("notify blocked on a slow subscriber")
+		}
+
+		close(release)
+		var last Event
+		for last.Position != subscriptionBufferSize*2-1 {
+			select {
+			case last = <-received:
+			case <-time.After(time.Second):
+				t.Fatal("latest event not delivered")
+			}
+		}
+	})
+
+	t.Run("unsubscribed handler is not called", func(t *testing.T) {
+		var n Notifier
+		called := make(chan Event, 1)
+		unsubscribe := n.Subscribe(func(e Event) { called <- e })
+		unsubscribe()
+		unsubscribe()
+
+		n.Notify(Event{})
+		select {
+		case <-called:
+			t.Fatal("handler called after unsubscribe")
+		case <-time.After(time.Millisecond * 10):
+		}
+	})
+}
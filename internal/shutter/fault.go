@@ -0,0 +1,28 @@
+package shutter
+
+import (
+	"time"
+)
+
+const (
+	// FaultRelay is set when a relay could not be switched, e.g. its I/O expander is not responding.
+	FaultRelay = "relay"
+	// FaultWatchdog is set when the watchdog force-disabled a relay energised for too long.
+	FaultWatchdog = "watchdog"
+	// FaultObstructed is set when the motor stopped mid-travel, until the next move.
+	FaultObstructed = "obstructed"
+)
+
+// Fault is a problem with a shutter which needs attention. The position is an estimate while it is set.
+type Fault struct {
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+	Since   time.Time `json:"since"`
+}
+
+// FaultReporter is implemented by shutters which detect faults of their hardware.
+// Events of Subscribe carry the active fault.
+type FaultReporter interface {
+	// Fault returns the active fault, ok is false when there is none.
+	Fault() (f Fault, ok bool)
+}
@@ -0,0 +1,53 @@
+package shutter
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	OutcomeCompleted  = "completed"
+	OutcomeCancelled  = "cancelled"
+	OutcomeSuperseded = "superseded"
+	OutcomeBlocked    = "blocked"
+	OutcomeObstructed = "obstructed"
+	OutcomeError      = "error"
+)
+
+// Movement describes a handled command, from the moment it was received until its outcome is known.
+type Movement struct {
+	Time          time.Time
+	Shutter       string
+	Command       string
+	Source        string
+	Origin        string
+	RequestID     string
+	StartPosition int
+	EndPosition   int
+	Target        int
+	Relay         string
+	RunTime       time.Duration
+	Outcome       string
+	Error         string
+}
+
+type MovementHandler func(m Movement)
+
+// MovementReporter is implemented by shutters able to report handled commands.
+type MovementReporter interface {
+	OnMovement(h MovementHandler)
+}
+
+func NewMovement(ctx context.Context, shutter string, command string, position int, target int) Movement {
+	return Movement{
+		Time:          time.Now(),
+		Shutter:       shutter,
+		Command:       command,
+		Source:        SourceFromContext(ctx),
+		Origin:        OriginFromContext(ctx),
+		RequestID:     RequestIDFromContext(ctx),
+		StartPosition: position,
+		EndPosition:   position,
+		Target:        target,
+	}
+}
@@ -0,0 +1,56 @@
+package shutter
+
+import "sync"
+
+// PowerHandler is called with a reading of the power drawn by a shutter motor, in watts.
+type PowerHandler func(watts float64)
+
+// PowerMeter is a source of readings of the power drawn by a shutter motor, e.g. a relay board measuring
+// the motor current or a topic of a device with a power meter.
+type PowerMeter interface {
+	SubscribePower(h PowerHandler) (unsubscribe func())
+}
+
+// PowerSensing is implemented by shutters which detect end-stops and obstructions from the power drawn by their motor.
+type PowerSensing interface {
+	// SensePower takes the motor as stopped once its power drops below threshold watts during a move.
+	// A stop within endStopTolerance positions from the end-stop it moves to snaps the position to it,
+	// a stop further away is an obstruction. Calling stop unsubscribes from m.
+	SensePower(m PowerMeter, threshold float64, endStopTolerance int) (stop func())
+}
+
+// PowerNotifier delivers power readings to subscribers, for PowerMeter implementations.
+// Unlike Notifier, handlers are called synchronously and have to return quickly.
+type PowerNotifier struct {
+	l        sync.Mutex
+	seq      int
+	handlers map[int]PowerHandler
+}
+
+func (n *PowerNotifier) SubscribePower(h PowerHandler) (unsubscribe func()) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	if n.handlers == nil {
+		n.handlers = map[int]PowerHandler{}
+	}
+	n.seq++
+	id := n.seq
+	n.handlers[id] = h
+
+	return func() {
+		n.l.Lock()
+		defer n.l.Unlock()
+
+		delete(n.handlers, id)
+	}
+}
+
+func (n *PowerNotifier) Notify(watts float64) {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	for _, h := range n.handlers {
+		h(watts)
+	}
+}
@@ -11,8 +11,6 @@ const (
 	ShutterClosingState = "closing"
 )
 
-type ShutterUpdateHandler func(state string, position int)
-
 type Shutter interface {
 	Name() string
 	FullOpenPosition() int
@@ -21,7 +19,8 @@ type Shutter interface {
 	Position() int
 	State() string
 
-	OnUpdate(h ShutterUpdateHandler)
+	// Subscribe registers a handler called asynchronously on every state or position change.
+	Subscribe(h EventHandler) (unsubscribe func())
 
 	Open(ctx context.Context) error
 	Close(ctx context.Context) error
@@ -34,3 +33,10 @@ type StatelessShutter interface {
 
 	ResetPosition(position int) error
 }
+
+// TruePositionReporter is implemented by shutters which know where they really are, unlike the estimated
+// Position, e.g. simulated ones. Events of SubscribeTruePosition carry the true position and state.
+type TruePositionReporter interface {
+	TruePosition() int
+	SubscribeTruePosition(h EventHandler) (unsubscribe func())
+}
@@ -0,0 +1,73 @@
+package shutter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SourceUnknown       = "unknown"
+	SourceMQTT          = "mqtt"
+	SourceHTTP          = "http"
+	SourceButton        = "button"
+	SourceWindowContact = "window_contact"
+	// SourceSystem marks commands issued by shutter2mqtt itself, e.g. on shutdown.
+	SourceSystem = "system"
+)
+
+type sourceKey struct{}
+
+// WithSource marks where a command comes from, e.g. for metrics and logs.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func SourceFromContext(ctx context.Context) string {
+	if source, ok := ctx.Value(sourceKey{}).(string); ok {
+		return source
+	}
+	return SourceUnknown
+}
+
+type originKey struct{}
+
+// WithOrigin adds a source specific detail, e.g. an MQTT topic or a button name.
+func WithOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+func OriginFromContext(ctx context.Context) string {
+	origin, _ := ctx.Value(originKey{}).(string)
+	return origin
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Logger enriches log with the command source and request ID carried by ctx.
+func Logger(ctx context.Context, log *logrus.Entry) *logrus.Entry {
+	fields := logrus.Fields{"source": SourceFromContext(ctx)}
+	if id := RequestIDFromContext(ctx); id != "" {
+		fields["request_id"] = id
+	}
+	return log.WithFields(fields)
+}
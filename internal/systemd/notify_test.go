@@ -0,0 +1,53 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotify(t *testing.T) {
+	t.Run("without socket nothing happens", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+		assert.NoError(t, Notify(Ready))
+	})
+
+	t.Run("state is sent to the socket", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "notify.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		t.Setenv("NOTIFY_SOCKET", socket)
+		assert.NoError(t, Notify(Ready))
+
+		buf := make([]byte, 64)
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, err := conn.Read(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, Ready, string(buf[:n]))
+	})
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+
+	t.Run("enabled for this process", func(t *testing.T) {
+		t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+		interval, err := WatchdogInterval()
+		assert.NoError(t, err)
+		assert.Equal(t, time.Second*30, interval)
+	})
+
+	t.Run("enabled for another process", func(t *testing.T) {
+		t.Setenv("WATCHDOG_PID", "1")
+		interval, err := WatchdogInterval()
+		assert.NoError(t, err)
+		assert.Zero(t, interval)
+	})
+}
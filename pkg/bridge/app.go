@@ -0,0 +1,653 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/health"
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
+	"github.com/jkaflik/shutter2mqtt/pkg/shutter"
+	"github.com/racerxdl/go-mcp23017"
+	"github.com/sirupsen/logrus"
+)
+
+const stepTimeout = time.Second * 5
+
+// defaultEndStopTolerance is how far from an end-stop, in positions, the motor may stop for the position
+// estimate to be taken as drifted rather than the motor obstructed.
+const defaultEndStopTolerance = 10
+
+// Config is a programmatic equivalent of the shutter2mqtt config file.
+type Config struct {
+	// MQTT client options. Run sets the availability will and wraps the connection handlers set,
+	// OnConnect is called on every connect, once the app subscribed its topics.
+	MQTT *paho.ClientOptions
+	// HomeAssistantTopicPrefix enables Home Assistant MQTT discovery, e.g. "homeassistant".
+	HomeAssistantTopicPrefix string
+
+	Shutters []Shutter
+	Inputs   []Input
+
+	// RelayPool limits relays enabled at once, 0 is unlimited.
+	RelayPool int
+	// RelayMaxOnTime is the hard limit relays can be enabled for, 0 disables the limit.
+	RelayMaxOnTime time.Duration
+	// RelayWatchdogInterval is how often RelayMaxOnTime is checked, defaults to a second.
+	RelayWatchdogInterval time.Duration
+	// InputPollInterval is how often input pins are read, defaults to 20ms.
+	InputPollInterval time.Duration
+
+	// Mcp23017 devices by ID, referenced by mcp23017 pins.
+	Mcp23017 map[int]Mcp23017
+
+	// Metrics collects metrics of shutters, relays and the MQTT connection, when set.
+	Metrics *Metrics
+	// OnMovement is called with every shutter movement, e.g. to keep an audit log.
+	OnMovement func(m shutter.Movement)
+
+	// Clock drives shutter positions, relay runs, the relay watchdog and input sampling, defaults to the system clock.
+	// Tests set a shutter.FakeClock to simulate travels instantly.
+	Clock shutter.Clock
+}
+
+type Shutter struct {
+	Name string
+	// Kind is a registered shutter kind, e.g. relays, and Driver its config.
+	Kind   string
+	Driver shutter.DriverConfig
+	// Shutter is bridged instead of one created from Kind and Driver, when set.
+	Shutter shutter.Shutter
+
+	// Metadata is published retained on shutter2mqtt/<name>/metadata.
+	Metadata      map[string]interface{}
+	WindowContact *WindowContact
+	// DebugTopics publishes shutter2mqtt/<name>/debug/true_position of simulated shutters.
+	DebugTopics bool
+	// PowerFeedback detects end-stops and obstructions of shutters supporting it, e.g. relays and simulated ones.
+	PowerFeedback *PowerFeedback
+}
+
+// PowerFeedback takes the motor as stopped once it draws less than Threshold watts. Readings come from Topic,
+// or from the shutter when empty, if it measures power. A stop within EndStopTolerance positions, 10 when not set,
+// from the end-stop it moves to resyncs the position, a stop further away is an obstruction.
+type PowerFeedback struct {
+	Topic            string
+	Threshold        float64
+	EndStopTolerance int
+}
+
+type WindowContact struct {
+	Topic         string
+	OpenPayload   string
+	ClosedPayload string
+	// Mode is shutter.WindowContactBlockMode (default) or shutter.WindowContactLimitMode.
+	Mode                string
+	VentilationPosition int
+}
+
+type Mcp23017 struct {
+	Bus          uint8
+	DeviceNumber uint8
+}
+
+// App runs shutters bridged to MQTT. Unlike the shutter2mqtt command, it keeps all its state,
+// so several apps can run within a process, e.g. with different brokers.
+type App struct {
+	cfg Config
+
+	pool     chan struct{}
+	watchdog *relay.Watchdog
+	health   *health.Checker
+
+	devicesL sync.Mutex
+	devices  map[int]*mcp23017.Device
+	mcp23017 map[int]Mcp23017
+
+	commands context.Context
+
+	l         sync.RWMutex
+	client    paho.Client
+	heartbeat *mqtt.Heartbeat
+	shutters  []*runningShutter
+	onAdd     []func(s shutter.Shutter)
+	onRemove  []func(s shutter.Shutter)
+	ready     chan struct{}
+
+	// reloadL serializes reloads, also with the shutdown
+	reloadL    sync.Mutex
+	inputs     []Input
+	stopInputs context.CancelFunc
+}
+
+// runningShutter is a shutter created from a Shutter config, bridged once the MQTT broker is connected.
+type runningShutter struct {
+	cfg Shutter
+	// inner is the shutter of the config, s is the one bridged, e.g. a window contact guard wrapping it
+	inner  shutter.Shutter
+	guard  *shutter.WindowContactGuard
+	s      shutter.Shutter
+	bridge *mqtt.Bridge
+	// watched are the relays of the shutter supervised by the App watchdog
+	watched []relay.Watched
+
+	// releases stop what runs next to the bridge, e.g. power sensing or metrics
+	releases []func()
+}
+
+func (r *runningShutter) release() {
+	for _, release := range r.releases {
+		release()
+	}
+}
+
+func New(cfg Config) *App {
+	if cfg.RelayWatchdogInterval <= 0 {
+		cfg.RelayWatchdogInterval = time.Second
+	}
+	if cfg.InputPollInterval <= 0 {
+		cfg.InputPollInterval = time.Millisecond * 20
+	}
+
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+
+	a := &App{
+		cfg:      cfg,
+		watchdog: relay.NewWatchdog(cfg.RelayMaxOnTime),
+		devices:  map[int]*mcp23017.Device{},
+		mcp23017: cfg.Mcp23017,
+		inputs:   cfg.Inputs,
+		ready:    make(chan struct{}),
+	}
+	a.watchdog.SetClock(cfg.Clock)
+	if cfg.RelayPool > 0 {
+		a.pool = make(chan struct{}, cfg.RelayPool)
+	}
+	if cfg.Metrics != nil {
+		cfg.Metrics.ObserveWatchdog(a.watchdog)
+	}
+	a.health = a.healthChecker()
+	return a
+}
+
+// Ready is closed once shutters are created and their MQTT topics subscribed.
+func (a *App) Ready() <-chan struct{} {
+	return a.ready
+}
+
+// Shutters returns the running shutters, nil before Ready.
+func (a *App) Shutters() []shutter.Shutter {
+	var shutters []shutter.Shutter
+	for _, r := range a.runningShutters() {
+		shutters = append(shutters, r.s)
+	}
+	return shutters
+}
+
+// Shutter returns a running shutter by name, nil when there is none.
+func (a *App) Shutter(name string) shutter.Shutter {
+	if r := a.runningShutter(name); r != nil {
+		return r.s
+	}
+	return nil
+}
+
+// Client returns the MQTT client, nil before Run connects it.
+func (a *App) Client() paho.Client {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	return a.client
+}
+
+// OnChange registers handlers called for shutters added and removed, also for the ones Run creates.
+// Handlers are not called for shutters running already.
+func (a *App) OnChange(added, removed func(s shutter.Shutter)) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.onAdd = append(a.onAdd, added)
+	a.onRemove = append(a.onRemove, removed)
+}
+
+// SafeState drives all relays to their safe state, e.g. before a forced exit.
+func (a *App) SafeState() error {
+	return a.watchdog.SafeState()
+}
+
+// Run creates shutters, connects to the MQTT broker and handles commands until ctx is done.
+// Then it stops moving shutters, publishes their final positions and disconnects. An App runs once.
+func (a *App) Run(ctx context.Context) error {
+	if a.cfg.MQTT == nil {
+		return errors.New("MQTT client options not set")
+	}
+	defer a.closeDevices()
+
+	var shutters []*runningShutter
+	for _, cfg := range a.cfg.Shutters {
+		r, err := a.createShutter(cfg)
+		if err != nil {
+			return err
+		}
+		shutters = append(shutters, r)
+	}
+	// relays might be left energised by a killed process
+	if err := a.watchdog.SafeState(); err != nil {
+		logrus.Error(err)
+	}
+
+	commands, stopCommands := context.WithCancel(ctx)
+	defer stopCommands()
+	a.commands = commands
+
+	opts := *a.cfg.MQTT
+	a.handleConnection(mqtt.SetAvailabilityWill(&opts))
+	client := paho.NewClient(&opts)
+	a.l.Lock()
+	a.client = client
+	a.heartbeat = mqtt.NewHeartbeat(client)
+	a.l.Unlock()
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	for _, r := range shutters {
+		if err := a.createBridge(r); err != nil {
+			client.Disconnect(uint(stepTimeout / time.Millisecond))
+			return err
+		}
+	}
+	a.watchdog.OnViolation(func(v relay.Violation) {
+		if r := a.runningShutter(v.Shutter); r != nil {
+			r.bridge.PublishError(fmt.Errorf("%s: %s relay force disabled by watchdog after %s", v.Shutter, v.Relay, v.OnTime))
+		}
+	})
+	// not bound to ctx, relays are still supervised during shutdown
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	go a.watchdog.Run(watchdogCtx, a.cfg.RelayWatchdogInterval)
+
+	for _, r := range shutters {
+		a.subscribe(commands, r.bridge)
+	}
+	if err := a.startInputs(a.inputs, shutters); err != nil {
+		client.Disconnect(uint(stepTimeout / time.Millisecond))
+		return err
+	}
+	if err := mqtt.PublishAvailability(client, true, stepTimeout); err != nil {
+		logrus.Error(err)
+	}
+
+	a.l.Lock()
+	a.shutters = shutters
+	a.l.Unlock()
+	for _, r := range shutters {
+		a.notifyChange(r, true)
+	}
+	close(a.ready)
+	go a.beat(commands)
+
+	<-ctx.Done()
+	stopCommands()
+	a.reloadL.Lock()
+	defer a.reloadL.Unlock()
+	a.shutdown(a.runningShutters())
+	return nil
+}
+
+// handleConnection logs and counts connection changes of the client of opts, then calls the handlers set by the caller.
+func (a *App) handleConnection(opts *paho.ClientOptions) {
+	onConnect, onConnectionLost, onReconnecting := opts.OnConnect, opts.OnConnectionLost, opts.OnReconnecting
+
+	opts.SetOnConnectHandler(func(c paho.Client) {
+		logrus.Info("MQTT broker connected")
+		if a.cfg.Metrics != nil {
+			a.cfg.Metrics.MQTTConnected()
+		}
+		if err := a.clientHeartbeat().Subscribe(); err != nil {
+			logrus.Error(err)
+		}
+		if a.commands.Err() == nil && a.isReady() {
+			for _, r := range a.runningShutters() {
+				a.subscribe(a.commands, r.bridge)
+			}
+			// the will replaced online when the connection dropped
+			if err := mqtt.PublishAvailability(c, true, stepTimeout); err != nil {
+				logrus.Error(err)
+			}
+		}
+		if onConnect != nil {
+			onConnect(c)
+		}
+	})
+	opts.SetConnectionLostHandler(func(c paho.Client, err error) {
+		logrus.Errorf("MQTT broker connection lost: %s", err)
+		if a.cfg.Metrics != nil {
+			a.cfg.Metrics.MQTTConnectionLost()
+		}
+		a.clientHeartbeat().Reset()
+		if onConnectionLost != nil {
+			onConnectionLost(c, err)
+		}
+	})
+	opts.SetReconnectingHandler(func(c paho.Client, o *paho.ClientOptions) {
+		logrus.Info("MQTT broker reconnecting")
+		if a.cfg.Metrics != nil {
+			a.cfg.Metrics.MQTTReconnecting()
+		}
+		if onReconnecting != nil {
+			onReconnecting(c, o)
+		}
+	})
+}
+
+func (a *App) isReady() bool {
+	select {
+	case <-a.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *App) runningShutters() []*runningShutter {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	return a.shutters
+}
+
+func (a *App) runningShutter(name string) *runningShutter {
+	for _, r := range a.runningShutters() {
+		if r.cfg.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// notifyChange calls OnChange handlers for an added or removed shutter.
+func (a *App) notifyChange(r *runningShutter, added bool) {
+	a.l.RLock()
+	handlers := a.onRemove
+	if added {
+		handlers = a.onAdd
+	}
+	a.l.RUnlock()
+
+	for _, h := range handlers {
+		h(r.s)
+	}
+}
+
+// createShutter creates the shutter of cfg and wraps it, e.g. by a window contact guard.
+func (a *App) createShutter(cfg Shutter) (_ *runningShutter, err error) {
+	r := &runningShutter{cfg: cfg, inner: cfg.Shutter}
+	defer func() {
+		if err != nil {
+			a.watchdog.UnwatchRelays(r.watched...)
+		}
+	}()
+
+	if r.inner == nil {
+		r.inner, err = driver.NewShutter(appBuilder{a: a, r: r}, cfg.Kind, cfg.Name, cfg.Driver)
+		if err != nil {
+			return nil, err
+		}
+	}
+	a.recordMovements(r.inner)
+	r.s = r.inner
+
+	if wc := cfg.WindowContact; wc != nil {
+		if wc.Topic == "" {
+			return nil, fmt.Errorf("%s: window contact topic not set", cfg.Name)
+		}
+		mode := wc.Mode
+		if mode == "" {
+			mode = shutter.WindowContactBlockMode
+		}
+		r.guard, err = shutter.NewWindowContactGuard(r.inner, mode, wc.VentilationPosition)
+		if err != nil {
+			return nil, err
+		}
+		a.recordMovements(r.guard)
+		r.s = r.guard
+	}
+
+	if a.cfg.Metrics != nil {
+		var unsubscribe func()
+		r.s, unsubscribe = a.cfg.Metrics.InstrumentShutter(r.s)
+		r.releases = append(r.releases, unsubscribe)
+	}
+	return r, nil
+}
+
+func (a *App) recordMovements(s shutter.Shutter) {
+	if a.cfg.OnMovement == nil {
+		return
+	}
+	if r, ok := s.(shutter.MovementReporter); ok {
+		r.OnMovement(a.cfg.OnMovement)
+	}
+}
+
+// createBridge bridges a created shutter. The MQTT broker has to be connected, the position is restored
+// from the retained one.
+func (a *App) createBridge(r *runningShutter) (err error) {
+	b, err := mqtt.NewBridge(a.client, r.s)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			r.release()
+			if err := b.Close(stepTimeout); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}()
+
+	cfg := r.cfg
+	if tp, ok := r.inner.(shutter.TruePositionReporter); ok && cfg.DebugTopics {
+		b.SetTruePosition(tp)
+	}
+	if c, ok := r.inner.(shutter.Calibrator); ok {
+		if err := b.SetCalibrator(c); err != nil {
+			return err
+		}
+	}
+	if pf := cfg.PowerFeedback; pf != nil {
+		stop, err := sensePower(b, r.inner, *pf)
+		if err != nil {
+			return err
+		}
+		r.releases = append(r.releases, stop)
+	}
+	if wc := cfg.WindowContact; wc != nil {
+		openPayload, closedPayload := wc.OpenPayload, wc.ClosedPayload
+		if openPayload == "" {
+			openPayload = "open"
+		}
+		if closedPayload == "" {
+			closedPayload = "closed"
+		}
+		b.SetWindowContact(r.guard, wc.Topic, openPayload, closedPayload)
+	}
+	if err := b.SetMetadata(cfg.Metadata); err != nil {
+		return err
+	}
+
+	r.bridge = b
+	return nil
+}
+
+func (a *App) subscribe(ctx context.Context, b *mqtt.Bridge) {
+	if a.cfg.HomeAssistantTopicPrefix != "" {
+		if err := PublishHomeAssistantDiscovery(a.client, a.cfg.HomeAssistantTopicPrefix, b); err != nil {
+			logrus.Error(err)
+		}
+	}
+	if err := b.Subscribe(ctx); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// shutdown stops motors and flushes the final state before the MQTT connection is closed.
+func (a *App) shutdown(shutters []*runningShutter) {
+	logrus.Info("shutdown: stop shutters")
+	ctx := shutter.WithSource(context.Background(), shutter.SourceSystem)
+	for _, r := range shutters {
+		if state := r.s.State(); state != shutter.OpeningState && state != shutter.ClosingState {
+			continue
+		}
+		if err := r.s.Stop(ctx); err != nil {
+			logrus.WithField("shutter", r.cfg.Name).Errorf("shutdown: stop failed: %s", err)
+		}
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+	if err := a.watchdog.WaitOff(waitCtx); err != nil {
+		logrus.Errorf("shutdown: %s", err)
+	}
+	cancel()
+	if err := a.watchdog.SafeState(); err != nil {
+		logrus.Errorf("shutdown: %s", err)
+	}
+
+	logrus.Info("shutdown: persist positions")
+	for _, r := range shutters {
+		if err := r.bridge.PublishState(stepTimeout); err != nil {
+			logrus.Errorf("shutdown: %s", err)
+		}
+	}
+
+	logrus.Info("shutdown: disconnect MQTT")
+	if err := mqtt.PublishAvailability(a.client, false, stepTimeout); err != nil {
+		logrus.Errorf("shutdown: %s", err)
+	}
+	for _, r := range shutters {
+		if err := r.bridge.Close(stepTimeout); err != nil {
+			logrus.Errorf("shutdown: %s", err)
+		}
+	}
+	a.client.Disconnect(uint(stepTimeout / time.Millisecond))
+	for _, r := range shutters {
+		r.release()
+	}
+	logrus.Info("shutdown: done")
+}
+
+// closeDevices closes opened MCP23017 devices, relays have to be off already.
+func (a *App) closeDevices() {
+	a.devicesL.Lock()
+	defer a.devicesL.Unlock()
+
+	for id, dev := range a.devices {
+		if err := dev.Close(); err != nil {
+			logrus.Errorf("mcp23017: %d close failed %s", id, err)
+			continue
+		}
+		logrus.Infof("mcp23017: %d close", id)
+	}
+}
+
+// appBuilder builds drivers of an App, its relays are supervised by the App watchdog, instrumented
+// and share its pool.
+type appBuilder struct {
+	a *App
+	// r is the shutter built, its watched relays are recorded
+	r *runningShutter
+}
+
+func (b appBuilder) Relay(shutterName, relayName string, cfg driver.Config) (driver.Relay, error) {
+	r, err := driver.NewRelay(b, shutterName, relayName, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if w, ok := r.(relay.Watched); ok {
+		b.a.watchdog.Watch(shutterName, relayName, w)
+		if b.r != nil {
+			b.r.watched = append(b.r.watched, w)
+		}
+	}
+	metrics := b.a.cfg.Metrics
+	if metrics != nil {
+		r = metrics.InstrumentRelay(shutterName, relayName, r)
+	}
+	if b.a.pool != nil {
+		p := relay.NewPoolProxy(r, b.a.pool)
+		if metrics != nil {
+			metrics.ObservePoolProxy(shutterName, relayName, p)
+		}
+		return p, nil
+	}
+	return r, nil
+}
+
+func (b appBuilder) Pin(cfg driver.Config) (driver.Pin, error) {
+	return driver.NewPin(b, cfg)
+}
+
+func (b appBuilder) Clock() clock.Clock {
+	return b.a.cfg.Clock
+}
+
+// MaxOnTime is the max on-time of the App watchdog.
+func (b appBuilder) MaxOnTime() time.Duration {
+	return b.a.cfg.RelayMaxOnTime
+}
+
+func (b appBuilder) Mcp23017Device(id int) (*mcp23017.Device, error) {
+	b.a.devicesL.Lock()
+	defer b.a.devicesL.Unlock()
+
+	if dev := b.a.devices[id]; dev != nil {
+		return dev, nil
+	}
+
+	cfg, found := b.a.mcp23017[id]
+	if !found {
+		return nil, fmt.Errorf("mcp23017 %d not defined", id)
+	}
+	dev, err := mcp23017.Open(cfg.Bus, cfg.DeviceNumber)
+	if err != nil {
+		return nil, fmt.Errorf("mcp23017 %d: %s", id, err)
+	}
+	if err := dev.Reset(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("mcp23017 %d: %s", id, err)
+	}
+
+	b.a.devices[id] = dev
+	return dev, nil
+}
+
+func sensePower(b *mqtt.Bridge, s shutter.Shutter, pf PowerFeedback) (stop func(), err error) {
+	sensing, ok := s.(shutter.PowerSensing)
+	if !ok {
+		return nil, fmt.Errorf("%s: power feedback not supported", s.Name())
+	}
+
+	meter, _ := s.(shutter.PowerMeter)
+	if pf.Topic != "" {
+		b.SetPowerTopic(pf.Topic)
+		meter = b
+	}
+	if meter == nil {
+		return nil, fmt.Errorf("%s: power feedback topic not set and the shutter does not measure power", s.Name())
+	}
+
+	if pf.EndStopTolerance == 0 {
+		pf.EndStopTolerance = defaultEndStopTolerance
+	}
+	return sensing.SensePower(meter, pf.Threshold, pf.EndStopTolerance), nil
+}
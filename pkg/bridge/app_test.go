@@ -0,0 +1,88 @@
+package bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/pkg/relay"
+	"github.com/jkaflik/shutter2mqtt/pkg/shutter"
+	"github.com/stretchr/testify/assert"
+)
+
+func relaysDriverConfig(t *testing.T, up, down shutter.DriverConfig) shutter.DriverConfig {
+	t.Helper()
+
+	cfg, err := shutter.NewDriverConfig("", relay.ShutterConfig{
+		Up:               up,
+		Down:             down,
+		FullOpenPosition: 100,
+		TimeToClose:      time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestAppRunConfigErrors(t *testing.T) {
+	dumb, err := shutter.NewDriverConfig("dumb", relay.DumbConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wired, err := shutter.NewDriverConfig("wired", relay.WiredConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := paho.NewClientOptions().AddBroker("tcp://127.0.0.1:1").SetConnectTimeout(time.Millisecond * 100)
+
+	tests := map[string]struct {
+		cfg Config
+		err string
+	}{
+		"no MQTT options": {
+			cfg: Config{},
+			err: "MQTT client options not set",
+		},
+		"unknown shutter kind": {
+			cfg: Config{MQTT: opts, Shutters: []Shutter{{Name: "kitchen", Kind: "somfy"}}},
+			err: `kitchen: "somfy" is not supported shutter kind`,
+		},
+		"wired relay without pin": {
+			cfg: Config{MQTT: opts, Shutters: []Shutter{{Name: "kitchen", Kind: "relays", Driver: relaysDriverConfig(t, wired, dumb)}}},
+			err: `kitchen: up relay: "" is not supported pin kind`,
+		},
+		"mcp23017 device not defined": {
+			cfg: Config{MQTT: opts, Shutters: []Shutter{{Name: "kitchen", Kind: "relays", Driver: relaysDriverConfig(t, dumb, func() shutter.DriverConfig {
+				cfg, err := shutter.NewDriverConfig("wired", map[string]interface{}{"pin": map[string]interface{}{"kind": "mcp23017", "mcp23017": 1}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return cfg
+			}())}}},
+			err: "kitchen: down relay: mcp23017 1 not defined",
+		},
+		"window contact out of range": {
+			cfg: Config{MQTT: opts, Shutters: []Shutter{{
+				Name:          "kitchen",
+				Kind:          "relays",
+				Driver:        relaysDriverConfig(t, dumb, dumb),
+				WindowContact: &WindowContact{Topic: "contact", Mode: shutter.WindowContactLimitMode, VentilationPosition: 101},
+			}}},
+			err: "kitchen: ventilation position 101 is out of range open/close position for (100/0)",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.EqualError(t, New(tt.cfg).Run(context.Background()), tt.err)
+		})
+	}
+
+	t.Run("broker not reachable", func(t *testing.T) {
+		app := New(Config{MQTT: opts, Shutters: []Shutter{{Name: "kitchen", Kind: "relays", Driver: relaysDriverConfig(t, dumb, dumb)}}})
+		assert.Error(t, app.Run(context.Background()))
+		assert.Nil(t, app.Shutters())
+	})
+}
@@ -0,0 +1,43 @@
+// Package bridge connects shutters to an MQTT broker, the way shutter2mqtt does.
+//
+// New(cfg).Run(ctx) runs a complete bridge from a programmatic config. Bridge connects a single shutter
+// to an MQTT client managed by the caller. Like package shutter, its API is unstable.
+package bridge
+
+import (
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/internal/metrics"
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt"
+	"github.com/jkaflik/shutter2mqtt/pkg/shutter"
+)
+
+const (
+	AvailabilityTopic   = mqtt.AvailabilityTopic
+	AvailabilityOnline  = mqtt.AvailabilityOnline
+	AvailabilityOffline = mqtt.AvailabilityOffline
+)
+
+// Bridge publishes shutter state and position to shutter2mqtt/<name>/... topics and handles commands.
+type Bridge = mqtt.Bridge
+
+// NewBridge bridges s. A stateless shutter restores its position from the retained position topic.
+func NewBridge(client paho.Client, s shutter.Shutter) (*Bridge, error) {
+	return mqtt.NewBridge(client, s)
+}
+
+// Metrics collects metrics of shutters, relays and the MQTT connection of an App, it serves them over HTTP
+// in the Prometheus text format.
+type Metrics = metrics.Collector
+
+func NewMetrics() *Metrics {
+	return metrics.NewCollector()
+}
+
+// PublishHomeAssistantDiscovery publishes retained Home Assistant MQTT discovery configs of a cover entity
+// and of a problem binary sensor, on while the shutter has a fault.
+func PublishHomeAssistantDiscovery(client paho.Client, topicPrefix string, b *Bridge) error {
+	if err := mqtt.PublishHAAutoDiscovery(client, topicPrefix, mqtt.NewHACoverFromMQTTBridge(b)); err != nil {
+		return err
+	}
+	return mqtt.PublishHAProblemAutoDiscovery(client, topicPrefix, b)
+}
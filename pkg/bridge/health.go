@@ -0,0 +1,91 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/health"
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt"
+)
+
+const (
+	// heartbeatInterval is how often the MQTT client publishes a heartbeat to itself.
+	heartbeatInterval = time.Second * 5
+	// stalledAfter is how many intervals a loop may miss, before it is taken as stuck.
+	stalledAfter = 3
+)
+
+// HealthChecker runs liveness and readiness checks of an App, it serves them over HTTP.
+type HealthChecker = health.Checker
+
+// Health returns the checker of the App, e.g. to serve /healthz and /readyz.
+func (a *App) Health() *HealthChecker {
+	return a.health
+}
+
+func (a *App) healthChecker() *health.Checker {
+	checker := health.NewChecker()
+
+	// a message handler blocking the MQTT client stops commands, while the connection is fine
+	checker.AddLivenessCheck("mqtt", func() error {
+		if h := a.clientHeartbeat(); h != nil {
+			return h.Stalled(time.Now(), heartbeatInterval*stalledAfter)
+		}
+		return nil
+	})
+	checker.AddLivenessCheck("relay_watchdog", func() error {
+		return a.watchdog.Stalled(a.cfg.Clock.Now(), a.cfg.RelayWatchdogInterval*stalledAfter)
+	})
+
+	checker.AddReadinessCheck("mqtt", func() error {
+		if c := a.Client(); c == nil || !c.IsConnectionOpen() {
+			return errors.New("MQTT broker not connected")
+		}
+		return nil
+	})
+	checker.AddReadinessCheck("mqtt_subscriptions", func() error {
+		for _, r := range a.runningShutters() {
+			if !r.bridge.Subscribed() {
+				return fmt.Errorf("%s: MQTT topics not subscribed", r.cfg.Name)
+			}
+		}
+		return nil
+	})
+	checker.AddReadinessCheck("mcp23017", func() error {
+		a.devicesL.Lock()
+		defer a.devicesL.Unlock()
+
+		for id, dev := range a.devices {
+			if !dev.IsPresent() {
+				return fmt.Errorf("mcp23017 %d not present", id)
+			}
+		}
+		return nil
+	})
+
+	return checker
+}
+
+func (a *App) clientHeartbeat() *mqtt.Heartbeat {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	return a.heartbeat
+}
+
+// beat publishes MQTT heartbeats until ctx is done.
+func (a *App) beat(ctx context.Context) {
+	every := time.NewTicker(heartbeatInterval)
+	defer every.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-every.C:
+			a.clientHeartbeat().Beat(now)
+		}
+	}
+}
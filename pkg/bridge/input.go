@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/input"
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt"
+)
+
+// Input is a button wired to an mcp23017 pin. Its presses are published on shutter2mqtt/button/<name>
+// and move Shutter, when set.
+type Input struct {
+	Name string
+	// Mcp23017 is the ID of the device the button is wired to, Pin its pin.
+	Mcp23017  int
+	Pin       uint8
+	PullUp    bool
+	ActiveLow bool
+
+	Shutter string
+	// Direction is up or down, the button moves the shutter to PresetPosition when set.
+	Direction      string
+	PresetPosition *int
+
+	// LongPress and DoublePress default to the input.Button ones.
+	LongPress   time.Duration
+	DoublePress time.Duration
+}
+
+// startInputs replaces running inputs by the ones of cfgs, bound to shutters.
+// Running inputs are kept when one of cfgs can not be created.
+func (a *App) startInputs(cfgs []Input, shutters []*runningShutter) error {
+	ctx, cancel := context.WithCancel(a.commands)
+	buttons, err := a.createInputs(ctx, cfgs, shutters)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	if a.stopInputs != nil {
+		a.stopInputs()
+	}
+	a.stopInputs = cancel
+	for _, button := range buttons {
+		go button.Run(ctx, a.cfg.InputPollInterval)
+	}
+	return nil
+}
+
+func (a *App) createInputs(ctx context.Context, cfgs []Input, shutters []*runningShutter) ([]*input.Button, error) {
+	byName := map[string]*runningShutter{}
+	for _, r := range shutters {
+		byName[r.cfg.Name] = r
+	}
+
+	var buttons []*input.Button
+	for _, cfg := range cfgs {
+		device, err := appBuilder{a: a}.Mcp23017Device(cfg.Mcp23017)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", cfg.Name, err)
+		}
+		pin, err := input.NewMcp23017Pin(device, cfg.Pin, cfg.PullUp, cfg.ActiveLow)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", cfg.Name, err)
+		}
+
+		button := input.NewButton(cfg.Name, pin)
+		button.Clock = a.cfg.Clock
+		if cfg.LongPress > 0 {
+			button.LongPress = cfg.LongPress
+		}
+		if cfg.DoublePress > 0 {
+			button.DoublePress = cfg.DoublePress
+		}
+
+		if cfg.Shutter != "" {
+			r, found := byName[cfg.Shutter]
+			if !found {
+				return nil, fmt.Errorf("%s: %s is not defined shutter", cfg.Name, cfg.Shutter)
+			}
+
+			action, err := input.NewShutterAction(r.s, cfg.Direction, cfg.PresetPosition)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", cfg.Name, err)
+			}
+			if c := r.bridge.Calibrator(); c != nil {
+				action.SetCalibrator(c)
+			}
+			button.OnEvent(action.Handler(ctx))
+		}
+
+		mqtt.NewButtonBridge(a.client, button)
+		buttons = append(buttons, button)
+	}
+	return buttons, nil
+}
@@ -0,0 +1,238 @@
+package bridge
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt/mqtttest"
+	"github.com/jkaflik/shutter2mqtt/pkg/relay"
+	"github.com/jkaflik/shutter2mqtt/pkg/shutter"
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testAppConfig(t *testing.T, broker *mqtttest.Broker, clock shutter.Clock) Config {
+	dumb, err := shutter.NewDriverConfig("dumb", relay.DumbConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Config{
+		MQTT:                     paho.NewClientOptions().AddBroker(broker.URL()).SetClientID("shutter2mqtt"),
+		HomeAssistantTopicPrefix: "homeassistant",
+		Clock:                    clock,
+		Shutters: []Shutter{
+			{
+				Name:     "kitchen",
+				Kind:     "relays",
+				Driver:   relaysDriverConfig(t, dumb, dumb),
+				Metadata: map[string]interface{}{"floor": 1},
+			},
+			{
+				Name:          "patio",
+				Kind:          "relays",
+				Driver:        relaysDriverConfig(t, dumb, dumb),
+				WindowContact: &WindowContact{Topic: "zigbee2mqtt/patio_door"},
+			},
+		},
+	}
+}
+
+// runApp runs an App until the returned stop is called, which waits for a graceful shutdown.
+func runApp(t *testing.T, cfg Config) (app *App, stop func()) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	app = New(cfg)
+	done := make(chan error, 1)
+	go func() { done <- app.Run(ctx) }()
+
+	select {
+	case <-app.Ready():
+	case err := <-done:
+		t.Fatalf("app stopped: %v", err)
+	case <-time.After(mqtttest.DefaultTimeout):
+		t.Fatal("app not ready")
+	}
+
+	var stopped bool
+	stop = func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cancel()
+		assert.NoError(t, <-done)
+	}
+	t.Cleanup(stop)
+	return app, stop
+}
+
+func TestAppIntegration(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	broker.Publish("shutter2mqtt/kitchen/position", "30", true)
+	messages := broker.Subscribe("#")
+	clock := shutter.NewFakeClock(epoch)
+
+	app, stop := runApp(t, testAppConfig(t, broker, clock))
+	kitchen := app.Shutter("kitchen")
+
+	// travel advances the clock by d once a move started, kitchen travels 1% per 10ms
+	travel := func(d time.Duration) {
+		clock.BlockUntil(2) // relay run and position calculation ticker
+		clock.Advance(d)
+	}
+	// settled waits until a finished move released its timers, so the next travel waits for its own
+	settled := func(t *testing.T) {
+		assert.Eventually(t, func() bool { return clock.Waiters() == 0 }, time.Second, time.Millisecond)
+	}
+
+	t.Run("startup", func(t *testing.T) {
+		broker.WaitForRetained(t, AvailabilityTopic, AvailabilityOnline)
+		assert.NoError(t, app.Health().Live())
+		assert.NoError(t, app.Health().Ready())
+		broker.WaitForRetained(t, "shutter2mqtt/kitchen/metadata", `{"floor":1}`)
+		for _, name := range []string{"kitchen", "patio"} {
+			payload, found := broker.Retained("homeassistant/cover/shutters2mqtt/" + name + "/config")
+			assert.True(t, found, name)
+			assert.Contains(t, payload, `"cmd_t":"shutter2mqtt/`+name+`/set"`)
+		}
+		assert.Equal(t, 30, kitchen.Position(), "position restored from the retained one")
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		broker.Publish("shutter2mqtt/kitchen/set", "open", false)
+		messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.OpeningState)
+		travel(time.Millisecond * 700)
+		messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.OpenState)
+		broker.WaitForRetained(t, "shutter2mqtt/kitchen/position", "100")
+		settled(t)
+
+		broker.Publish("shutter2mqtt/kitchen/position/set", "20", false)
+		travel(time.Millisecond * 800)
+		messages.WaitFor(t, "shutter2mqtt/kitchen/position", "20")
+		assert.Equal(t, shutter.OpenState, kitchen.State())
+		settled(t)
+	})
+
+	t.Run("window contact", func(t *testing.T) {
+		broker.Publish("zigbee2mqtt/patio_door", "open", false)
+		broker.Publish("shutter2mqtt/patio/set", "close", false)
+		assert.Contains(t, messages.NextOn(t, "shutter2mqtt/patio/error").Payload, "window")
+		assert.NotEqual(t, shutter.ClosingState, app.Shutter("patio").State())
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		broker.DropClients()
+		messages.WaitFor(t, AvailabilityTopic, AvailabilityOffline)
+		messages.WaitFor(t, AvailabilityTopic, AvailabilityOnline)
+
+		messages.Drain()
+		broker.Publish("shutter2mqtt/kitchen/set", "close", false)
+		travel(time.Millisecond * 200)
+		messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.ClosedState)
+		settled(t)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		broker.Publish("shutter2mqtt/kitchen/set", "open", false)
+		messages.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.OpeningState)
+		clock.BlockUntil(2)
+		for position := 1; position <= 10; position++ {
+			clock.Advance(time.Millisecond * 10)
+			messages.WaitFor(t, "shutter2mqtt/kitchen/position", strconv.Itoa(position))
+		}
+		stop()
+
+		assert.Equal(t, shutter.OpenState, kitchen.State(), "stopped on shutdown")
+		position := kitchen.Position()
+		assert.Equal(t, 10, position)
+
+		payload, _ := broker.Retained(AvailabilityTopic)
+		assert.Equal(t, AvailabilityOffline, payload)
+		payload, _ = broker.Retained("shutter2mqtt/kitchen/position")
+		assert.Equal(t, strconv.Itoa(position), payload, "final position retained")
+		assert.Eventually(t, func() bool {
+			return broker.Connected() == 0
+		}, time.Second, time.Millisecond*10, "disconnected")
+	})
+
+	t.Run("restart restores positions", func(t *testing.T) {
+		app, _ := runApp(t, testAppConfig(t, broker, clock))
+		assert.Equal(t, kitchen.Position(), app.Shutter("kitchen").Position())
+	})
+}
+
+func TestAppReload(t *testing.T) {
+	broker := mqtttest.NewBroker(t)
+	cfg := testAppConfig(t, broker, nil)
+	app := New(cfg)
+	var added, removed []string
+	app.OnChange(
+		func(s shutter.Shutter) { added = append(added, s.Name()) },
+		func(s shutter.Shutter) { removed = append(removed, s.Name()) },
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- app.Run(ctx) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+	<-app.Ready()
+	assert.Equal(t, []string{"kitchen", "patio"}, added)
+	kitchen := app.Shutter("kitchen")
+
+	next := testAppConfig(t, broker, nil)
+	next.Shutters[1].Name = "bedroom"
+	next.Shutters[1].WindowContact = nil
+	assert.NoError(t, app.Reload(next))
+
+	assert.Same(t, kitchen, app.Shutter("kitchen"), "unchanged shutter kept running")
+	assert.Nil(t, app.Shutter("patio"))
+	assert.NotNil(t, app.Shutter("bedroom"))
+	assert.Equal(t, []string{"kitchen", "patio", "bedroom"}, added)
+	assert.Equal(t, []string{"patio"}, removed)
+	broker.WaitForRetained(t, "homeassistant/cover/shutters2mqtt/patio/config", "")
+	assert.Eventually(t, func() bool {
+		_, found := broker.Retained("homeassistant/cover/shutters2mqtt/bedroom/config")
+		return found
+	}, time.Second, time.Millisecond*10, "added shutter discovered")
+
+	t.Run("shutter not created", func(t *testing.T) {
+		broken := testAppConfig(t, broker, nil)
+		broken.Shutters[1].Kind = "somfy"
+		assert.EqualError(t, app.Reload(broken), `patio: "somfy" is not supported shutter kind`)
+		assert.Same(t, kitchen, app.Shutter("kitchen"))
+		assert.Nil(t, app.Shutter("bedroom"))
+	})
+
+	t.Run("changed shutter not created keeps running", func(t *testing.T) {
+		broken := testAppConfig(t, broker, nil)
+		broken.Shutters[0].PowerFeedback = &PowerFeedback{}
+		assert.EqualError(t, app.Reload(broken), "kitchen: power feedback topic not set and the shutter does not measure power")
+		assert.Same(t, kitchen, app.Shutter("kitchen"))
+		assert.NotContains(t, removed, "kitchen")
+
+		states := broker.Subscribe("shutter2mqtt/kitchen/state")
+		broker.Publish("shutter2mqtt/kitchen/set", "open", false)
+		states.WaitFor(t, "shutter2mqtt/kitchen/state", shutter.OpeningState)
+		broker.Publish("shutter2mqtt/kitchen/set", "stop", false)
+		assert.Eventually(t, func() bool {
+			return kitchen.State() != shutter.OpeningState
+		}, time.Second, time.Millisecond*10, "stopped")
+	})
+
+	t.Run("changed shutter replaced", func(t *testing.T) {
+		next := testAppConfig(t, broker, nil)
+		next.Shutters[0].Metadata = map[string]interface{}{"floor": 2}
+		assert.NoError(t, app.Reload(next))
+		assert.NotSame(t, kitchen, app.Shutter("kitchen"))
+		assert.Contains(t, removed, "kitchen")
+		assert.Equal(t, kitchen.Position(), app.Shutter("kitchen").Position(), "position restored")
+	})
+}
@@ -0,0 +1,185 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/jkaflik/shutter2mqtt/internal/mqtt"
+	"github.com/jkaflik/shutter2mqtt/pkg/shutter"
+	"github.com/sirupsen/logrus"
+)
+
+// Reload applies Shutters, Inputs and Mcp23017 of cfg to the running app, other fields require a restart.
+// Shutters with an unchanged config keep running, also when moving. Removed and changed ones get stopped,
+// changed ones are created again and restore their position like on a start. Opened MCP23017 devices keep
+// their settings. Shutters and inputs which can not be created are reported in the returned error,
+// changed shutters and inputs keep running with the previous config then.
+func (a *App) Reload(cfg Config) error {
+	a.reloadL.Lock()
+	defer a.reloadL.Unlock()
+
+	if !a.isReady() || a.commands.Err() != nil {
+		return errors.New("app not running")
+	}
+
+	a.devicesL.Lock()
+	for id := range a.devices {
+		if !reflect.DeepEqual(a.mcp23017[id], cfg.Mcp23017[id]) {
+			logrus.Warnf("mcp23017 %d: changes require a restart", id)
+		}
+	}
+	a.mcp23017 = cfg.Mcp23017
+	a.devicesL.Unlock()
+
+	var errs []string
+	changed, shutters := a.applyShutters(cfg.Shutters, &errs)
+	if changed || !reflect.DeepEqual(a.inputs, cfg.Inputs) {
+		if err := a.startInputs(cfg.Inputs, shutters); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			a.inputs = cfg.Inputs
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// applyShutters makes running shutters match cfgs. Shutters which can not be created are added to errs,
+// a changed shutter keeps running then.
+func (a *App) applyShutters(cfgs []Shutter, errs *[]string) (changed bool, shutters []*runningShutter) {
+	desired := map[string]Shutter{}
+	for _, cfg := range cfgs {
+		desired[cfg.Name] = cfg
+	}
+
+	running := map[string]*runningShutter{}
+	replaced := map[string]*runningShutter{}
+	var kept []*runningShutter
+	for _, r := range a.runningShutters() {
+		cfg, found := desired[r.cfg.Name]
+		switch {
+		case !found:
+			changed = true
+			a.remove(r, true)
+			continue
+		case reflect.DeepEqual(cfg, r.cfg):
+			running[r.cfg.Name] = r
+		default:
+			replaced[r.cfg.Name] = r
+		}
+		kept = append(kept, r)
+	}
+	a.l.Lock()
+	a.shutters = kept
+	a.l.Unlock()
+
+	for _, cfg := range cfgs {
+		if r, found := running[cfg.Name]; found {
+			shutters = append(shutters, r)
+			continue
+		}
+
+		changed = true
+		old := replaced[cfg.Name]
+		if old != nil {
+			a.halt(old)
+		}
+		r, err := a.replacement(cfg)
+		if err != nil {
+			log := logrus.WithField("shutter", cfg.Name)
+			log.Errorf("shutter not created: %s", err)
+			*errs = append(*errs, err.Error())
+			if old != nil {
+				log.Warn("shutter kept running with the previous config")
+				shutters = append(shutters, old)
+			}
+			continue
+		}
+		if old != nil {
+			a.discard(old, false)
+		}
+		if a.client.IsConnectionOpen() { // subscribed on connect otherwise
+			a.subscribe(a.commands, r.bridge)
+		}
+
+		logrus.WithField("shutter", cfg.Name).Info("shutter created")
+		shutters = append(shutters, r)
+		a.l.Lock()
+		a.shutters = append(a.shutters, r)
+		a.l.Unlock()
+		a.notifyChange(r, true)
+	}
+
+	a.l.Lock()
+	a.shutters = shutters
+	a.l.Unlock()
+	return changed, shutters
+}
+
+// replacement creates a shutter and its bridge, next to a running one of the same name it replaces.
+// Nothing of it is left running on error.
+func (a *App) replacement(cfg Shutter) (*runningShutter, error) {
+	r, err := a.createShutter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.createBridge(r); err != nil {
+		a.watchdog.UnwatchRelays(r.watched...)
+		return nil, err
+	}
+	return r, nil
+}
+
+// remove stops a running shutter and its bridge. Its discovery is deleted from Home Assistant, unless
+// it gets replaced.
+func (a *App) remove(r *runningShutter, deleteDiscovery bool) {
+	a.halt(r)
+	a.discard(r, deleteDiscovery)
+}
+
+// halt stops a running shutter and publishes its state, it keeps running in case its replacement fails.
+func (a *App) halt(r *runningShutter) {
+	log := logrus.WithField("shutter", r.cfg.Name)
+
+	if state := r.s.State(); state == shutter.OpeningState || state == shutter.ClosingState {
+		if err := r.s.Stop(shutter.WithSource(context.Background(), shutter.SourceSystem)); err != nil {
+			log.Errorf("stop failed: %s", err)
+		}
+	}
+
+	// relays are released before a replacement drives the same pins
+	ctx, cancel := context.WithTimeout(context.Background(), stepTimeout)
+	if err := a.watchdog.WaitOff(ctx, r.cfg.Name); err != nil {
+		log.Error(err)
+	}
+	cancel()
+
+	// a replacement restores the position from the retained one
+	if err := r.bridge.PublishState(stepTimeout); err != nil {
+		log.Error(err)
+	}
+}
+
+// discard closes the bridge of a halted shutter and releases what runs next to it.
+func (a *App) discard(r *runningShutter, deleteDiscovery bool) {
+	log := logrus.WithField("shutter", r.cfg.Name)
+
+	a.watchdog.UnwatchRelays(r.watched...)
+	if err := r.bridge.Close(stepTimeout); err != nil {
+		log.Error(err)
+	}
+	r.release()
+	if deleteDiscovery && a.cfg.HomeAssistantTopicPrefix != "" {
+		if err := mqtt.DeleteHAAutoDiscovery(a.client, a.cfg.HomeAssistantTopicPrefix, r.cfg.Name); err != nil {
+			log.Errorf("HA discovery delete failed: %s", err)
+		}
+	}
+
+	log.Info("shutter removed")
+	a.notifyChange(r, false)
+}
@@ -0,0 +1,90 @@
+// Package relay is the public relays shutter driver of shutter2mqtt: a shutter moved by an up and a down relay.
+// Types are aliases, so values are interchangeable with the ones shutter2mqtt uses internally.
+// Like package shutter, its API is unstable.
+package relay
+
+import (
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/relay"
+	"github.com/racerxdl/go-mcp23017"
+)
+
+type (
+	Relay  = relay.Relay
+	SetPin = relay.SetPin
+
+	Wired       = relay.Wired
+	Dumb        = relay.Dumb
+	Mcp23017Pin = relay.Mcp23017Pin
+	PoolProxy   = relay.PoolProxy
+	Wrapper     = relay.Wrapper
+
+	RelaysShutter     = relay.RelaysShutter
+	DutyCycleLimits   = relay.DutyCycleLimits
+	CoolingDownError  = relay.CoolingDownError
+	DutyCycleLimiter  = relay.DutyCycleLimiter
+	Watchdog          = relay.Watchdog
+	Watched           = relay.Watched
+	Violation         = relay.Violation
+	ShutterConfig     = relay.ShutterConfig
+	DutyCycleConfig   = relay.DutyCycleConfig
+	WiredConfig       = relay.WiredConfig
+	DumbConfig        = relay.DumbConfig
+	Mcp23017PinConfig = relay.Mcp23017PinConfig
+	Mcp23017Devices   = relay.Mcp23017Devices
+
+	FaultRecovery       = relay.FaultRecovery
+	FaultRecoveryConfig = relay.FaultRecoveryConfig
+)
+
+// ErrForceDisabled is returned by a run aborted by a Watchdog.
+var ErrForceDisabled = relay.ErrForceDisabled
+
+// ErrNotWatched is returned by ForceDisable for a relay which can not be driven to its safe state.
+var ErrNotWatched = relay.ErrNotWatched
+
+// DefaultFaultRecovery is how a relays shutter recovers from a relay fault unless SetFaultRecovery is called.
+var DefaultFaultRecovery = relay.DefaultFaultRecovery
+
+func NewRelaysShutter(name string, up, down Relay, fullOpenPosition, fullClosePosition int, timeToClose time.Duration) *RelaysShutter {
+	return relay.NewRelaysShutter(name, up, down, fullOpenPosition, fullClosePosition, timeToClose)
+}
+
+func NewMcp23017Pin(device *mcp23017.Device, pin uint8) (*Mcp23017Pin, error) {
+	return relay.NewMcp23017Pin(device, pin)
+}
+
+// NewPoolProxy limits relays enabled at once to the capacity of pool, shared by all proxies.
+func NewPoolProxy(r Relay, pool chan struct{}) *PoolProxy {
+	return relay.NewPoolProxy(r, pool)
+}
+
+// ForceDisable drives r, or the relay it wraps, to its safe state when it is Watched.
+// It returns ErrNotWatched for other relays.
+func ForceDisable(r Relay) error {
+	return relay.ForceDisable(r)
+}
+
+// NewWatchdog force disables watched relays enabled for longer than maxOnTime.
+func NewWatchdog(maxOnTime time.Duration) *Watchdog {
+	return relay.NewWatchdog(maxOnTime)
+}
+
+type (
+	// Driver creates relays of a kind, see RegisterDriver.
+	Driver = driver.RelayDriver
+	// PinDriver creates pins of a kind, see RegisterPinDriver.
+	PinDriver = driver.PinDriver
+)
+
+// RegisterDriver makes a relay kind available to configs. It panics when kind is registered twice.
+func RegisterDriver(kind string, d Driver) {
+	driver.RegisterRelay(kind, d)
+}
+
+// RegisterPinDriver makes a pin kind available to configs. It panics when kind is registered twice.
+func RegisterPinDriver(kind string, d PinDriver) {
+	driver.RegisterPin(kind, d)
+}
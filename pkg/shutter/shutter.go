@@ -0,0 +1,147 @@
+// Package shutter is the public shutter model of shutter2mqtt, for embedding it in other Go programs.
+// Types are aliases, so values are interchangeable with the ones shutter2mqtt uses internally.
+//
+// The API is unstable. Aliased types change together with shutter2mqtt internals, so any release
+// of the module may break packages under pkg.
+package shutter
+
+import (
+	"context"
+	"time"
+
+	"github.com/jkaflik/shutter2mqtt/internal/clock"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver"
+	"github.com/jkaflik/shutter2mqtt/internal/shutter/driver/simulated"
+)
+
+const (
+	OpenState    = shutter.ShutterOpenState
+	ClosedState  = shutter.ShutterClosedState
+	OpeningState = shutter.ShutterOpeningState
+	ClosingState = shutter.ShutterClosingState
+
+	DirectionNone = shutter.DirectionNone
+	DirectionUp   = shutter.DirectionUp
+	DirectionDown = shutter.DirectionDown
+
+	SourceUnknown       = shutter.SourceUnknown
+	SourceMQTT          = shutter.SourceMQTT
+	SourceHTTP          = shutter.SourceHTTP
+	SourceButton        = shutter.SourceButton
+	SourceWindowContact = shutter.SourceWindowContact
+	SourceSystem        = shutter.SourceSystem
+
+	OutcomeCompleted  = shutter.OutcomeCompleted
+	OutcomeCancelled  = shutter.OutcomeCancelled
+	OutcomeSuperseded = shutter.OutcomeSuperseded
+	OutcomeBlocked    = shutter.OutcomeBlocked
+	OutcomeError      = shutter.OutcomeError
+	OutcomeObstructed = shutter.OutcomeObstructed
+
+	FaultRelay      = shutter.FaultRelay
+	FaultWatchdog   = shutter.FaultWatchdog
+	FaultObstructed = shutter.FaultObstructed
+
+	WindowContactBlockMode = shutter.WindowContactBlockMode
+	WindowContactLimitMode = shutter.WindowContactLimitMode
+)
+
+type (
+	Shutter          = shutter.Shutter
+	StatelessShutter = shutter.StatelessShutter
+
+	Event        = shutter.Event
+	EventHandler = shutter.EventHandler
+	// Notifier delivers events to subscribers, for Shutter implementations.
+	Notifier = shutter.Notifier
+
+	Movement         = shutter.Movement
+	MovementHandler  = shutter.MovementHandler
+	MovementReporter = shutter.MovementReporter
+
+	WindowContactGuard = shutter.WindowContactGuard
+	WindowContactError = shutter.WindowContactError
+
+	TruePositionReporter = shutter.TruePositionReporter
+
+	Calibration = shutter.Calibration
+	Calibrator  = shutter.Calibrator
+
+	PowerHandler  = shutter.PowerHandler
+	PowerMeter    = shutter.PowerMeter
+	PowerSensing  = shutter.PowerSensing
+	PowerNotifier = shutter.PowerNotifier
+
+	Fault         = shutter.Fault
+	FaultReporter = shutter.FaultReporter
+)
+
+// NewWindowContactGuard blocks s, or limits it to the ventilation position, while a window is open.
+func NewWindowContactGuard(s Shutter, mode string, ventilationPosition int) (*WindowContactGuard, error) {
+	return shutter.NewWindowContactGuard(s, mode, ventilationPosition)
+}
+
+// WithSource marks where a command comes from, e.g. for metrics and logs.
+func WithSource(ctx context.Context, source string) context.Context {
+	return shutter.WithSource(ctx, source)
+}
+
+func SourceFromContext(ctx context.Context) string {
+	return shutter.SourceFromContext(ctx)
+}
+
+// WithOrigin adds a source specific detail, e.g. an MQTT topic or a button name.
+func WithOrigin(ctx context.Context, origin string) context.Context {
+	return shutter.WithOrigin(ctx, origin)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return shutter.WithRequestID(ctx, id)
+}
+
+type (
+	// DriverConfig is a driver config section, see RegisterDriver.
+	DriverConfig = driver.Config
+	// DriverBuilder builds relays and pins of any registered kind for a shutter driver.
+	DriverBuilder = driver.Builder
+	Driver        = driver.ShutterDriver
+	// DriverConfigValidator is implemented by driver config types checking their values, see driver.Validator.
+	DriverConfigValidator = driver.Validator
+	// DriverFieldErrors are problems with values of a driver config section, returned by Validate.
+	DriverFieldErrors = driver.FieldErrors
+)
+
+// NewDriverConfig returns a driver config section of kind with values of v, e.g. a driver config struct.
+func NewDriverConfig(kind string, v interface{}) (DriverConfig, error) {
+	return driver.NewConfig(kind, v)
+}
+
+// RegisterDriver makes a shutter kind available to configs. It panics when kind is registered twice.
+func RegisterDriver(kind string, d Driver) {
+	driver.RegisterShutter(kind, d)
+}
+
+type (
+	// SimulatedConfig is the driver config of simulated shutter kind, a shutter without hardware.
+	SimulatedConfig      = simulated.Config
+	SimulatedMotorConfig = simulated.MotorConfig
+	Simulated            = simulated.Shutter
+)
+
+// NewSimulated returns a shutter moving a simulated motor on clock c, the system clock when nil.
+func NewSimulated(name string, cfg SimulatedConfig, c Clock) *Simulated {
+	return simulated.New(name, cfg, c)
+}
+
+type (
+	// Clock is what shutter and relay timing runs on.
+	Clock = clock.Clock
+	// FakeClock is a virtual Clock for tests, time only passes on Advance.
+	FakeClock = clock.Fake
+)
+
+// NewFakeClock returns a FakeClock stopped at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return clock.NewFake(now)
+}